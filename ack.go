@@ -0,0 +1,524 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAckTimeout = time.Minute // time a Delivery has to be settled before it's requeued
+)
+
+var (
+	errDeliveryNacked      = errors.New("delivery is nacked")
+	errAckDeadlineExceeded = errors.New("ack deadline exceeded")
+)
+
+// Delivery is an event delivered to a CallbackDelivery callback. It's settled by Ack or Nack, possibly from another
+// goroutine, only the first one counts. A delivery that isn't settled before its ack deadline is requeued.
+type Delivery struct {
+	Event   *Event        // decoded event
+	Message kafka.Message // consumed message
+	Attempt int           // 1 for the first delivery, incremented by every requeue
+
+	tracker *ackTracker
+	retrier *retrier
+
+	// guarded by tracker.lock
+	deadline time.Time
+	settled  bool
+}
+
+// Ack acknowledges the event. Its offset is committed once the offsets before it are acknowledged too.
+func (delivery *Delivery) Ack() {
+	delivery.tracker.ack(delivery)
+}
+
+// Nack rejects the event. With requeue it's delivered again after the delay of the subscribe retry policy, until
+// the policy gives up. Otherwise, or once the policy gives up, it's published to the dead letter topic if the
+// subscriber has one and acknowledged.
+func (delivery *Delivery) Nack(requeue bool) {
+	delivery.tracker.nack(delivery, requeue, errDeliveryNacked)
+}
+
+// Extend postpones the ack deadline of the delivery by the ack timeout of the subscriber
+func (delivery *Delivery) Extend() {
+	delivery.tracker.extend(delivery)
+}
+
+// ackPartition is the watermark of a partition: the fetched offsets that aren't committed yet
+type ackPartition struct {
+	pending   []int64 // in fetch order
+	acked     map[int64]bool
+	committed int64 // highest committed offset, -1 if there's none
+}
+
+// newAckPartition creates the watermark of a partition without committed offset
+func newAckPartition() *ackPartition {
+	return &ackPartition{acked: make(map[int64]bool), committed: -1}
+}
+
+// add tracks a fetched offset, the offsets fetched again are already tracked
+func (partition *ackPartition) add(offset int64) {
+	if len(partition.pending) == 0 || offset > partition.pending[len(partition.pending)-1] {
+		partition.pending = append(partition.pending, offset)
+	}
+}
+
+// ack acknowledges an offset. It returns the highest contiguous acknowledged offset, false if it didn't move.
+func (partition *ackPartition) ack(offset int64) (int64, bool) {
+	i := sort.Search(len(partition.pending), func(i int) bool { return partition.pending[i] >= offset })
+	if i == len(partition.pending) || partition.pending[i] != offset {
+		// already committed
+		return 0, false
+	}
+
+	partition.acked[offset] = true
+
+	committed, ok := int64(0), false
+	for len(partition.pending) > 0 && partition.acked[partition.pending[0]] {
+		committed, ok = partition.pending[0], true
+		delete(partition.acked, committed)
+		partition.pending = partition.pending[1:]
+	}
+
+	if ok {
+		partition.committed = committed
+	}
+
+	return committed, ok
+}
+
+// topicPartition identifies a partition of a subscribed topic
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// requeuedDelivery is a nacked delivery waiting to be delivered again
+type requeuedDelivery struct {
+	delivery *Delivery
+	due      time.Time
+}
+
+// ackTracker delivers the events of a manual acknowledgement subscriber and commits the acknowledged offsets.
+// It also commits the offsets processed by the workers of a concurrent subscriber, see keyWorkers.
+// It lives as long as the reader it's created for, the deliveries settled afterwards are ignored.
+type ackTracker struct {
+	sub          *subscription
+	groupID      string
+	timeout      time.Duration
+	policy       *RetryPolicy
+	loggerFields *logrus.Entry
+	retryMetrics *kafkaprometheus.RetryCollector
+
+	// commit commits the offset of the message, publish publishes a message to a topic without prefix
+	commit  func(message kafka.Message)
+	publish func(topic string, message kafka.Message) error
+
+	lock       sync.Mutex
+	partitions map[topicPartition]*ackPartition
+	deliveries map[*Delivery]struct{} // not settled yet
+	requeued   []requeuedDelivery
+	nextDue    time.Time // no deadline or requeue is due before, zero if there's none
+	wake       context.CancelFunc
+	stopping   bool
+	closed     bool
+}
+
+// newAckTracker creates the tracker of a manual acknowledgement or concurrent subscriber
+func newAckTracker(sub *subscription, groupID string, policy *RetryPolicy, loggerFields *logrus.Entry) *ackTracker {
+	timeout := sub.builder.ackTimeout
+	if timeout <= 0 {
+		timeout = defaultAckTimeout
+	}
+
+	return &ackTracker{
+		sub:          sub,
+		groupID:      groupID,
+		timeout:      timeout,
+		policy:       policy,
+		loggerFields: loggerFields,
+		partitions:   make(map[topicPartition]*ackPartition),
+		deliveries:   make(map[*Delivery]struct{}),
+	}
+}
+
+// deliver tracks a fetched message and calls the callback with its delivery
+func (tracker *ackTracker) deliver(message kafka.Message) {
+	tracker.lock.Lock()
+	tracker.partition(message).add(message.Offset)
+	tracker.lock.Unlock()
+
+	tracker.dispatch(message, 1, nil)
+}
+
+// track tracks a fetched message of a concurrent subscriber. It returns false if its offset is already committed.
+func (tracker *ackTracker) track(message kafka.Message) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	partition := tracker.partition(message)
+	if message.Offset <= partition.committed {
+		return false
+	}
+
+	partition.add(message.Offset)
+
+	return true
+}
+
+// fetchedBefore returns true if the offset of the message is fetched again before it's committed, e.g. the reader
+// restarted from the committed offsets after a rebalance
+func (tracker *ackTracker) fetchedBefore(message kafka.Message) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	partition, ok := tracker.partitions[topicPartition{topic: message.Topic, partition: message.Partition}]
+
+	return ok && len(partition.pending) > 0 && message.Offset <= partition.pending[len(partition.pending)-1]
+}
+
+// partition returns the watermark of the partition of the message, tracker.lock must be held
+func (tracker *ackTracker) partition(message kafka.Message) *ackPartition {
+	key := topicPartition{topic: message.Topic, partition: message.Partition}
+
+	partition, ok := tracker.partitions[key]
+	if !ok {
+		partition = newAckPartition()
+		tracker.partitions[key] = partition
+	}
+
+	return partition
+}
+
+// dispatch calls the callback with a new delivery of the message
+func (tracker *ackTracker) dispatch(message kafka.Message, attempt int, retrier *retrier) {
+	builder := tracker.sub.builder
+
+	event, err := unmarshal(message)
+	if err != nil {
+		tracker.loggerFields.Error("unable to unmarshal message from subscribe in kafka: ", err)
+
+		// as retry will fail infinitely - ACK the event
+		tracker.ackOffset(message)
+		return
+	}
+
+	event.Topic = builder.eventTopic(message)
+	if !builder.subscribes(event) {
+		// don't send events if consumer didn't subscribe on their event name, or they're filtered out
+		tracker.ackOffset(message)
+		return
+	}
+
+	delivery := &Delivery{
+		Event:   event,
+		Message: message,
+		Attempt: attempt,
+		tracker: tracker,
+		retrier: retrier,
+	}
+
+	tracker.lock.Lock()
+	delivery.deadline = time.Now().Add(tracker.timeout)
+	tracker.deliveries[delivery] = struct{}{}
+	tracker.setNextDue(delivery.deadline)
+	tracker.lock.Unlock()
+
+	if err = builder.callbackDelivery(tracker.sub.ctx, delivery, nil); err != nil {
+		tracker.nack(delivery, true, err)
+	}
+}
+
+// ack settles the delivery and commits its offset once the offsets before it are acknowledged too
+func (tracker *ackTracker) ack(delivery *Delivery) {
+	tracker.lock.Lock()
+	if delivery.settled || tracker.closed {
+		tracker.lock.Unlock()
+		return
+	}
+
+	delivery.settled = true
+	delete(tracker.deliveries, delivery)
+	tracker.lock.Unlock()
+
+	tracker.ackOffset(delivery.Message)
+	tracker.wakeSettled()
+}
+
+// ackOffset commits the offset of the message once the offsets before it are acknowledged too
+func (tracker *ackTracker) ackOffset(message kafka.Message) {
+	tracker.lock.Lock()
+	if tracker.closed {
+		tracker.lock.Unlock()
+		return
+	}
+
+	committed, ok := int64(0), false
+	key := topicPartition{topic: message.Topic, partition: message.Partition}
+	if partition, exists := tracker.partitions[key]; exists {
+		committed, ok = partition.ack(message.Offset)
+	}
+	tracker.lock.Unlock()
+
+	if ok {
+		tracker.commit(kafka.Message{Topic: message.Topic, Partition: message.Partition, Offset: committed})
+	}
+}
+
+// nack settles the delivery. It's requeued until the retry policy gives up, then dead lettered and acknowledged.
+func (tracker *ackTracker) nack(delivery *Delivery, requeue bool, err error) {
+	tracker.lock.Lock()
+	if delivery.settled || tracker.closed {
+		tracker.lock.Unlock()
+		return
+	}
+
+	delivery.settled = true
+	delete(tracker.deliveries, delivery)
+	tracker.sub.failed(err)
+
+	if requeue && tracker.stopping {
+		// the event isn't committed, it's delivered again once the subscriber restarts
+		tracker.lock.Unlock()
+		tracker.wakeSettled()
+
+		return
+	}
+
+	attempt := delivery.Attempt
+	if requeue {
+		if delivery.retrier == nil {
+			delivery.retrier = newRetrier(tracker.policy)
+		}
+
+		delay, ok := delivery.retrier.next(err)
+		attempt = delivery.retrier.attempt
+
+		if ok {
+			due := time.Now().Add(delay)
+			tracker.requeued = append(tracker.requeued, requeuedDelivery{delivery: delivery, due: due})
+			wake := tracker.setNextDue(due)
+			tracker.lock.Unlock()
+
+			wake()
+			tracker.retryMetrics.ObserveRetry(retryOperationSubscribe, delivery.Message.Topic, attempt)
+			tracker.loggerFields.WithField("Attempt", attempt).Error("unable to process the event: ", err)
+
+			return
+		}
+	}
+	tracker.lock.Unlock()
+
+	defer tracker.wakeSettled()
+
+	_ = tracker.giveUp(delivery.Message, attempt, err)
+}
+
+// giveUp publishes the message given up after attempt to the dead letter topic if the subscriber has one,
+// and acknowledges it. It isn't acknowledged if it can't be published, so it's delivered again once the subscriber
+// restarts.
+func (tracker *ackTracker) giveUp(message kafka.Message, attempt int, err error) error {
+	tracker.loggerFields.WithField("Attempt", attempt).Error("giving up processing the event: ", err)
+
+	if deadLetterTopic := tracker.sub.builder.deadLetterTopicName(); deadLetterTopic != "" {
+		letter := deadLetter{
+			topic:     message.Topic,
+			groupID:   tracker.groupID,
+			partition: message.Partition,
+			offset:    message.Offset,
+			attempts:  attempt,
+			err:       err,
+		}
+
+		if errPublish := tracker.publish(deadLetterTopic, newDeadLetterMessage(message, letter)); errPublish != nil {
+			return errPublish
+		}
+	}
+
+	tracker.ackOffset(message)
+
+	return nil
+}
+
+// extend postpones the ack deadline of the delivery
+func (tracker *ackTracker) extend(delivery *Delivery) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	if !delivery.settled {
+		delivery.deadline = time.Now().Add(tracker.timeout)
+	}
+}
+
+// setNextDue moves the next due time earlier if needed. It returns the function waking up the consumer waiting
+// for the previous one. tracker.lock must be held
+func (tracker *ackTracker) setNextDue(due time.Time) func() {
+	if !tracker.nextDue.IsZero() && !due.Before(tracker.nextDue) {
+		return func() {}
+	}
+
+	tracker.nextDue = due
+
+	if tracker.wake == nil {
+		return func() {}
+	}
+
+	return tracker.wake
+}
+
+// wakeSettled wakes up the consumer waiting for the deliveries to be settled while stopping
+func (tracker *ackTracker) wakeSettled() {
+	tracker.lock.Lock()
+	wake := tracker.wake
+	stopping := tracker.stopping
+	tracker.lock.Unlock()
+
+	if stopping && wake != nil {
+		wake()
+	}
+}
+
+// waitContext returns a context done once a requeued delivery or an ack deadline is due, or when parent is done.
+// The consumer fetches the next message with it.
+func (tracker *ackTracker) waitContext(parent context.Context) (context.Context, context.CancelFunc) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	return tracker.waitContextLocked(parent)
+}
+
+// waitContextLocked is waitContext with tracker.lock held
+func (tracker *ackTracker) waitContextLocked(parent context.Context) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if tracker.nextDue.IsZero() {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithDeadline(parent, tracker.nextDue)
+	}
+
+	tracker.wake = cancel
+
+	return ctx, cancel
+}
+
+// processDue requeues the deliveries past their ack deadline and delivers again the requeued ones that are due
+func (tracker *ackTracker) processDue() {
+	now := time.Now()
+
+	tracker.lock.Lock()
+	if tracker.nextDue.IsZero() || now.Before(tracker.nextDue) {
+		tracker.lock.Unlock()
+		return
+	}
+
+	nextDue := time.Time{}
+	setNextDue := func(due time.Time) {
+		if nextDue.IsZero() || due.Before(nextDue) {
+			nextDue = due
+		}
+	}
+
+	var expired []*Delivery
+	for delivery := range tracker.deliveries {
+		if delivery.deadline.After(now) {
+			setNextDue(delivery.deadline)
+			continue
+		}
+		expired = append(expired, delivery)
+	}
+
+	var due []*Delivery
+	requeued := tracker.requeued[:0]
+	for _, requeuedDelivery := range tracker.requeued {
+		if requeuedDelivery.due.After(now) {
+			setNextDue(requeuedDelivery.due)
+			requeued = append(requeued, requeuedDelivery)
+			continue
+		}
+		due = append(due, requeuedDelivery.delivery)
+	}
+	tracker.requeued = requeued
+	tracker.nextDue = nextDue
+	tracker.lock.Unlock()
+
+	for _, delivery := range expired {
+		tracker.nack(delivery, true, errAckDeadlineExceeded)
+	}
+
+	for _, delivery := range due {
+		tracker.dispatch(delivery.Message, delivery.retrier.attempt+1, delivery.retrier)
+	}
+}
+
+// drain waits until the deliveries are settled or past their ack deadline, or until ctx is done.
+// The requeued deliveries aren't delivered again, they aren't committed.
+func (tracker *ackTracker) drain(ctx context.Context) {
+	tracker.lock.Lock()
+	tracker.stopping = true
+	tracker.requeued = nil
+	tracker.lock.Unlock()
+
+	for {
+		tracker.lock.Lock()
+		if len(tracker.deliveries) == 0 {
+			tracker.lock.Unlock()
+			return
+		}
+
+		// the context is created with the lock held, so a delivery settled afterwards wakes it up
+		waitCtx, cancelWait := tracker.waitContextLocked(ctx)
+		tracker.lock.Unlock()
+
+		<-waitCtx.Done()
+		cancelWait()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		tracker.processDue()
+	}
+}
+
+// reset forgets the offsets of the drained tracker, so the offsets fetched again after a seek are tracked again
+func (tracker *ackTracker) reset() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.stopping = false
+	tracker.partitions = make(map[topicPartition]*ackPartition)
+}
+
+// close ignores the deliveries settled afterwards, their offsets can't be committed by the closed reader
+func (tracker *ackTracker) close() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.closed = true
+}
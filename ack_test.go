@@ -0,0 +1,151 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckPartitionWatermark(t *testing.T) {
+	t.Parallel()
+
+	partition := newAckPartition()
+	for offset := int64(0); offset < 5; offset++ {
+		partition.add(offset)
+	}
+	partition.add(2) // fetched again
+
+	testCases := []struct {
+		ack       int64
+		committed int64
+		ok        bool
+	}{
+		{ack: 1},
+		{ack: 0, committed: 1, ok: true},
+		{ack: 3},
+		{ack: 1},
+		{ack: 2, committed: 3, ok: true},
+		{ack: 2},
+		{ack: 4, committed: 4, ok: true},
+	}
+
+	for _, testCase := range testCases {
+		committed, ok := partition.ack(testCase.ack)
+		assert.Equal(t, testCase.ok, ok, "ack %d", testCase.ack)
+		assert.Equal(t, testCase.committed, committed, "ack %d", testCase.ack)
+	}
+
+	assert.Empty(t, partition.pending)
+	assert.Empty(t, partition.acked)
+}
+
+func TestSubscribeManualAck(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	groupID := generateID()
+	createTestTopic(t, topicName)
+
+	for i := 0; i < 3; i++ {
+		err := client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+	}
+
+	deliveries := make(chan *Delivery, 10)
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			AckTimeout(500 * time.Millisecond).
+			RetryPolicy(&RetryPolicy{InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			CallbackDelivery(func(ctx context.Context, delivery *Delivery, err error) error {
+				if delivery != nil {
+					deliveries <- delivery
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	nextDelivery := func() *Delivery {
+		select {
+		case delivery := <-deliveries:
+			return delivery
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+			return nil
+		}
+	}
+
+	// the first event isn't settled before its ack deadline, the others are acknowledged out of order
+	first := nextDelivery()
+	assert.Equal(t, 0, first.Event.EventID)
+	second, third := nextDelivery(), nextDelivery()
+	third.Ack()
+	second.Ack()
+
+	redelivery := nextDelivery()
+	assert.Equal(t, 0, redelivery.Event.EventID)
+	assert.Equal(t, 2, redelivery.Attempt, "the expired delivery should be requeued")
+	first.Ack() // ignored, the delivery is already expired
+
+	redelivery.Nack(true)
+	redelivery = nextDelivery()
+	assert.Equal(t, 0, redelivery.Event.EventID)
+	assert.Equal(t, 3, redelivery.Attempt, "the nacked delivery should be requeued")
+	redelivery.Extend()
+	redelivery.Ack()
+
+	require.NoError(t, subscription.Unsubscribe(ctx))
+
+	// the next subscriber of the group starts after the acknowledged events
+	err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(3))
+	require.NoError(t, err)
+
+	received := make(chan int, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event.EventID
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	select {
+	case eventID := <-received:
+		assert.Equal(t, 3, eventID, "the acknowledged events should be committed")
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// BatchResult is the result of an event published in a batch
+type BatchResult struct {
+	// Index of the publish builder in the batch
+	Index int
+
+	// Event is the constructed event, nil if the publish builder is invalid
+	Event *Event
+
+	// Err is the reason the event wasn't published, nil on success
+	Err error
+}
+
+// batchMessage is a message of a batch with the index of its publish builder
+type batchMessage struct {
+	index   int
+	event   *Event
+	message kafka.Message
+	err     error
+
+	// write attempts and position of the message, see messageDelivery
+	attempts int
+	delivery *messageDelivery
+	spooled  bool
+
+	deadLettered bool
+}
+
+// batchGroup is the messages of a batch published to the same topic
+type batchGroup struct {
+	topic    string
+	messages []*batchMessage
+}
+
+// constructBatch validates the publish builders and constructs their events.
+// The messages are grouped by topic, in the order of the batch.
+func constructBatch(prefix string, strictValidation bool, publishBuilders []*PublishBuilder) (
+	[]BatchResult, []*batchGroup) {
+	results := make([]BatchResult, len(publishBuilders))
+	groups := make([]*batchGroup, 0)
+	groupByTopic := make(map[string]*batchGroup)
+
+	for i, publishBuilder := range publishBuilders {
+		results[i].Index = i
+
+		if publishBuilder == nil {
+			logrus.Error(errPubNilEvent)
+			results[i].Err = errPubNilEvent
+
+			continue
+		}
+
+		err := validatePublishEvent(publishBuilder, strictValidation)
+		if err != nil {
+			logrus.
+				WithField("Topic Name", publishBuilder.topic).
+				WithField("Event Name", publishBuilder.eventName).
+				Error("incorrect publisher event: ", err)
+			results[i].Err = err
+
+			continue
+		}
+
+		message, event, err := ConstructEvent(publishBuilder)
+		if err != nil {
+			logrus.
+				WithField("Topic Name", publishBuilder.topic).
+				WithField("Event Name", publishBuilder.eventName).
+				Error("unable to construct event: ", err)
+			results[i].Err = fmt.Errorf("unable to construct event : %s , error : %v", publishBuilder.eventName, err)
+
+			continue
+		}
+
+		results[i].Event = event
+
+		for _, pubTopic := range publishBuilder.topic {
+			topic := constructTopic(prefix, pubTopic)
+
+			group, ok := groupByTopic[topic]
+			if !ok {
+				group = &batchGroup{topic: topic}
+				groupByTopic[topic] = group
+				groups = append(groups, group)
+			}
+
+			group.messages = append(group.messages, &batchMessage{index: i, event: event, message: message})
+		}
+	}
+
+	return results, groups
+}
+
+// kafkaMessages returns the kafka messages of the group
+func (group *batchGroup) kafkaMessages() []kafka.Message {
+	messages := make([]kafka.Message, 0, len(group.messages))
+	for _, batchMsg := range group.messages {
+		messages = append(messages, batchMsg.message)
+	}
+
+	return messages
+}
+
+// setError sets the error of every message of the group from the WriteMessages error.
+// kafka.WriteErrors carries an error per message, any other error applies to every message.
+// It returns the failed messages.
+func (group *batchGroup) setError(err error) []*batchMessage {
+	var writeErrors kafka.WriteErrors
+	isWriteErrors := errors.As(err, &writeErrors) && len(writeErrors) == len(group.messages)
+
+	failed := make([]*batchMessage, 0)
+
+	for i, batchMsg := range group.messages {
+		batchMsg.err = err
+		if isWriteErrors {
+			batchMsg.err = writeErrors[i]
+		}
+
+		if batchMsg.err != nil {
+			failed = append(failed, batchMsg)
+		}
+	}
+
+	return failed
+}
+
+// batchError returns an error reporting the failed events of the batch, or nil if every event was published
+func batchError(results []BatchResult) error {
+	var firstErr error
+	failed := 0
+
+	for _, result := range results {
+		if result.Err != nil {
+			if firstErr == nil {
+				firstErr = result.Err
+			}
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("unable to publish %d of %d events: %w", failed, len(results), firstErr)
+}
+
+// failBatch sets the error of the valid events of the batch
+func failBatch(results []BatchResult, err error) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = err
+		}
+	}
+}
+
+// batchMessageFailed logs a message of a batch that couldn't be published and calls its error callback
+func batchMessageFailed(publishBuilder *PublishBuilder, topic string, event *Event, err error) {
+	logrus.
+		WithField("Topic Name", topic).
+		WithField("Event Name", publishBuilder.eventName).
+		Error("giving up publishing event: ", err)
+
+	if publishBuilder.errorCallback != nil {
+		publishBuilder.errorCallback(event, err)
+	}
+}
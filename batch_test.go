@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishBatchSync(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+
+	topicName := constructTopicTest()
+	otherTopicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	createTestTopic(t, otherTopicName)
+
+	publishBuilders := []*PublishBuilder{
+		NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(0),
+		NewPublish().Topic(otherTopicName).EventName("otherEvent").Key(testKey),
+		NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(1),
+		NewPublish().Topic(topicName), // invalid, without event name
+		NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(2),
+	}
+
+	results, err := client.PublishBatchSync(publishBuilders...)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 5 events")
+	require.Len(t, results, len(publishBuilders))
+
+	for i, result := range results {
+		assert.Equal(t, i, result.Index)
+
+		if i == 3 {
+			assert.Error(t, result.Err)
+			assert.Nil(t, result.Event)
+
+			continue
+		}
+
+		assert.NoError(t, result.Err)
+		require.NotNil(t, result.Event)
+		assert.NotEmpty(t, result.Event.ID)
+	}
+
+	received := make(chan *Event, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-received:
+			assert.Equal(t, i, event.EventID, "events should keep the batch order")
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+
+	topicName := constructTopicTest()
+
+	received := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	results, err := client.PublishBatch(
+		NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(0),
+		NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(1))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-received:
+			assert.Equal(t, i, event.EventID)
+			assert.Equal(t, results[i].Event.ID, event.ID)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+}
+
+func TestPublishBatchFailed(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
+	t.Parallel()
+
+	client := createInvalidKafkaClient(t)
+
+	topicName := constructTopicTest()
+
+	var lock sync.Mutex
+	failed := make(map[string]error)
+	errorCallback := func(event *Event, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		failed[event.ID] = err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	results, err := client.PublishBatchSync(
+		NewPublish().Topic(topicName).EventName("testEvent").Context(ctx).ErrorCallback(errorCallback),
+		NewPublish().Topic(topicName).EventName("testEvent").Context(ctx).ErrorCallback(errorCallback))
+	require.Error(t, err)
+	require.Len(t, results, 2)
+
+	lock.Lock()
+	for _, result := range results {
+		assert.Error(t, result.Err)
+		assert.Equal(t, result.Err, failed[result.Event.ID], "error callback should be called with the event error")
+	}
+	lock.Unlock()
+
+	done := make(chan struct{})
+	results, err = client.PublishBatch(
+		NewPublish().Topic(topicName).EventName("testEvent").Timeout(2 * time.Second).
+			ErrorCallback(func(event *Event, err error) {
+				assert.Error(t, err)
+				close(done)
+			}))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	select {
+	case <-done:
+	case <-time.After(time.Duration(timeoutTest) * time.Second):
+		assert.FailNow(t, errorTimeout)
+	}
+}
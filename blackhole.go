@@ -16,6 +16,8 @@
 
 package eventstream
 
+import "context"
+
 // BlackholeClient satisfies the publisher for mocking
 type BlackholeClient struct{}
 
@@ -29,17 +31,71 @@ func (client *BlackholeClient) Publish(publishBuilder *PublishBuilder) error {
 	return nil
 }
 
+func (client *BlackholeClient) PublishAsync(publishBuilder *PublishBuilder) (PublishResult, error) {
+	// do nothing, the result is already completed without any report
+	return newPublishResult(nil, 0), nil
+}
+
 func (client *BlackholeClient) Register(subscribeBuilder *SubscribeBuilder) error {
 	// do nothing
 	return nil
 }
 
+func (client *BlackholeClient) Subscribe(subscribeBuilder *SubscribeBuilder) (Subscription, error) {
+	// do nothing, the subscription stays idle until it is stopped
+	if subscribeBuilder == nil {
+		subscribeBuilder = NewSubscribe()
+	}
+	return newIdleSubscription(subscribeBuilder), nil
+}
+
 func (client *BlackholeClient) PublishSync(publishBuidler *PublishBuilder) error {
 	// do nothing
 	return nil
 }
 
+func (client *BlackholeClient) PublishBatch(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	// do nothing
+	return client.PublishBatchSync(publishBuilders...)
+}
+
+func (client *BlackholeClient) PublishBatchSync(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	// do nothing
+	results := make([]BatchResult, len(publishBuilders))
+	for i := range results {
+		results[i].Index = i
+	}
+
+	return results, nil
+}
+
 func (client *BlackholeClient) PublishAuditLog(auditLogBuilder *AuditLogBuilder) error {
 	// do nothing
 	return nil
 }
+
+func (client *BlackholeClient) Deliveries() <-chan DeliveryReport {
+	// do nothing
+	return nil
+}
+
+func (client *BlackholeClient) PauseAll() {
+	// do nothing
+}
+
+func (client *BlackholeClient) ResumeAll() {
+	// do nothing
+}
+
+func (client *BlackholeClient) Health(ctx context.Context) HealthReport {
+	return newHealthReport()
+}
+
+func (client *BlackholeClient) Describe() ClientDescription {
+	return ClientDescription{Writers: []WriterDescription{}, Subscriptions: []SubscriptionDescription{}}
+}
+
+func (client *BlackholeClient) Close(ctx context.Context) error {
+	// do nothing
+	return nil
+}
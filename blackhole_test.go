@@ -17,6 +17,7 @@
 package eventstream
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,3 +38,8 @@ func TestBlackholeSubscribe(t *testing.T) {
 	client := &BlackholeClient{}
 	_ = client.Register(nil)
 }
+
+func TestBlackholeClose(t *testing.T) {
+	client := &BlackholeClient{}
+	assert.NoError(t, client.Close(context.Background()))
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// keyWorkers processes the messages of a concurrent subscriber with a worker per queue. The messages with the same
+// key go to the same queue, so they're processed in order while the other keys are processed in parallel.
+type keyWorkers struct {
+	queues  []chan kafka.Message
+	process func(message kafka.Message) error
+
+	pending sync.WaitGroup // dispatched messages that aren't processed yet
+	running sync.WaitGroup // workers
+
+	lock sync.Mutex
+	err  error // first error of process
+}
+
+// newKeyWorkers starts n workers processing the dispatched messages
+func newKeyWorkers(n int, process func(message kafka.Message) error) *keyWorkers {
+	workers := &keyWorkers{
+		queues:  make([]chan kafka.Message, n),
+		process: process,
+	}
+
+	for i := range workers.queues {
+		workers.queues[i] = make(chan kafka.Message, 1)
+
+		workers.running.Add(1)
+		go workers.run(workers.queues[i])
+	}
+
+	return workers
+}
+
+// run processes the messages of a queue until it's closed
+func (workers *keyWorkers) run(queue <-chan kafka.Message) {
+	defer workers.running.Done()
+
+	for message := range queue {
+		if err := workers.process(message); err != nil {
+			workers.lock.Lock()
+			if workers.err == nil {
+				workers.err = err
+			}
+			workers.lock.Unlock()
+		}
+
+		workers.pending.Done()
+	}
+}
+
+// dispatch queues the message to the worker of its key, it blocks while the queue is full.
+// It returns false if ctx is done first.
+func (workers *keyWorkers) dispatch(ctx context.Context, message kafka.Message) bool {
+	hash := fnv.New32a()
+	_, _ = hash.Write(message.Key)
+	queue := workers.queues[hash.Sum32()%uint32(len(workers.queues))]
+
+	workers.pending.Add(1)
+
+	select {
+	case queue <- message:
+		return true
+	case <-ctx.Done():
+		workers.pending.Done()
+		return false
+	}
+}
+
+// drain waits until the dispatched messages are processed
+func (workers *keyWorkers) drain() {
+	workers.pending.Wait()
+}
+
+// failed returns the first error of a worker, the subscriber restarts on it
+func (workers *keyWorkers) failed() error {
+	workers.lock.Lock()
+	defer workers.lock.Unlock()
+
+	return workers.err
+}
+
+// close processes the queued messages and stops the workers
+func (workers *keyWorkers) close() {
+	for _, queue := range workers.queues {
+		close(queue)
+	}
+
+	workers.running.Wait()
+}
+
+// process processes a message of a concurrent subscriber in a worker, the failed attempts are retried in the worker
+// with the retry policy of the subscriber. The message is acknowledged once it's processed, forwarded to a retry
+// topic or given up. It returns an error if the message can't be forwarded or dead lettered.
+func (tracker *ackTracker) process(message kafka.Message) error {
+	builder := tracker.sub.builder
+
+	var r *retrier
+	for {
+		err := processMessage(tracker.sub.ctx, builder, message, message.Topic)
+		if err == nil {
+			tracker.ackOffset(message)
+			return nil
+		}
+
+		tracker.sub.failed(err)
+
+		if len(builder.retryDelays) > 0 {
+			// the event is published to the next retry topic and acknowledged
+			forwardTopic, forwardMessage := builder.forwardFailed(message, message.Topic, tracker.groupID, err)
+			tracker.loggerFields.WithField("Retry Topic", forwardTopic).Warn("unable to process the event: ", err)
+
+			if errPublish := tracker.publish(forwardTopic, forwardMessage); errPublish != nil {
+				return errPublish
+			}
+
+			tracker.ackOffset(message)
+
+			return nil
+		}
+
+		if r == nil {
+			r = newRetrier(tracker.policy)
+		}
+
+		delay, ok := r.next(err)
+		if !ok {
+			return tracker.giveUp(message, r.attempt, err)
+		}
+
+		tracker.retryMetrics.ObserveRetry(retryOperationSubscribe, message.Topic, r.attempt)
+		tracker.loggerFields.WithField("Attempt", r.attempt).Error("unable to process the event: ", err)
+
+		if !tracker.sub.waitUntil(time.Now().Add(delay)) {
+			// the event isn't committed, it's processed again once the subscriber restarts
+			return nil
+		}
+	}
+}
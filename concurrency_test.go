@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyWorkersOrder(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	processed := make(map[string][]int64)
+
+	workers := newKeyWorkers(3, func(message kafka.Message) error {
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		processed[string(message.Key)] = append(processed[string(message.Key)], message.Offset)
+		if message.Offset == 5 {
+			return errRetryTest
+		}
+
+		return nil
+	})
+	defer workers.close()
+
+	for offset := int64(0); offset < 30; offset++ {
+		key := []byte(strconv.Itoa(int(offset % 4)))
+		assert.True(t, workers.dispatch(context.Background(), kafka.Message{Key: key, Offset: offset}))
+	}
+
+	workers.drain()
+
+	assert.Len(t, processed, 4)
+	for key, offsets := range processed {
+		assert.IsIncreasing(t, offsets, "the events of key %s should be processed in order", key)
+	}
+
+	assert.ErrorIs(t, workers.failed(), errRetryTest)
+}
+
+// workerKeys returns two keys processed by different workers of a subscriber with concurrency 2
+func workerKeys() (string, string) {
+	worker := func(key string) uint32 {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(key))
+		return hash.Sum32() % 2
+	}
+
+	for i := 0; ; i++ {
+		if key := testKey + strconv.Itoa(i); worker(key) != worker(testKey) {
+			return testKey, key
+		}
+	}
+}
+
+func TestSubscribeConcurrency(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	groupID := generateID()
+	createTestTopic(t, topicName)
+
+	slowKey, fastKey := workerKeys()
+	keys := []string{slowKey, fastKey, fastKey}
+	for i, key := range keys {
+		err := client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(key).EventID(i))
+		require.NoError(t, err)
+	}
+
+	processed := make(chan int, 10)
+	release := make(chan struct{})
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			Concurrency(2).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				if event.EventID == 0 {
+					// the event of the slow key blocks its worker only
+					select {
+					case <-release:
+					case <-ctx.Done():
+					}
+				}
+
+				processed <- event.EventID
+				return nil
+			}))
+	require.NoError(t, err)
+
+	nextProcessed := func() int {
+		select {
+		case eventID := <-processed:
+			return eventID
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+			return 0
+		}
+	}
+
+	assert.Equal(t, 1, nextProcessed(), "the other key should be processed in parallel")
+	assert.Equal(t, 2, nextProcessed(), "the events of a key should be processed in order")
+
+	// unsubscribe finishes the processing event
+	unsubscribed := make(chan error, 1)
+	go func() {
+		unsubscribed <- subscription.Unsubscribe(ctx)
+	}()
+
+	select {
+	case <-unsubscribed:
+		assert.FailNow(t, "unsubscribe should wait for the processing event")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(t, 0, nextProcessed())
+	assert.NoError(t, <-unsubscribed)
+
+	// the next subscriber of the group starts after the processed events
+	err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(3))
+	require.NoError(t, err)
+
+	received := make(chan int, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event.EventID
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	select {
+	case eventID := <-received:
+		assert.Equal(t, 3, eventID, "the processed events should be committed")
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscribeConcurrencyInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Concurrency(2).
+			CallbackDelivery(func(ctx context.Context, delivery *Delivery, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidConcurrency)
+}
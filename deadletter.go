@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DeadLetterTopicSuffix is appended to a topic to name its dead letter topic
+const DeadLetterTopicSuffix = ".dlq"
+
+// headers describing why a message was published to a dead letter topic
+const (
+	HeaderDeadLetterError     = "dlq-error"              // error of the last attempt
+	HeaderDeadLetterAttempts  = "dlq-attempts"           // number of failed attempts
+	HeaderDeadLetterGroupID   = "dlq-group-id"           // consumer group of the subscriber, empty for publish failures
+	HeaderDeadLetterTopic     = "dlq-original-topic"     // topic of the message
+	HeaderDeadLetterPartition = "dlq-original-partition" // partition of the message, -1 for publish failures
+	HeaderDeadLetterOffset    = "dlq-original-offset"    // offset of the message, -1 for publish failures
+	HeaderDeadLetterTime      = "dlq-time"               // time the message was dead lettered, RFC 3339
+)
+
+// DeadLetterTopicName returns the default dead letter topic of a topic
+func DeadLetterTopicName(topic string) string {
+	return topic + DeadLetterTopicSuffix
+}
+
+// deadLetter describes a message given up by a publish or a subscriber
+type deadLetter struct {
+	topic     string
+	groupID   string
+	partition int
+	offset    int64
+	attempts  int
+	err       error
+}
+
+// newDeadLetterMessage returns the message to publish to the dead letter topic: the original key, value and headers,
+// with the dead letter headers replacing the ones of a previous dead lettering
+func newDeadLetterMessage(message kafka.Message, letter deadLetter) kafka.Message {
+	errMessage := ""
+	if letter.err != nil {
+		errMessage = letter.err.Error()
+	}
+
+	return replaceHeaders(message, "dlq-",
+		kafka.Header{Key: HeaderDeadLetterError, Value: []byte(errMessage)},
+		kafka.Header{Key: HeaderDeadLetterAttempts, Value: []byte(strconv.Itoa(letter.attempts))},
+		kafka.Header{Key: HeaderDeadLetterGroupID, Value: []byte(letter.groupID)},
+		kafka.Header{Key: HeaderDeadLetterTopic, Value: []byte(letter.topic)},
+		kafka.Header{Key: HeaderDeadLetterPartition, Value: []byte(strconv.Itoa(letter.partition))},
+		kafka.Header{Key: HeaderDeadLetterOffset, Value: []byte(strconv.FormatInt(letter.offset, 10))},
+		kafka.Header{Key: HeaderDeadLetterTime, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+}
+
+// replaceHeaders returns a new message with the key and value of the message, and its headers with the ones
+// starting with prefix replaced by headers
+func replaceHeaders(message kafka.Message, prefix string, headers ...kafka.Header) kafka.Message {
+	replaced := make([]kafka.Header, 0, len(message.Headers)+len(headers))
+	for _, header := range message.Headers {
+		if !strings.HasPrefix(header.Key, prefix) {
+			replaced = append(replaced, header)
+		}
+	}
+
+	return kafka.Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: append(replaced, headers...),
+	}
+}
+
+// deadLetterTopicName returns the dead letter topic of the subscriber without prefix, empty if it isn't enabled.
+// It's always enabled with retry topics.
+func (s *SubscribeBuilder) deadLetterTopicName() string {
+	if s.retryParent != nil {
+		return s.retryParent.deadLetterTopicName()
+	}
+
+	if !s.deadLetter && len(s.retryDelays) == 0 {
+		return ""
+	}
+
+	if s.deadLetterTopic != "" {
+		return s.deadLetterTopic
+	}
+
+	return DeadLetterTopicName(s.subscribedTopic())
+}
+
+// subscribeRetryPolicy returns the retry policy of the subscriber, given up after the attempts of its dead letter topic
+func (s *SubscribeBuilder) subscribeRetryPolicy(clientPolicy *RetryPolicy) *RetryPolicy {
+	policy := retryPolicyOrDefault(s.retryPolicy, func() *RetryPolicy { return clientPolicy })
+
+	if s.deadLetter && s.deadLetterAttempts > 0 {
+		withAttempts := *policy
+		withAttempts.MaxAttempts = s.deadLetterAttempts
+		policy = &withAttempts
+	}
+
+	return policy
+}
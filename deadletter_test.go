@@ -0,0 +1,222 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkatest"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDeadLetterMessage(t *testing.T) {
+	t.Parallel()
+
+	message := kafka.Message{
+		Topic:     "topic",
+		Partition: 2,
+		Offset:    10,
+		Key:       []byte(testKey),
+		Value:     []byte("value"),
+		Headers: []kafka.Header{
+			{Key: "header", Value: []byte("value")},
+			{Key: HeaderDeadLetterAttempts, Value: []byte("5")},
+		},
+	}
+
+	deadLetterMessage := newDeadLetterMessage(message, deadLetter{
+		topic:     "topic",
+		groupID:   "group",
+		partition: 2,
+		offset:    10,
+		attempts:  3,
+		err:       errRetryTest,
+	})
+
+	assert.Empty(t, deadLetterMessage.Topic, "the writer sets the topic")
+	assert.Equal(t, message.Key, deadLetterMessage.Key)
+	assert.Equal(t, message.Value, deadLetterMessage.Value)
+
+	headers := eventHeaders(deadLetterMessage.Headers)
+	assert.Len(t, deadLetterMessage.Headers, 8, "the previous dead letter headers should be replaced")
+	assert.Equal(t, "value", headers["header"])
+	assert.Equal(t, errRetryTest.Error(), headers[HeaderDeadLetterError])
+	assert.Equal(t, "3", headers[HeaderDeadLetterAttempts])
+	assert.Equal(t, "group", headers[HeaderDeadLetterGroupID])
+	assert.Equal(t, "topic", headers[HeaderDeadLetterTopic])
+	assert.Equal(t, "2", headers[HeaderDeadLetterPartition])
+	assert.Equal(t, "10", headers[HeaderDeadLetterOffset])
+	assert.NotEmpty(t, headers[HeaderDeadLetterTime])
+}
+
+func TestSubscribeDeadLetter(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	createTestTopic(t, DeadLetterTopicName(topicName))
+
+	deadLetters := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(DeadLetterTopicName(topicName)).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					deadLetters <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+	}
+
+	groupID := generateID()
+	deliveries := make(chan int, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			RetryPolicy(&RetryPolicy{InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			DeadLetter(2).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				deliveries <- event.EventID
+				if event.EventID == 0 {
+					return errRetryTest
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	var delivered []int
+	for len(delivered) < 3 {
+		select {
+		case eventID := <-deliveries:
+			delivered = append(delivered, eventID)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "delivered: %v", delivered)
+		}
+	}
+
+	assert.Equal(t, []int{0, 0, 1}, delivered, "the failed event should be dead lettered after 2 attempts")
+
+	select {
+	case event := <-deadLetters:
+		assert.Equal(t, 0, event.EventID)
+		assert.Equal(t, errRetryTest.Error(), event.Headers[HeaderDeadLetterError])
+		assert.Equal(t, "2", event.Headers[HeaderDeadLetterAttempts])
+		assert.Equal(t, constructGroupID(prefix, groupID), event.Headers[HeaderDeadLetterGroupID])
+		assert.Equal(t, constructTopic(prefix, topicName), event.Headers[HeaderDeadLetterTopic])
+		assert.Equal(t, "0", event.Headers[HeaderDeadLetterPartition])
+		assert.Equal(t, "0", event.Headers[HeaderDeadLetterOffset])
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscribeDeadLetterInvalidTopic(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			DeadLetterTopic(topicName).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidDeadLetterTopic)
+}
+
+func TestPublishDeadLetter(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	// only the dead letter topic exists
+	broker := kafkatest.NewBroker(&kafkatest.BrokerConfig{DisableAutoCreateTopics: true})
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+
+	topicName := constructTopicTest()
+	deadLetterTopic := DeadLetterTopicName(constructTopic(prefix, topicName))
+	require.NoError(t, broker.CreateTopic(deadLetterTopic, 1))
+
+	client, err := NewClient(prefix, eventStreamKafka, broker.Addrs(), &BrokerConfig{
+		DialTimeout:        time.Second,
+		BaseWriterConfig:   &kafka.WriterConfig{MaxAttempts: 1},
+		PublishRetryPolicy: &RetryPolicy{MaxAttempts: 2, InitialInterval: 10 * time.Millisecond},
+		PublishDeadLetter:  true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	errorCallbacks := make(chan error, 1)
+	result, err := client.PublishAsync(
+		NewPublish().
+			Topic(topicName).
+			EventName("testEvent").
+			Key(testKey).
+			ErrorCallback(func(event *Event, err error) {
+				errorCallbacks <- err
+			}))
+	require.NoError(t, err)
+
+	reports, err := result.Wait(ctx)
+	assert.Error(t, err)
+	assert.True(t, reports[0].DeadLettered)
+	assert.Error(t, <-errorCallbacks, "the error callback should still be called")
+
+	messages := broker.Messages(deadLetterTopic, 0)
+	require.Len(t, messages, 1)
+	assert.Equal(t, testKey, string(messages[0].Key))
+
+	headers := eventHeaders(messages[0].Headers)
+	assert.Equal(t, "2", headers[HeaderDeadLetterAttempts])
+	assert.Equal(t, constructTopic(prefix, topicName), headers[HeaderDeadLetterTopic])
+	assert.Equal(t, "-1", headers[HeaderDeadLetterPartition])
+	assert.Empty(t, headers[HeaderDeadLetterGroupID])
+}
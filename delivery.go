@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDeliveryReportsSize = 1000 // buffer of the Deliveries channel
+)
+
+// DeliveryReport is the outcome of an event published asynchronously to a topic
+type DeliveryReport struct {
+	EventID   string
+	Topic     string
+	Partition int       // -1 if the event wasn't published
+	Offset    int64     // -1 if the event wasn't published
+	Timestamp time.Time // time of the message in the topic
+	Attempts  int       // number of writes until the event was published or given up
+	Spooled   bool      // the event is kept in the disk spool and is forwarded once kafka is reachable again
+	Err       error     // nil if the event was published or spooled
+
+	DeadLettered bool // the event couldn't be published and was published to the dead letter topic instead
+}
+
+// PublishResult is the future of an event published asynchronously.
+// It completes once the event is published to every topic or the publish gives up.
+type PublishResult interface {
+	// Event returns the published event
+	Event() *Event
+
+	// Done is closed when the publish completes
+	Done() <-chan struct{}
+
+	// Wait blocks until the publish completes and returns a report per topic.
+	// It returns the first publish error, or the context error if the context is done first.
+	Wait(ctx context.Context) ([]DeliveryReport, error)
+}
+
+// publishResult implements PublishResult
+type publishResult struct {
+	event *Event
+
+	lock    sync.Mutex
+	reports []DeliveryReport
+	pending int
+
+	done chan struct{}
+}
+
+// newPublishResult creates a publish result waiting for a report per topic
+func newPublishResult(event *Event, topics int) *publishResult {
+	result := &publishResult{
+		event:   event,
+		reports: make([]DeliveryReport, 0, topics),
+		pending: topics,
+		done:    make(chan struct{}),
+	}
+
+	if topics == 0 {
+		close(result.done)
+	}
+
+	return result
+}
+
+// Event returns the published event
+func (result *publishResult) Event() *Event {
+	return result.event
+}
+
+// Done is closed when the publish completes
+func (result *publishResult) Done() <-chan struct{} {
+	return result.done
+}
+
+// Wait blocks until the publish completes and returns a report per topic
+func (result *publishResult) Wait(ctx context.Context) ([]DeliveryReport, error) {
+	select {
+	case <-result.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	result.lock.Lock()
+	defer result.lock.Unlock()
+
+	reports := make([]DeliveryReport, len(result.reports))
+	copy(reports, result.reports)
+
+	for _, report := range reports {
+		if report.Err != nil {
+			return reports, report.Err
+		}
+	}
+
+	return reports, nil
+}
+
+// report adds the report of a topic, the result completes with the last one
+func (result *publishResult) report(report DeliveryReport) {
+	result.lock.Lock()
+	defer result.lock.Unlock()
+
+	result.reports = append(result.reports, report)
+
+	result.pending--
+	if result.pending == 0 {
+		close(result.done)
+	}
+}
+
+// messageDelivery is set as the WriterData of a published message, the writer completion fills it with
+// the position of the message. A new one is used for every write attempt.
+type messageDelivery struct {
+	partition int
+	offset    int64
+	time      time.Time
+}
+
+// newDeliveryReport creates the report of a message, delivery is only read if the message was published
+func newDeliveryReport(eventID, topic string, delivery *messageDelivery, attempts int, err error) DeliveryReport {
+	report := DeliveryReport{
+		EventID:   eventID,
+		Topic:     topic,
+		Partition: -1,
+		Offset:    -1,
+		Attempts:  attempts,
+		Err:       err,
+	}
+
+	if err == nil && delivery != nil {
+		report.Partition = delivery.partition
+		report.Offset = delivery.offset
+		report.Timestamp = delivery.time
+	}
+
+	return report
+}
+
+// newSpooledReport creates the report of a message stored in the spool
+func newSpooledReport(eventID, topic string, attempts int) DeliveryReport {
+	report := newDeliveryReport(eventID, topic, nil, attempts, nil)
+	report.Spooled = true
+
+	return report
+}
+
+// completeDeliveries is the completion function of the kafka writers.
+// WriteMessages waits for it, so the deliveries are filled when it returns without error.
+func completeDeliveries(messages []kafka.Message, err error) {
+	if err != nil {
+		return
+	}
+
+	for _, message := range messages {
+		if delivery, ok := message.WriterData.(*messageDelivery); ok {
+			delivery.partition = message.Partition
+			delivery.offset = message.Offset
+			delivery.time = message.Time
+		}
+	}
+}
+
+// sendDeliveryReport sends the report to the deliveries channel without blocking the publish
+func sendDeliveryReport(deliveries chan DeliveryReport, report DeliveryReport) {
+	if deliveries == nil {
+		return
+	}
+
+	select {
+	case deliveries <- report:
+	default:
+		logrus.
+			WithField("Topic Name", report.Topic).
+			WithField("Event ID", report.EventID).
+			Warn("dropping delivery report: the deliveries channel is full")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishAsyncDeliveryReport(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, err := NewClient(prefix, testStream(), testBrokers(), &BrokerConfig{
+		StrictValidation: true,
+		DialTimeout:      2 * time.Second,
+		DeliveryReports:  true,
+	})
+	require.NoError(t, err)
+
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	result, err := client.PublishAsync(
+		NewPublish().
+			Topic(topicName).
+			EventName("testEvent").
+			Key(testKey))
+	require.NoError(t, err)
+
+	reports, err := result.Wait(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+
+	report := reports[0]
+	assert.Equal(t, result.Event().ID, report.EventID)
+	assert.Equal(t, constructTopic(prefix, topicName), report.Topic)
+	assert.GreaterOrEqual(t, report.Partition, 0)
+	assert.GreaterOrEqual(t, report.Offset, int64(0))
+	assert.False(t, report.Timestamp.IsZero())
+	assert.Equal(t, 1, report.Attempts)
+
+	select {
+	case delivery := <-client.Deliveries():
+		assert.Equal(t, report, delivery)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+
+	received := make(chan *Event, 1)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, report.EventID, event.ID)
+		assert.Equal(t, report.Partition, event.Partition)
+		assert.Equal(t, report.Offset, event.Offset)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+
+	require.NoError(t, client.Close(ctx))
+
+	_, ok := <-client.Deliveries()
+	assert.False(t, ok, "deliveries channel should be closed")
+}
+
+func TestPublishAsyncDeliveryFailed(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, err := NewClient(prefix, eventStreamKafka, []string{"invalidbroker:9092"}, &BrokerConfig{
+		DialTimeout:     time.Second,
+		DeliveryReports: true,
+	})
+	require.NoError(t, err)
+
+	callbackErr := make(chan error, 1)
+	result, err := client.PublishAsync(
+		NewPublish().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Timeout(2 * time.Second).
+			ErrorCallback(func(event *Event, err error) {
+				callbackErr <- err
+			}))
+	require.NoError(t, err)
+
+	reports, err := result.Wait(ctx)
+	require.Error(t, err)
+	require.Len(t, reports, 1)
+
+	assert.Equal(t, err, reports[0].Err)
+	assert.Equal(t, -1, reports[0].Partition)
+	assert.Equal(t, int64(-1), reports[0].Offset)
+	assert.GreaterOrEqual(t, reports[0].Attempts, 1)
+	assert.Equal(t, err, <-callbackErr, "error callback should be called before the result completes")
+
+	select {
+	case delivery := <-client.Deliveries():
+		assert.Equal(t, reports[0], delivery)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestPublishAsyncWaitContext(t *testing.T) {
+	t.Parallel()
+
+	result := newPublishResult(&Event{ID: "event"}, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := result.Wait(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	result.report(DeliveryReport{EventID: "event", Partition: 1, Offset: 2, Attempts: 1})
+
+	select {
+	case <-result.Done():
+	default:
+		assert.Fail(t, "result should be completed")
+	}
+
+	reports, err := result.Wait(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []DeliveryReport{{EventID: "event", Partition: 1, Offset: 2, Attempts: 1}}, reports)
+}
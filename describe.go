@@ -0,0 +1,307 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientDescription is a snapshot of the writers and the subscriptions of a client, see Client.Describe
+type ClientDescription struct {
+	Writers       []WriterDescription       `json:"writers"`
+	Subscriptions []SubscriptionDescription `json:"subscriptions"`
+}
+
+// WriterDescription is the state of the writer of a topic
+type WriterDescription struct {
+	// Topic is the name of the topic without the client prefix
+	Topic string `json:"topic"`
+
+	// PendingMessages are the messages being written to the topic
+	PendingMessages int64 `json:"pendingMessages"`
+
+	// LastError is the last error writing to the topic, empty if there's none
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// SubscriptionDescription is the state of a subscription
+type SubscriptionDescription struct {
+	// Topics are the names of the subscribed topics without the client prefix, or the pattern of the topics
+	Topics     []string          `json:"topics"`
+	EventNames []string          `json:"eventNames"`
+	GroupID    string            `json:"groupId,omitempty"`
+	State      SubscriptionState `json:"state"`
+
+	// Partitions are the partitions assigned to the subscriber. The kafka reader of a subscriber without partition
+	// hooks doesn't expose its assignments, its partitions are the ones it fetched or committed events of since it
+	// started: an assigned partition without any event isn't reported.
+	Partitions []PartitionDescription `json:"partitions"`
+
+	// LastError is the last error fetching or processing an event, empty if there's none
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+
+	// LastMessageTime is the time the last event was fetched, not its timestamp, nil if there's none
+	LastMessageTime *time.Time `json:"lastMessageTime,omitempty"`
+}
+
+// PartitionDescription is the state of a partition consumed by a subscription
+type PartitionDescription struct {
+	// Topic is the name of the topic without the client prefix
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+
+	// CommittedOffset is the offset of the next event to process committed by the subscriber, -1 if it didn't
+	// commit any
+	CommittedOffset int64 `json:"committedOffset"`
+
+	// Lag is the number of events of the partition after the last one fetched, -1 if it's unknown
+	Lag int64 `json:"lag"`
+}
+
+// MarshalText returns the state name
+func (state SubscriptionState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+// partitionActivity is the progress of the subscriber on a partition
+type partitionActivity struct {
+	committed int64
+	lag       int64
+}
+
+// resetPartitions replaces the partitions reported for the subscriber, e.g. when its reader is assigned partitions
+func (sub *subscription) resetPartitions(partitions []topicPartition) {
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	sub.partitions = make(map[topicPartition]*partitionActivity, len(partitions))
+	for _, partition := range partitions {
+		sub.partitions[partition] = &partitionActivity{committed: -1, lag: -1}
+	}
+}
+
+// partition returns the activity of the partition of the message, sub.activityLock must be held
+func (sub *subscription) partition(message kafka.Message) *partitionActivity {
+	if sub.partitions == nil {
+		sub.partitions = make(map[topicPartition]*partitionActivity)
+	}
+
+	key := topicPartition{topic: message.Topic, partition: message.Partition}
+
+	partition, ok := sub.partitions[key]
+	if !ok {
+		partition = &partitionActivity{committed: -1, lag: -1}
+		sub.partitions[key] = partition
+	}
+
+	return partition
+}
+
+// fetched records the message fetched by the subscriber
+func (sub *subscription) fetched(message kafka.Message) {
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	sub.lastMessageTime = time.Now()
+	sub.lastFetchTime = sub.lastMessageTime
+	sub.fetchError = nil
+
+	if message.HighWaterMark > 0 {
+		sub.partition(message).lag = message.HighWaterMark - message.Offset - 1
+	}
+}
+
+// committed records the messages committed by the subscriber
+func (sub *subscription) committed(messages ...kafka.Message) {
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	for _, message := range messages {
+		partition := sub.partition(message)
+		if message.Offset+1 > partition.committed {
+			partition.committed = message.Offset + 1
+		}
+	}
+}
+
+// failed records an error fetching or processing an event
+func (sub *subscription) failed(err error) {
+	if err == nil {
+		return
+	}
+
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	sub.lastError = err
+	sub.lastErrorTime = time.Now()
+}
+
+// describe returns the description of the subscription with its recorded partitions
+func (sub *subscription) describe() SubscriptionDescription {
+	builder := sub.builder
+
+	description := SubscriptionDescription{
+		Topics:     builder.subscribedTopics(),
+		EventNames: builder.subscribedEventNames(),
+		GroupID:    builder.groupID,
+		State:      sub.State(),
+		Partitions: []PartitionDescription{},
+	}
+	if builder.topicPattern != nil {
+		description.Topics = []string{builder.topicPattern.String()}
+	}
+
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	for key, partition := range sub.partitions {
+		description.Partitions = append(description.Partitions, PartitionDescription{
+			Topic:           trimTopicPrefix(builder.prefix, key.topic),
+			Partition:       key.partition,
+			CommittedOffset: partition.committed,
+			Lag:             partition.lag,
+		})
+	}
+	sortPartitions(description.Partitions)
+
+	if sub.lastError != nil {
+		lastErrorTime := sub.lastErrorTime
+		description.LastError = sub.lastError.Error()
+		description.LastErrorTime = &lastErrorTime
+	}
+
+	if !sub.lastMessageTime.IsZero() {
+		lastMessageTime := sub.lastMessageTime
+		description.LastMessageTime = &lastMessageTime
+	}
+
+	return description
+}
+
+// merge adds the partitions and the activity of the internal subscriber of a topic to the description
+func (description *SubscriptionDescription) merge(topicDescription SubscriptionDescription) {
+	description.Partitions = append(description.Partitions, topicDescription.Partitions...)
+	sortPartitions(description.Partitions)
+
+	if topicDescription.LastErrorTime != nil &&
+		(description.LastErrorTime == nil || topicDescription.LastErrorTime.After(*description.LastErrorTime)) {
+		description.LastError = topicDescription.LastError
+		description.LastErrorTime = topicDescription.LastErrorTime
+	}
+
+	if topicDescription.LastMessageTime != nil &&
+		(description.LastMessageTime == nil || topicDescription.LastMessageTime.After(*description.LastMessageTime)) {
+		description.LastMessageTime = topicDescription.LastMessageTime
+	}
+}
+
+// sortDescription sorts the writers by topic and the subscriptions by topics and group ID
+func sortDescription(description ClientDescription) {
+	sort.Slice(description.Writers, func(i, j int) bool {
+		return description.Writers[i].Topic < description.Writers[j].Topic
+	})
+
+	sort.SliceStable(description.Subscriptions, func(i, j int) bool {
+		left, right := description.Subscriptions[i], description.Subscriptions[j]
+		if topics, otherTopics := strings.Join(left.Topics, ","), strings.Join(right.Topics, ","); topics != otherTopics {
+			return topics < otherTopics
+		}
+
+		return left.GroupID < right.GroupID
+	})
+}
+
+// sortPartitions sorts the partitions by topic and partition
+func sortPartitions(partitions []PartitionDescription) {
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+
+		return partitions[i].Partition < partitions[j].Partition
+	})
+}
+
+// describedReader records the messages fetched and committed with the reader of a subscription
+type describedReader struct {
+	messageReader
+	sub *subscription
+}
+
+// FetchMessage fetches the next message and records it
+func (reader describedReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	message, err := reader.messageReader.FetchMessage(ctx)
+	if err == nil {
+		reader.sub.fetched(message)
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		reader.sub.polled()
+	}
+
+	return message, err
+}
+
+// CommitMessages commits the messages and records them
+func (reader describedReader) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	err := reader.messageReader.CommitMessages(ctx, messages...)
+	if err == nil {
+		reader.sub.committed(messages...)
+	}
+
+	return err
+}
+
+// writerActivity is the activity of the writer of a topic
+type writerActivity struct {
+	pending       int64
+	lastError     error
+	lastErrorTime time.Time
+}
+
+// describe returns the description of the writer of the topic
+func (activity *writerActivity) describe(topic string) WriterDescription {
+	description := WriterDescription{Topic: topic, PendingMessages: activity.pending}
+	if activity.lastError != nil {
+		lastErrorTime := activity.lastErrorTime
+		description.LastError = activity.lastError.Error()
+		description.LastErrorTime = &lastErrorTime
+	}
+
+	return description
+}
+
+// DescribeHandler returns an http.Handler rendering the description of the client as JSON, see Client.Describe
+func DescribeHandler(client Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(client.Describe()); err != nil {
+			logrus.Error("unable to encode the client description: ", err)
+		}
+	})
+}
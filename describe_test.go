@@ -0,0 +1,207 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// describedSubscription returns the description of the subscription of the topic
+func describedSubscription(client Client, topicName string) (SubscriptionDescription, bool) {
+	for _, description := range client.Describe().Subscriptions {
+		if len(description.Topics) == 1 && description.Topics[0] == topicName {
+			return description, true
+		}
+	}
+
+	return SubscriptionDescription{}, false
+}
+
+func TestSubscriptionDescribeLastMessageTime(t *testing.T) {
+	t.Parallel()
+
+	sub := newSubscription(NewSubscribe().Topic(constructTopicTest()))
+	defer sub.cancel()
+
+	assert.Nil(t, sub.describe().LastMessageTime)
+
+	// an event fetched late is reported at the time it's fetched
+	sub.fetched(kafka.Message{Time: time.Now().Add(-time.Hour)})
+
+	lastMessageTime := sub.describe().LastMessageTime
+	if assert.NotNil(t, lastMessageTime) {
+		assert.WithinDuration(t, time.Now(), *lastMessageTime, time.Minute)
+	}
+}
+
+func TestClientDescribe(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	groupID := generateID()
+
+	received := make(chan *Event, 10)
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	publishSeekTestEvents(t, client, topicName, 0, 1)
+	assert.Equal(t, []int{0, 1}, receiveEventIDs(ctx, t, received, 2))
+
+	assert.Eventually(t, func() bool {
+		description, ok := describedSubscription(client, topicName)
+		if !ok {
+			return false
+		}
+
+		for _, partition := range description.Partitions {
+			if partition.CommittedOffset == 2 && partition.Lag == 0 {
+				return true
+			}
+		}
+
+		return false
+	}, 10*time.Second, 50*time.Millisecond, "the committed offset of the partition should be described")
+
+	description, ok := describedSubscription(client, topicName)
+	require.True(t, ok)
+	assert.Equal(t, []string{"testEvent"}, description.EventNames)
+	assert.Equal(t, groupID, description.GroupID)
+	assert.Equal(t, SubscriptionRunning, description.State)
+	assert.NotNil(t, description.LastMessageTime, "the time of the last event should be described")
+	assert.Empty(t, description.LastError)
+
+	if _, ok := client.(*KafkaClient); ok {
+		writers := client.Describe().Writers
+		require.NotEmpty(t, writers)
+
+		var writer *WriterDescription
+		for i := range writers {
+			if writers[i].Topic == topicName {
+				writer = &writers[i]
+			}
+		}
+		require.NotNil(t, writer, "the writer of the topic should be described")
+		assert.Zero(t, writer.PendingMessages)
+		assert.Empty(t, writer.LastError)
+	}
+
+	subscription.Pause()
+
+	description, ok = describedSubscription(client, topicName)
+	require.True(t, ok)
+	assert.Equal(t, SubscriptionPaused, description.State)
+
+	require.NoError(t, subscription.Unsubscribe(ctx))
+
+	_, ok = describedSubscription(client, topicName)
+	assert.False(t, ok, "an unsubscribed subscription shouldn't be described")
+}
+
+func TestKafkaClientWriterActivities(t *testing.T) {
+	t.Parallel()
+
+	client, ok := createInvalidKafkaClient(t).(*KafkaClient)
+	require.True(t, ok)
+
+	topicName := constructTopicTest()
+	topic := constructTopic(prefix, topicName)
+
+	writerTopics := func() []string {
+		var topics []string
+		for _, writer := range client.Describe().Writers {
+			topics = append(topics, writer.Topic)
+		}
+
+		return topics
+	}
+
+	finishWrite := client.startWrite(topic, 1)
+	assert.Equal(t, []string{topicName}, writerTopics(), "a pending write should be described")
+
+	finishWrite(nil)
+	assert.Empty(t, writerTopics(), "the activity without writer should be dropped")
+
+	finishWrite = client.startWrite(topic, 1)
+	finishWrite(errors.New("write failed"))
+	assert.Equal(t, []string{topicName}, writerTopics(), "the last error of the topic should be described")
+
+	_, err := client.getWriter(kafka.WriterConfig{Topic: topic, Brokers: []string{"invalidbroker:9092"}})
+	require.NoError(t, err)
+	client.startWrite(topic, 1)(nil)
+	assert.Equal(t, []string{topicName}, writerTopics(), "the activity of a writer should be described")
+
+	client.deleteWriter(topic)
+	assert.Empty(t, writerTopics(), "the activity of a closed writer should be dropped")
+}
+
+func TestDescribeHandler(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	DescribeHandler(client).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var body map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	require.Len(t, body["subscriptions"], 1)
+	assert.Equal(t, "running", body["subscriptions"][0]["state"])
+	assert.Equal(t, []interface{}{topicName}, body["subscriptions"][0]["topics"])
+	assert.Equal(t, []map[string]interface{}{}, body["writers"])
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// eventNamePatternChars are the characters of a glob pattern, see path.Match
+const eventNamePatternChars = "*?["
+
+// subscribedEventNames returns the event names and glob patterns subscribed by the builder:
+// EventName, EventNames and the ones of the handlers
+func (s *SubscribeBuilder) subscribedEventNames() []string {
+	if s.eventNamesOnSubscribe != nil {
+		return s.eventNamesOnSubscribe
+	}
+
+	eventNames := make([]string, 0, 1+len(s.eventNames)+len(s.handlers))
+	if s.eventName != "" {
+		eventNames = append(eventNames, s.eventName)
+	}
+
+	eventNames = append(eventNames, s.eventNames...)
+
+	handlerNames := make([]string, 0, len(s.handlers))
+	for eventName := range s.handlers {
+		handlerNames = append(handlerNames, eventName)
+	}
+	sort.Strings(handlerNames)
+
+	return append(eventNames, handlerNames...)
+}
+
+// handlerPatterns returns the sorted glob patterns of the handlers
+func (s *SubscribeBuilder) handlerPatterns() []string {
+	if s.handlerPatternsOnSubscribe != nil {
+		return s.handlerPatternsOnSubscribe
+	}
+
+	patterns := make([]string, 0, len(s.handlers))
+	for pattern := range s.handlers {
+		if strings.ContainsAny(pattern, eventNamePatternChars) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+
+	return patterns
+}
+
+// setEventNames keeps the subscribed event names and the handler patterns, so they aren't sorted again for
+// every event. It's called on subscribe, the builder isn't changed afterwards.
+func (s *SubscribeBuilder) setEventNames() {
+	s.eventNamesOnSubscribe = s.subscribedEventNames()
+	s.handlerPatternsOnSubscribe = s.handlerPatterns()
+}
+
+// subscribedEventName returns the subscribed event names separated by commas, e.g. for logs and the slug
+func (s *SubscribeBuilder) subscribedEventName() string {
+	return strings.Join(s.subscribedEventNames(), ",")
+}
+
+// subscribes returns true if the name of the event matches a subscribed event name and the event passes the filter.
+// Every event name is subscribed when there's none.
+func (s *SubscribeBuilder) subscribes(event *Event) bool {
+	eventNames := s.subscribedEventNames()
+	if len(eventNames) > 0 && matchEventNames(eventNames, event.EventName) == "" {
+		return false
+	}
+
+	return s.filter == nil || s.filter(event)
+}
+
+// eventCallback returns the callback of a subscribed event: the handler of its event name, or Callback
+func (s *SubscribeBuilder) eventCallback(eventName string) func(ctx context.Context, event *Event, err error) error {
+	if handler, ok := s.handlers[eventName]; ok {
+		return handler
+	}
+
+	if pattern := matchEventNames(s.handlerPatterns(), eventName); pattern != "" {
+		return s.handlers[pattern]
+	}
+
+	return s.callback
+}
+
+// matchEventNames returns the first event name or glob pattern matching the event name, empty if there's none
+func matchEventNames(patterns []string, eventName string) string {
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, eventNamePatternChars) {
+			if pattern == eventName {
+				return pattern
+			}
+
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, eventName); matched {
+			return pattern
+		}
+	}
+
+	return ""
+}
+
+// validateEventNamePattern returns true if the value is a valid event name, or a valid glob pattern of event names
+func validateEventNamePattern(value string) bool {
+	if !strings.ContainsAny(value, eventNamePatternChars) {
+		return validateTopicEvent(value)
+	}
+
+	if _, err := path.Match(value, ""); err != nil {
+		return false
+	}
+
+	// the pattern is valid if the event names it matches are
+	return validateTopicEvent(strings.NewReplacer("*", "a", "?", "a", "[", "a", "]", "a", "^", "a").Replace(value))
+}
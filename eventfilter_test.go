@@ -0,0 +1,181 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchEventNames(t *testing.T) {
+	t.Parallel()
+
+	patterns := []string{"order.created", "user.*", "item.?"}
+
+	testCases := []struct {
+		eventName string
+		expected  string
+	}{
+		{eventName: "order.created", expected: "order.created"},
+		{eventName: "order.deleted", expected: ""},
+		{eventName: "user.created", expected: "user.*"},
+		{eventName: "user", expected: ""},
+		{eventName: "item.a", expected: "item.?"},
+		{eventName: "item.ab", expected: ""},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, matchEventNames(patterns, testCase.eventName), testCase.eventName)
+	}
+}
+
+func TestValidateEventNamePattern(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, validateEventNamePattern("user.created"))
+	assert.True(t, validateEventNamePattern("user.*"))
+	assert.True(t, validateEventNamePattern("*"))
+	assert.True(t, validateEventNamePattern("user.[a-c]*"))
+	assert.False(t, validateEventNamePattern("user.["))
+	assert.False(t, validateEventNamePattern("user..*"))
+	assert.False(t, validateEventNamePattern(""))
+}
+
+func TestSetEventNames(t *testing.T) {
+	t.Parallel()
+
+	callback := func(ctx context.Context, event *Event, err error) error {
+		return nil
+	}
+
+	builder := NewSubscribe().
+		EventName("order.created").
+		HandleEvent("user.*", callback).
+		HandleEvent("item.?", callback).
+		HandleEvent("user.deleted", callback)
+	builder.setEventNames()
+
+	assert.Equal(t, []string{"order.created", "item.?", "user.*", "user.deleted"}, builder.subscribedEventNames())
+	assert.Equal(t, []string{"item.?", "user.*"}, builder.handlerPatterns())
+
+	// the event names aren't sorted again for every event
+	assert.Same(t, &builder.subscribedEventNames()[0], &builder.subscribedEventNames()[0])
+	assert.Same(t, &builder.handlerPatterns()[0], &builder.handlerPatterns()[0])
+
+	assert.True(t, builder.subscribes(&Event{EventName: "user.created"}))
+	assert.False(t, builder.subscribes(&Event{EventName: "order.deleted"}))
+}
+
+func TestSubscribeEventNames(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	published := []struct {
+		eventName string
+		namespace string
+	}{
+		{eventName: "user.created", namespace: "namespace"},
+		{eventName: "user.created", namespace: "skipped"},
+		{eventName: "user.deleted", namespace: "namespace"},
+		{eventName: "other", namespace: "namespace"},
+		{eventName: "order.created", namespace: "namespace"},
+	}
+
+	for i, event := range published {
+		err := client.PublishSync(
+			NewPublish().
+				Topic(topicName).
+				EventName(event.eventName).
+				Namespace(event.namespace).
+				Key(testKey).
+				EventID(i))
+		require.NoError(t, err)
+	}
+
+	received := make(chan *Event, 10)
+	handled := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("order.created").
+			EventNames("user.*").
+			HandleEvent("user.deleted", func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					handled <- event
+				}
+				return nil
+			}).
+			Filter(func(event *Event) bool {
+				return event.Namespace != "skipped"
+			}).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	var receivedIDs []int
+	for len(receivedIDs) < 2 {
+		select {
+		case event := <-received:
+			receivedIDs = append(receivedIDs, event.EventID)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "received: %v", receivedIDs)
+		}
+	}
+
+	assert.Equal(t, []int{0, 4}, receivedIDs, "the other and filtered events shouldn't be delivered")
+
+	require.Len(t, handled, 1, "the handler should receive its event before the last one is delivered")
+	assert.Equal(t, 2, (<-handled).EventID)
+}
+
+func TestSubscribeEventNamesInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventNames("user.[").
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidEventNameFormat)
+
+	_, err = client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			HandleEvent("user.*", func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.NoError(t, err, "a handler should be enough to subscribe")
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
@@ -28,12 +29,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	eventStreamNull   = "none"
 	eventStreamStdout = "stdout"
 	eventStreamKafka  = "kafka"
+	eventStreamMemory = "memory"
 
 	actorTypeUser   = "USER"
 	actorTypeClient = "CLIENT"
@@ -81,6 +84,9 @@ type Event struct {
 	Partition int    `json:",omitempty"`
 	Offset    int64  `json:",omitempty"`
 	Key       string `json:",omitempty"`
+
+	Headers        map[string]string `json:"-"` // headers of the kafka message
+	KafkaTimestamp time.Time         `json:"-"` // time of the kafka message, set on consume
 }
 
 var (
@@ -102,6 +108,37 @@ type BrokerConfig struct {
 	BaseReaderConfig *kafka.ReaderConfig
 
 	MetricsRegistry *prometheus.Registry // optional registry to report metrics to prometheus (used for kafka stats)
+
+	// DeliveryReports enables the Deliveries channel reporting the events published asynchronously.
+	// The reports are dropped while the channel is full.
+	DeliveryReports     bool
+	DeliveryReportsSize int // buffer of the Deliveries channel. default: 1000
+
+	// SpoolConfig enables the disk spool of the kafka stream, keeping the messages that can't be published while
+	// kafka is unreachable. optional
+	SpoolConfig *SpoolConfig
+
+	// retry policies, overridable per builder. default: the Default...RetryPolicy functions
+	PublishRetryPolicy   *RetryPolicy // Publish, PublishAsync and PublishBatch
+	AuditLogRetryPolicy  *RetryPolicy // PublishAuditLog
+	SubscribeRetryPolicy *RetryPolicy // redelivery of the events failed by the subscriber callback
+	FetchRetryPolicy     *RetryPolicy // subscriber fetch errors, the reader is recreated once it gives up
+
+	// PublishDeadLetter publishes the events given up by PublishAsync and PublishBatch to the dead letter topic
+	// <topic>.dlq, the error callback is still called
+	PublishDeadLetter bool
+
+	// TopicDiscoveryInterval is the interval of the discovery of the topics matching the TopicPattern of the
+	// subscribers. default: 30 seconds
+	TopicDiscoveryInterval time.Duration
+
+	// SubscriberStallThreshold is the time a running subscriber can go without fetching, e.g. while its callback
+	// hangs or its fetches fail, before Health reports it as stalled. default: 5 minutes
+	SubscriberStallThreshold time.Duration
+
+	// TracerProvider enables the OpenTelemetry spans of the publishes and the subscriber callbacks, their context
+	// is propagated through the W3C traceparent header of the events. optional
+	TracerProvider trace.TracerProvider
 }
 
 // SecurityConfig contains security configuration for message broker
@@ -137,6 +174,9 @@ type PublishBuilder struct {
 	errorCallback    func(event *Event, err error)
 	ctx              context.Context
 	timeout          time.Duration
+	retryPolicy      *RetryPolicy
+	headers          map[string]string
+	message          *kafka.Message
 }
 
 // NewPublish create new PublishBuilder instance
@@ -274,6 +314,23 @@ func (p *PublishBuilder) Payload(payload map[string]interface{}) *PublishBuilder
 	return p
 }
 
+// Header adds a header to the kafka message, e.g. routing or tracing metadata
+func (p *PublishBuilder) Header(key, value string) *PublishBuilder {
+	if p.headers == nil {
+		p.headers = make(map[string]string)
+	}
+	p.headers[key] = value
+	return p
+}
+
+// Headers adds the headers to the kafka message
+func (p *PublishBuilder) Headers(headers map[string]string) *PublishBuilder {
+	for key, value := range headers {
+		p.Header(key, value)
+	}
+	return p
+}
+
 // ErrorCallback function to handle the event when failed to publish
 func (p *PublishBuilder) ErrorCallback(errorCallback func(event *Event, err error)) *PublishBuilder {
 	p.errorCallback = errorCallback
@@ -293,6 +350,20 @@ func (p *PublishBuilder) Timeout(timeout time.Duration) *PublishBuilder {
 	return p
 }
 
+// RetryPolicy overrides the publish retry policy of the client for this event, PublishBatch uses the client one.
+// The Timeout, if set, replaces the budget of the policy.
+func (p *PublishBuilder) RetryPolicy(policy *RetryPolicy) *PublishBuilder {
+	p.retryPolicy = policy
+	return p
+}
+
+// Message publishes a message constructed beforehand by ConstructEvent, e.g. kept in an outbox, so the event
+// keeps its ID and timestamp. The topic and event name are still required, the other event fields are ignored.
+func (p *PublishBuilder) Message(message kafka.Message) *PublishBuilder {
+	p.message = &message
+	return p
+}
+
 // SubscribeBuilder defines the structure of message which is sent through message broker
 type SubscribeBuilder struct {
 	topic       string
@@ -300,8 +371,60 @@ type SubscribeBuilder struct {
 	offset      int64
 	callback    func(ctx context.Context, event *Event, err error) error
 	eventName   string
+	eventNames  []string
+	handlers    map[string]func(ctx context.Context, event *Event, err error) error
+	filter      func(event *Event) bool
 	ctx         context.Context
 	callbackRaw func(ctx context.Context, msgValue []byte, err error) error
+	retryPolicy *RetryPolicy
+
+	// topics and topicPattern subscribe more topics with the same reader. The internal subscriber of a topic of
+	// the memory stream has the builder of the subscriber as topicParent.
+	topics       []string
+	topicPattern *regexp.Regexp
+	topicParent  *SubscribeBuilder
+
+	// prefix is the topic prefix of the client, set on subscribe
+	prefix string
+
+	// tracing creates the spans of the callbacks, set on subscribe
+	tracing *tracing
+
+	// eventNamesOnSubscribe and handlerPatternsOnSubscribe are the subscribed event names and the glob patterns of
+	// the handlers, set on subscribe
+	eventNamesOnSubscribe      []string
+	handlerPatternsOnSubscribe []string
+
+	// startAt is the position the subscriber starts at, instead of offset
+	startAt SeekPosition
+
+	deadLetter         bool
+	deadLetterTopic    string
+	deadLetterAttempts int
+
+	// retryDelays are the delays of the retry topics. The internal subscriber of a retry topic has the builder of
+	// the subscribed topic as retryParent and the 1-based index of its retry topic as retryTier.
+	retryDelays []time.Duration
+	retryTier   int
+	retryParent *SubscribeBuilder
+
+	// callbackTyped decodes the message for SubscribeTyped, message is nil when the subscriber stops
+	callbackTyped func(ctx context.Context, message *kafka.Message, err error) error
+
+	// callbackDelivery enables the manual acknowledgement, see CallbackDelivery
+	callbackDelivery func(ctx context.Context, delivery *Delivery, err error) error
+	ackTimeout       time.Duration
+
+	concurrency int
+
+	// callbackBatch processes the events in batches, see CallbackBatch
+	callbackBatch func(ctx context.Context, events []*Event) error
+	batchSize     int
+	batchTimeout  time.Duration
+
+	// onPartitionsAssigned and onPartitionsRevoked are called when the group of the subscriber rebalances
+	onPartitionsAssigned func(ctx context.Context, generationID int32, partitions []TopicPartition)
+	onPartitionsRevoked  func(ctx context.Context, generationID int32, partitions []TopicPartition)
 }
 
 // NewSubscribe create new SubscribeBuilder instance
@@ -318,24 +441,80 @@ func (s *SubscribeBuilder) Topic(topic string) *SubscribeBuilder {
 	return s
 }
 
+// Topics subscribes several topics with the same reader, requires a GroupID
+func (s *SubscribeBuilder) Topics(topics ...string) *SubscribeBuilder {
+	s.topics = append(s.topics, topics...)
+	return s
+}
+
+// TopicPattern subscribes the topics matching the pattern with the same reader, requires a GroupID. The pattern
+// is matched against the topic names without the client prefix, the topics created afterwards are discovered
+// periodically, see BrokerConfig.TopicDiscoveryInterval.
+func (s *SubscribeBuilder) TopicPattern(pattern *regexp.Regexp) *SubscribeBuilder {
+	s.topicPattern = pattern
+	return s
+}
+
 // Offset set Offset of the event to start
 func (s *SubscribeBuilder) Offset(offset int64) *SubscribeBuilder {
 	s.offset = offset
 	return s
 }
 
+// StartAt starts the subscriber at the first events published at or after t, resolved for every partition.
+// The offsets of a group are committed for it when subscribing.
+func (s *SubscribeBuilder) StartAt(t time.Time) *SubscribeBuilder {
+	s.startAt.Time = t
+	return s
+}
+
+// StartAtOffsets starts the partitions of the subscriber at the offsets, they take precedence over StartAt.
+// The offsets of a group are committed for it when subscribing.
+func (s *SubscribeBuilder) StartAtOffsets(offsets map[int]int64) *SubscribeBuilder {
+	s.startAt.Offsets = offsets
+	return s
+}
+
 // GroupID set subscriber groupID or queue group name
 func (s *SubscribeBuilder) GroupID(groupID string) *SubscribeBuilder {
 	s.groupID = groupID
 	return s
 }
 
-// EventName set event name that will be subscribe
+// EventName set event name that will be subscribe, or a glob pattern of event names, e.g. user.*
 func (s *SubscribeBuilder) EventName(eventName string) *SubscribeBuilder {
 	s.eventName = eventName
 	return s
 }
 
+// EventNames subscribes more event names or glob patterns of event names with the same reader, their events are
+// delivered to Callback
+func (s *SubscribeBuilder) EventNames(eventNames ...string) *SubscribeBuilder {
+	s.eventNames = append(s.eventNames, eventNames...)
+	return s
+}
+
+// HandleEvent subscribes an event name or a glob pattern of event names with the same reader, its events are
+// delivered to the callback instead of Callback. An exact event name takes precedence over the patterns.
+func (s *SubscribeBuilder) HandleEvent(
+	eventName string,
+	callback func(ctx context.Context, event *Event, err error) error,
+) *SubscribeBuilder {
+	if s.handlers == nil {
+		s.handlers = make(map[string]func(ctx context.Context, event *Event, err error) error)
+	}
+
+	s.handlers[eventName] = callback
+	return s
+}
+
+// Filter only delivers the subscribed events for which the filter returns true, e.g. the events of a namespace.
+// The other events are skipped and committed.
+func (s *SubscribeBuilder) Filter(filter func(event *Event) bool) *SubscribeBuilder {
+	s.filter = filter
+	return s
+}
+
 // Callback to do when the event received
 func (s *SubscribeBuilder) Callback(
 	callback func(ctx context.Context, event *Event, err error) error,
@@ -352,6 +531,49 @@ func (s *SubscribeBuilder) CallbackRaw(
 	return s
 }
 
+// CallbackDelivery enables the manual acknowledgement: the callback receives a Delivery to settle with Ack or Nack,
+// e.g. once a worker pool processed the event, so the events can be acknowledged out of order. The offset committed
+// for each partition is the highest one acknowledged with all the offsets before it.
+// An error returned by the callback nacks the delivery with requeue.
+func (s *SubscribeBuilder) CallbackDelivery(
+	callback func(ctx context.Context, delivery *Delivery, err error) error,
+) *SubscribeBuilder {
+	s.callbackDelivery = callback
+	return s
+}
+
+// AckTimeout sets the time to settle a Delivery before it's requeued, see Delivery.Extend.
+// default: 1 minute
+func (s *SubscribeBuilder) AckTimeout(timeout time.Duration) *SubscribeBuilder {
+	s.ackTimeout = timeout
+	return s
+}
+
+// CallbackBatch processes the events in batches of up to BatchSize events, collected until BatchTimeout has passed.
+// The events of a batch are committed at once when the callback returns nil. When it returns a PartialBatchError
+// only its failed events are delivered again, otherwise the whole batch is, with the retry policy of the subscriber.
+// It takes precedence over Callback and CallbackRaw.
+func (s *SubscribeBuilder) CallbackBatch(
+	callback func(ctx context.Context, events []*Event) error,
+) *SubscribeBuilder {
+	s.callbackBatch = callback
+	return s
+}
+
+// BatchSize sets the max events of a CallbackBatch batch.
+// default: 100
+func (s *SubscribeBuilder) BatchSize(size int) *SubscribeBuilder {
+	s.batchSize = size
+	return s
+}
+
+// BatchTimeout sets the time to collect the events of a CallbackBatch batch once its first event is fetched.
+// default: 1 second
+func (s *SubscribeBuilder) BatchTimeout(timeout time.Duration) *SubscribeBuilder {
+	s.batchTimeout = timeout
+	return s
+}
+
 // Context define client context when subscribe event.
 // default: context.Background()
 func (s *SubscribeBuilder) Context(ctx context.Context) *SubscribeBuilder {
@@ -359,9 +581,71 @@ func (s *SubscribeBuilder) Context(ctx context.Context) *SubscribeBuilder {
 	return s
 }
 
+// RetryPolicy overrides the subscribe retry policy of the client for this subscriber.
+// An event failed by the callback is delivered again until the policy gives up, then it's skipped and committed.
+func (s *SubscribeBuilder) RetryPolicy(policy *RetryPolicy) *SubscribeBuilder {
+	s.retryPolicy = policy
+	return s
+}
+
+// DeadLetter publishes the events failed by the callback maxAttempts times to the dead letter topic and commits them,
+// so they don't block the partition. The dead letter topic is <topic>.dlq unless it's set by DeadLetterTopic.
+// maxAttempts 0 keeps the max attempts of the retry policy.
+func (s *SubscribeBuilder) DeadLetter(maxAttempts int) *SubscribeBuilder {
+	s.deadLetter = true
+	s.deadLetterAttempts = maxAttempts
+	return s
+}
+
+// DeadLetterTopic sets the dead letter topic of the subscriber and enables DeadLetter
+func (s *SubscribeBuilder) DeadLetterTopic(topic string) *SubscribeBuilder {
+	s.deadLetter = true
+	s.deadLetterTopic = topic
+	return s
+}
+
+// RetryTopics enables the non-blocking retries: an event failed by the callback is published to the retry topic
+// of the first delay, <topic>.retry.<delay>, and its offset is committed so it doesn't block the partition.
+// Internal subscribers deliver the events of a retry topic again to the callback once its delay has passed, an event
+// failed again goes to the next retry topic, then to the dead letter topic after the last one. Requires a GroupID.
+func (s *SubscribeBuilder) RetryTopics(delays ...time.Duration) *SubscribeBuilder {
+	s.retryDelays = delays
+	return s
+}
+
+// Concurrency processes the events with n workers. The events with the same key are processed by the same worker,
+// in order, while the other keys are processed in parallel. The offset committed for each partition is the one
+// before the lowest event that isn't processed yet, and the processing events are finished on rebalance or
+// unsubscribe. A failed event is retried by its worker, with the retry policy of the subscriber.
+// default: 1
+func (s *SubscribeBuilder) Concurrency(n int) *SubscribeBuilder {
+	s.concurrency = n
+	return s
+}
+
+// OnPartitionsAssigned calls f with the partitions assigned to the subscriber and the generation of its group,
+// before their events are fetched. The generation ID increases with every rebalance, so an assignment of an older
+// generation is stale. Requires a GroupID.
+func (s *SubscribeBuilder) OnPartitionsAssigned(
+	f func(ctx context.Context, generationID int32, partitions []TopicPartition),
+) *SubscribeBuilder {
+	s.onPartitionsAssigned = f
+	return s
+}
+
+// OnPartitionsRevoked calls f with the partitions revoked from the subscriber and the generation they were assigned
+// in, when its group rebalances or it stops. It's called once the callbacks of the events fetched from them finished
+// and their offsets are committed. Requires a GroupID.
+func (s *SubscribeBuilder) OnPartitionsRevoked(
+	f func(ctx context.Context, generationID int32, partitions []TopicPartition),
+) *SubscribeBuilder {
+	s.onPartitionsRevoked = f
+	return s
+}
+
 // Slug is a string describing a unique subscriber (topic, eventName, groupID)
 func (s *SubscribeBuilder) Slug() string {
-	return fmt.Sprintf("%s%s%s%s%s", s.topic, kafkaprometheus.SlugSeparator, s.eventName, kafkaprometheus.SlugSeparator, s.groupID)
+	return fmt.Sprintf("%s%s%s%s%s", s.subscribedTopic(), kafkaprometheus.SlugSeparator, s.subscribedEventName(), kafkaprometheus.SlugSeparator, s.groupID)
 }
 
 func NewClient(prefix, stream string, brokers []string, config ...*BrokerConfig) (Client, error) {
@@ -372,6 +656,8 @@ func NewClient(prefix, stream string, brokers []string, config ...*BrokerConfig)
 		return newStdoutClient(prefix), nil
 	case eventStreamKafka:
 		return newKafkaClient(brokers, prefix, config...)
+	case eventStreamMemory:
+		return newMemoryClient(prefix, config...), nil
 	default:
 		return nil, errors.New("unsupported stream")
 	}
@@ -380,9 +666,19 @@ func NewClient(prefix, stream string, brokers []string, config ...*BrokerConfig)
 // Client is an interface for event stream functionality
 type Client interface {
 	Publish(publishBuilder *PublishBuilder) error
+	PublishAsync(publishBuilder *PublishBuilder) (PublishResult, error)
 	PublishSync(publishBuilder *PublishBuilder) error
+	PublishBatch(publishBuilders ...*PublishBuilder) ([]BatchResult, error)
+	PublishBatchSync(publishBuilders ...*PublishBuilder) ([]BatchResult, error)
 	Register(subscribeBuilder *SubscribeBuilder) error
+	Subscribe(subscribeBuilder *SubscribeBuilder) (Subscription, error)
 	PublishAuditLog(auditLogBuilder *AuditLogBuilder) error
+	Deliveries() <-chan DeliveryReport
+	PauseAll()
+	ResumeAll()
+	Describe() ClientDescription
+	Health(ctx context.Context) HealthReport
+	Close(ctx context.Context) error
 }
 
 type AuditLog struct {
@@ -425,6 +721,7 @@ type AuditLogBuilder struct {
 	errorCallback PublishErrorCallbackFunc
 	ctx           context.Context
 	version       int
+	retryPolicy   *RetryPolicy
 }
 
 // NewAuditLogBuilder create new AuditLogBuilder instance
@@ -522,6 +819,13 @@ func (auditLogBuilder *AuditLogBuilder) Diff(diff *AuditLogDiff) *AuditLogBuilde
 	return auditLogBuilder
 }
 
+// Context defines the context of the audit log, the producer span of the publish is started from it.
+// default: context.Background()
+func (auditLogBuilder *AuditLogBuilder) Context(ctx context.Context) *AuditLogBuilder {
+	auditLogBuilder.ctx = ctx
+	return auditLogBuilder
+}
+
 func (auditLogBuilder *AuditLogBuilder) ErrorCallback(errCallback PublishErrorCallbackFunc) *AuditLogBuilder {
 	auditLogBuilder.errorCallback = errCallback
 	return auditLogBuilder
@@ -532,6 +836,12 @@ func (auditLogBuilder *AuditLogBuilder) Key(key string) *AuditLogBuilder {
 	return auditLogBuilder
 }
 
+// RetryPolicy overrides the audit log retry policy of the client for this audit log
+func (auditLogBuilder *AuditLogBuilder) RetryPolicy(policy *RetryPolicy) *AuditLogBuilder {
+	auditLogBuilder.retryPolicy = policy
+	return auditLogBuilder
+}
+
 func (auditLogBuilder *AuditLogBuilder) Build() (kafka.Message, error) {
 
 	id := generateID()
@@ -142,4 +142,8 @@ func main() {
 		logrus.Error(err)
 	}
 
+	err = client.Close(context.Background())
+	if err != nil {
+		logrus.Error(err)
+	}
 }
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSubscriberStallThreshold = 5 * time.Minute
+
+	healthCheckClient         = "client"
+	healthCheckBroker         = "broker"
+	healthCheckAuthentication = "authentication"
+	healthCheckMetadata       = "metadata"
+	healthCheckTopic          = "topic"
+	healthCheckSubscriber     = "subscriber"
+)
+
+var errTopicNotFound = errors.New("topic not found")
+
+// HealthReport is the result of Client.Health
+type HealthReport struct {
+	// Live is false when a subscriber is stalled, restarting the service may recover it
+	Live bool `json:"live"`
+
+	// Ready is false when a check failed, e.g. the brokers can't be reached
+	Ready bool `json:"ready"`
+
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HealthCheck is a check of Client.Health
+type HealthCheck struct {
+	// Name is the checked component: client, broker, authentication, metadata, topic or subscriber
+	Name string `json:"name"`
+
+	// Target is the checked topic, or the topics and the group of the checked subscriber
+	Target string `json:"target,omitempty"`
+
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Err returns an error listing the failed checks, nil if the client is ready
+func (report HealthReport) Err() error {
+	failures := make([]string, 0)
+	for _, check := range report.Checks {
+		if check.Healthy {
+			continue
+		}
+
+		name := check.Name
+		if check.Target != "" {
+			name += " " + check.Target
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %s", name, check.Error))
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("eventstream unhealthy: %s", strings.Join(failures, "; "))
+}
+
+// add appends the check, the report isn't ready anymore if it failed and isn't live if it's a stalled subscriber
+func (report *HealthReport) add(name, target string, err error) {
+	check := HealthCheck{Name: name, Target: target, Healthy: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+
+		report.Ready = false
+		if name == healthCheckSubscriber {
+			report.Live = false
+		}
+	}
+
+	report.Checks = append(report.Checks, check)
+}
+
+// newHealthReport returns a live and ready report without checks
+func newHealthReport() HealthReport {
+	return HealthReport{Live: true, Ready: true, Checks: []HealthCheck{}}
+}
+
+// fetchFailed records an error fetching an event, it's reported if the subscriber is stalled
+func (sub *subscription) fetchFailed(err error) {
+	sub.failed(err)
+
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	sub.fetchError = err
+}
+
+// polled records a fetch without event within the poll interval, the subscriber isn't stalled on an empty topic
+func (sub *subscription) polled() {
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	sub.lastFetchTime = time.Now()
+}
+
+// stalled returns an error if the running subscriber hasn't fetched within the threshold, e.g. its callback is
+// hanging or its fetches are failing. The last fetch error is wrapped.
+func (sub *subscription) stalled(threshold time.Duration) error {
+	if sub.State() != SubscriptionRunning {
+		return nil
+	}
+
+	sub.activityLock.Lock()
+	defer sub.activityLock.Unlock()
+
+	if time.Since(sub.lastFetchTime) <= threshold {
+		return nil
+	}
+
+	since := sub.lastFetchTime.Format(time.RFC3339)
+	if sub.fetchError != nil {
+		return fmt.Errorf("not fetching since %s: %w", since, sub.fetchError)
+	}
+
+	return fmt.Errorf("not fetching since %s", since)
+}
+
+// checkSubscribers adds a check of each consuming subscription, sorted by target
+func (report *HealthReport) checkSubscribers(subscriptions []*subscription, threshold time.Duration) {
+	type subscriberCheck struct {
+		target string
+		err    error
+	}
+
+	checks := make([]subscriberCheck, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		if sub.idle {
+			continue
+		}
+
+		target := strings.Join(sub.builder.subscribedTopics(), ",")
+		if sub.builder.topicPattern != nil {
+			target = sub.builder.topicPattern.String()
+		}
+		if sub.builder.groupID != "" {
+			target += " group " + sub.builder.groupID
+		}
+
+		checks = append(checks, subscriberCheck{target: target, err: sub.stalled(threshold)})
+	}
+
+	sort.SliceStable(checks, func(i, j int) bool {
+		return checks[i].target < checks[j].target
+	})
+
+	for _, check := range checks {
+		report.add(healthCheckSubscriber, check.target, check.err)
+	}
+}
+
+// isAuthenticationError returns true if the error is a failed SASL authentication or TLS handshake
+func isAuthenticationError(err error) bool {
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case kafka.SASLAuthenticationFailed, kafka.UnsupportedSASLMechanism, kafka.IllegalSASLState:
+			return true
+		}
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	return errors.As(err, &recordHeaderErr) || errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &certificateInvalidErr) || errors.As(err, &hostnameErr)
+}
+
+// subscriberHealthChecker is implemented by the clients checking their subscribers without the brokers
+type subscriberHealthChecker interface {
+	subscriberHealth() HealthReport
+}
+
+// LivenessHandler returns an http.Handler responding 503 when a subscriber of the client is stalled, see
+// HealthReport.Live. The brokers aren't checked, restarting the service doesn't fix them being unreachable.
+func LivenessHandler(client Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report HealthReport
+		if checker, ok := client.(subscriberHealthChecker); ok {
+			report = checker.subscriberHealth()
+		} else {
+			report = client.Health(r.Context())
+		}
+
+		writeHealthReport(w, report, report.Live)
+	})
+}
+
+// ReadinessHandler returns an http.Handler responding 503 when a check of the client failed, see
+// HealthReport.Ready
+func ReadinessHandler(client Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := client.Health(r.Context())
+		writeHealthReport(w, report, report.Ready)
+	})
+}
+
+// writeHealthReport renders the report as JSON with status 200 if healthy, 503 otherwise
+func writeHealthReport(w http.ResponseWriter, report HealthReport, healthy bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logrus.Error("unable to encode the health report: ", err)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkatest"
+)
+
+// healthCheck returns the check of the report with the name and target
+func healthCheck(report HealthReport, name, target string) (HealthCheck, bool) {
+	for _, check := range report.Checks {
+		if check.Name == name && check.Target == target {
+			return check, true
+		}
+	}
+
+	return HealthCheck{}, false
+}
+
+func TestKafkaClientHealth(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	broker := kafkatest.NewBroker(&kafkatest.BrokerConfig{DisableAutoCreateTopics: true})
+	defer broker.Close() // nolint: errcheck
+
+	client, err := NewClient(prefix, eventStreamKafka, broker.Addrs(), &BrokerConfig{DialTimeout: 2 * time.Second})
+	require.NoError(t, err)
+	defer client.Close(ctx) // nolint: errcheck
+
+	existingTopic := constructTopicTest()
+	missingTopic := constructTopicTest()
+	require.NoError(t, broker.CreateTopic(constructTopic(prefix, existingTopic), 1))
+
+	for _, topicName := range []string{existingTopic, missingTopic} {
+		err = client.Register(
+			NewSubscribe().
+				Topic(topicName).
+				EventName("testEvent").
+				Context(ctx).
+				Callback(func(ctx context.Context, event *Event, err error) error {
+					return nil
+				}))
+		require.NoError(t, err)
+	}
+
+	report := client.Health(ctx)
+	assert.False(t, report.Ready, "the client shouldn't be ready while a topic is missing")
+	assert.True(t, report.Live)
+
+	for _, name := range []string{healthCheckClient, healthCheckBroker, healthCheckMetadata} {
+		check, ok := healthCheck(report, name, "")
+		assert.True(t, ok, "the %s should be checked", name)
+		assert.True(t, check.Healthy, "the %s should be healthy", name)
+	}
+
+	_, ok := healthCheck(report, healthCheckAuthentication, "")
+	assert.False(t, ok, "the authentication shouldn't be checked without SASL nor TLS")
+
+	check, ok := healthCheck(report, healthCheckTopic, existingTopic)
+	require.True(t, ok)
+	assert.True(t, check.Healthy)
+
+	check, ok = healthCheck(report, healthCheckTopic, missingTopic)
+	require.True(t, ok)
+	assert.False(t, check.Healthy)
+	assert.Equal(t, errTopicNotFound.Error(), check.Error)
+
+	check, ok = healthCheck(report, healthCheckSubscriber, existingTopic)
+	require.True(t, ok)
+	assert.True(t, check.Healthy)
+
+	assert.ErrorContains(t, report.Err(), "topic "+missingTopic)
+}
+
+func TestKafkaClientHealthUnreachable(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	report := createInvalidKafkaClient(t).Health(ctx)
+	assert.False(t, report.Ready)
+	assert.True(t, report.Live, "an unreachable broker shouldn't fail the liveness")
+
+	check, ok := healthCheck(report, healthCheckBroker, "")
+	require.True(t, ok)
+	assert.False(t, check.Healthy)
+	assert.NotEmpty(t, check.Error)
+
+	_, ok = healthCheck(report, healthCheckMetadata, "")
+	assert.False(t, ok, "the metadata shouldn't be checked without a broker")
+	assert.Error(t, report.Err())
+}
+
+func TestLivenessHandlerUnreachable(t *testing.T) {
+	t.Parallel()
+
+	// a broker accepting connections without ever answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, errAccept := listener.Accept()
+		if errAccept == nil {
+			accepted <- struct{}{}
+			defer conn.Close()
+		}
+	}()
+
+	config := &BrokerConfig{DialTimeout: time.Minute, ReadTimeout: time.Minute}
+	client, err := NewClient(prefix, eventStreamKafka, []string{listener.Addr().String()}, config)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	LivenessHandler(client).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Less(t, time.Since(start), time.Second, "the liveness shouldn't wait for the broker")
+	assert.Empty(t, accepted, "the liveness shouldn't dial the broker")
+}
+
+func TestSubscriptionStalled(t *testing.T) {
+	t.Parallel()
+
+	sub := newSubscription(NewSubscribe().Topic(constructTopicTest()))
+	defer sub.cancel()
+
+	notFetchedFor := func(duration time.Duration) {
+		sub.activityLock.Lock()
+		sub.lastFetchTime = time.Now().Add(-duration)
+		sub.activityLock.Unlock()
+	}
+
+	assert.NoError(t, sub.stalled(time.Minute), "the subscriber shouldn't be stalled within the threshold")
+
+	notFetchedFor(time.Hour)
+	assert.Error(t, sub.stalled(time.Minute), "the subscriber should be stalled without fetching")
+
+	errFetch := errors.New("fetch failed")
+	sub.fetchFailed(errFetch)
+	assert.ErrorIs(t, sub.stalled(time.Minute), errFetch)
+
+	sub.polled()
+	assert.NoError(t, sub.stalled(time.Minute), "the subscriber shouldn't be stalled while it polls")
+
+	notFetchedFor(time.Hour)
+	sub.Pause()
+	assert.NoError(t, sub.stalled(time.Minute), "a paused subscriber shouldn't be stalled")
+	sub.Resume()
+	assert.NoError(t, sub.stalled(time.Minute), "the time paused shouldn't count")
+
+	notFetchedFor(time.Hour)
+	sub.fetched(kafka.Message{Time: time.Now()})
+	assert.NoError(t, sub.stalled(time.Minute), "the subscriber shouldn't be stalled once it fetches an event")
+}
+
+func TestSubscriberStalledCallback(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	stallThreshold := 2 * time.Second
+	config := &BrokerConfig{
+		StrictValidation:         true,
+		DialTimeout:              2 * time.Second,
+		ReadTimeout:              2 * time.Second,
+		WriteTimeout:             2 * time.Second,
+		SubscriberStallThreshold: stallThreshold,
+	}
+	client, err := NewClient(prefix, testStream(), testBrokers(), config)
+	require.NoError(t, err)
+	defer client.Close(context.Background())
+
+	topic := constructTopicTest()
+	createTestTopic(t, topic)
+
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	err = client.Register(
+		NewSubscribe().
+			Topic(topic).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				received <- struct{}{}
+				<-release
+
+				return nil
+			}))
+	require.NoError(t, err)
+
+	time.Sleep(stallThreshold + time.Second)
+	assert.True(t, client.Health(ctx).Live, "an idle subscriber shouldn't be stalled")
+
+	err = client.Publish(
+		NewPublish().
+			Topic(topic).
+			EventName("testEvent").
+			Context(ctx))
+	require.NoError(t, err)
+
+	select {
+	case <-received:
+	case <-ctx.Done():
+		require.FailNow(t, errorTimeout)
+	}
+
+	assert.Eventually(t, func() bool {
+		return !client.Health(ctx).Live
+	}, 3*stallThreshold, 100*time.Millisecond, "a hanging callback should stall the subscriber")
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return client.Health(ctx).Live
+	}, 3*stallThreshold, 100*time.Millisecond, "the subscriber should recover once the callback returns")
+}
+
+func TestHealthHandlers(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createMemoryClient(t)
+
+	handle, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	require.NoError(t, err)
+
+	serve := func(handler http.Handler) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(LivenessHandler(client)))
+	assert.Equal(t, http.StatusOK, serve(ReadinessHandler(client)))
+
+	sub, ok := handle.(*subscription)
+	require.True(t, ok)
+
+	sub.fetchFailed(errors.New("fetch failed"))
+	sub.activityLock.Lock()
+	sub.lastFetchTime = time.Now().Add(-time.Hour)
+	sub.activityLock.Unlock()
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(LivenessHandler(client)), "a stalled subscriber should fail")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(ReadinessHandler(client)))
+
+	sub.fetched(kafka.Message{Time: time.Now()})
+	require.NoError(t, client.Close(ctx))
+
+	assert.Equal(t, http.StatusOK, serve(LivenessHandler(client)), "a closed client should still be live")
+	assert.Equal(t, http.StatusServiceUnavailable, serve(ReadinessHandler(client)), "a closed client isn't ready")
+}
@@ -23,23 +23,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
-	"github.com/cenkalti/backoff"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/scram"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	defaultReaderSize     = 10e6 // 10MB
-	maxBackOffCount       = 4
-	kafkaMaxWait          = time.Second // (for consumer message batching)
-	saslScramAuth         = "SASL-SCRAM"
-	defaultPublishTimeout = 60 * time.Second
+	defaultReaderSize = 10e6        // 10MB
+	kafkaMaxWait      = time.Second // (for consumer message batching)
+	saslScramAuth     = "SASL-SCRAM"
 
 	auditLogTopicEnvKey  = "APP_EVENT_STREAM_AUDIT_LOG_TOPIC"
 	auditLogEnableEnvKey = "APP_EVENT_STREAM_AUDIT_LOG_ENABLED"
@@ -51,6 +49,11 @@ var (
 	auditEnabled   = true
 	errPubNilEvent = errors.New("unable to publish nil event")
 	errSubNilEvent = errors.New("unable to subscribe nil event")
+
+	errPubSyncTopics = errors.New("incorrect number of topics for sync publish")
+
+	// ErrClientClosed is returned when publishing or subscribing through a closed client
+	ErrClientClosed = errors.New("eventstream client is closed")
 )
 
 // KafkaClient wraps client's functionality for Kafka
@@ -69,16 +72,67 @@ type KafkaClient struct {
 	subscribeConfig kafka.ReaderConfig
 
 	// current subscribers
-	readers map[string]*kafka.Reader
+	readers map[string]statsReader
 
 	// current writers
 	writers map[string]*kafka.Writer
 
+	// writersClosed is set by Close once the writers are closed, guarded by WritersLock
+	writersClosed bool
+
+	// activity of the writers of the topics, reported by Describe, guarded by WritersLock. It's dropped once the
+	// writer is closed and its writes are finished, unless the last one failed so its error is reported.
+	writerActivities map[string]*writerActivity
+
 	// mutex to avoid runtime races to access subscribers map
 	ReadersLock sync.RWMutex
 
 	// mutex to avoid runtime races to access writers map
 	WritersLock sync.RWMutex
+
+	// cancelled when the client gives up on pending publishes during Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// closed is set by Close, guarded by closeLock
+	closed    bool
+	closeLock sync.RWMutex
+
+	// pending publishes, waited for by Close
+	publishers sync.WaitGroup
+
+	// running subscriptions, stopped by Close
+	subscribers   sync.WaitGroup
+	subscriptions map[*subscription]struct{}
+
+	// reports of the asynchronous publishes, nil unless enabled in the config
+	deliveries chan DeliveryReport
+
+	// disk spool of the messages that couldn't be published, nil unless enabled in the config
+	spool          *diskSpool
+	spoolCancel    context.CancelFunc
+	spoolForwarder sync.WaitGroup
+
+	// retry policies of the client, the builders can override them
+	publishRetryPolicy   *RetryPolicy
+	auditLogRetryPolicy  *RetryPolicy
+	subscribeRetryPolicy *RetryPolicy
+	fetchRetryPolicy     *RetryPolicy
+
+	// retries reported to prometheus, nil unless a metrics registry is configured
+	retryMetrics *kafkaprometheus.RetryCollector
+
+	// publish the events given up by the asynchronous publishes to their dead letter topic
+	publishDeadLetter bool
+
+	// interval of the discovery of the topics matching the pattern of the subscribers
+	topicDiscoveryInterval time.Duration
+
+	// time a subscriber can go without fetching before Health reports it
+	subscriberStallThreshold time.Duration
+
+	// spans of the publishes and the subscribers, nil unless a TracerProvider is configured
+	tracing *tracing
 }
 
 // setConfig sets some defaults for producers and consumers. Needed for backwards compatibility.
@@ -169,15 +223,70 @@ func newKafkaClient(brokers []string, prefix string, configList ...*BrokerConfig
 
 	config, err := setConfig(configList, brokers)
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	client := &KafkaClient{
 		prefix:           prefix,
 		strictValidation: config.StrictValidation,
 		publishConfig:    *config.BaseWriterConfig,
 		subscribeConfig:  *config.BaseReaderConfig,
-		readers:          make(map[string]*kafka.Reader),
+		readers:          make(map[string]statsReader),
 		writers:          make(map[string]*kafka.Writer),
+		writerActivities: make(map[string]*writerActivity),
+		ctx:              ctx,
+		cancel:           cancel,
+		subscriptions:    make(map[*subscription]struct{}),
+
+		publishRetryPolicy:   retryPolicyOrDefault(config.PublishRetryPolicy, DefaultPublishRetryPolicy),
+		auditLogRetryPolicy:  retryPolicyOrDefault(config.AuditLogRetryPolicy, DefaultAuditLogRetryPolicy),
+		subscribeRetryPolicy: retryPolicyOrDefault(config.SubscribeRetryPolicy, DefaultSubscribeRetryPolicy),
+		fetchRetryPolicy:     retryPolicyOrDefault(config.FetchRetryPolicy, DefaultFetchRetryPolicy),
+
+		publishDeadLetter:      config.PublishDeadLetter,
+		topicDiscoveryInterval: config.TopicDiscoveryInterval,
+
+		subscriberStallThreshold: config.SubscriberStallThreshold,
+
+		tracing: newTracing(config.TracerProvider),
+	}
+	if client.topicDiscoveryInterval <= 0 {
+		client.topicDiscoveryInterval = defaultTopicDiscoveryInterval
+	}
+	if client.subscriberStallThreshold <= 0 {
+		client.subscriberStallThreshold = defaultSubscriberStallThreshold
+	}
+	if config.DeliveryReports {
+		size := config.DeliveryReportsSize
+		if size <= 0 {
+			size = defaultDeliveryReportsSize
+		}
+		client.deliveries = make(chan DeliveryReport, size)
+	}
+	if config.SpoolConfig != nil {
+		client.spool, err = openSpool(*config.SpoolConfig)
+		if err != nil {
+			logrus.Error("unable to open spool: ", err)
+			return client, err
+		}
+
+		spoolCtx, spoolCancel := context.WithCancel(context.Background())
+		client.spoolCancel = spoolCancel
+		client.spoolForwarder.Add(1)
+
+		go client.forwardSpool(spoolCtx)
 	}
 	if config.MetricsRegistry != nil {
+		if client.spool != nil {
+			err = config.MetricsRegistry.Register(&kafkaprometheus.SpoolCollector{Client: client})
+			if err != nil {
+				logrus.Errorf("failed to register spool metrics: %v", err)
+			}
+		}
+		client.retryMetrics = kafkaprometheus.NewRetryCollector()
+		err = config.MetricsRegistry.Register(client.retryMetrics)
+		if err != nil {
+			logrus.Errorf("failed to register retry metrics: %v", err)
+		}
 		err = config.MetricsRegistry.Register(&kafkaprometheus.WriterCollector{Client: client})
 		if err != nil {
 			logrus.Errorf("failed to register kafka writers metrics: %v", err)
@@ -186,15 +295,26 @@ func newKafkaClient(brokers []string, prefix string, configList ...*BrokerConfig
 		if err != nil {
 			logrus.Errorf("failed to register kafka Readers metrics: %v", err)
 		}
+		err = config.MetricsRegistry.Register(&kafkaprometheus.SubscriberCollector{Client: client})
+		if err != nil {
+			logrus.Errorf("failed to register subscriber metrics: %v", err)
+		}
 	}
 	return client, err
 }
 
 // Publish send event to single or multiple topic with exponential backoff retry
 func (client *KafkaClient) Publish(publishBuilder *PublishBuilder) error {
+	_, err := client.PublishAsync(publishBuilder)
+	return err
+}
+
+// PublishAsync send event to single or multiple topic with exponential backoff retry.
+// The returned result completes with a delivery report per topic once the event is published or given up.
+func (client *KafkaClient) PublishAsync(publishBuilder *PublishBuilder) (PublishResult, error) {
 	if publishBuilder == nil {
 		logrus.Error(errPubNilEvent)
-		return errPubNilEvent
+		return nil, errPubNilEvent
 	}
 
 	err := validatePublishEvent(publishBuilder, client.strictValidation)
@@ -203,7 +323,7 @@ func (client *KafkaClient) Publish(publishBuilder *PublishBuilder) error {
 			WithField("Topic Name", publishBuilder.topic).
 			WithField("Event Name", publishBuilder.eventName).
 			Error("incorrect publisher event: ", err)
-		return err
+		return nil, err
 	}
 
 	message, event, err := ConstructEvent(publishBuilder)
@@ -212,7 +332,7 @@ func (client *KafkaClient) Publish(publishBuilder *PublishBuilder) error {
 			WithField("Topic Name", publishBuilder.topic).
 			WithField("Event Name", publishBuilder.eventName).
 			Error("unable to construct event: ", err)
-		return fmt.Errorf("unable to construct event : %s , error : %v", publishBuilder.eventName, err)
+		return nil, fmt.Errorf("unable to construct event : %s , error : %v", publishBuilder.eventName, err)
 	}
 
 	config := client.publishConfig
@@ -222,48 +342,91 @@ func (client *KafkaClient) Publish(publishBuilder *PublishBuilder) error {
 		logrus.Warnf("eventstream got more than 1 topic per publish: %+v", publishBuilder.topic)
 	}
 
+	if err = client.addPublishers(len(publishBuilder.topic)); err != nil {
+		return nil, err
+	}
+
+	result := newPublishResult(event, len(publishBuilder.topic))
+
+	policy := retryPolicyOrDefault(publishBuilder.retryPolicy, func() *RetryPolicy { return client.publishRetryPolicy })
+	if publishBuilder.timeout > 0 {
+		policy = policy.withBudget(publishBuilder.timeout)
+	}
+
 	for _, pubTopic := range publishBuilder.topic {
 		topic := constructTopic(client.prefix, pubTopic)
 
-		if publishBuilder.timeout == 0 {
-			publishBuilder.timeout = defaultPublishTimeout
-		}
-
 		go func(topic string) {
-			publishCtx, cancelPublish := context.WithTimeout(context.Background(), publishBuilder.timeout)
+			defer client.publishers.Done()
+
+			// the span covers the retries of the publish, its context is sent in the headers of the message
+			message, endSpan := client.tracing.startPublishMessage(publishBuilder.ctx, topic, message)
+
+			if client.spoolFirst(topic, message) {
+				endSpan(nil)
+
+				report := newSpooledReport(event.ID, topic, 0)
+				sendDeliveryReport(client.deliveries, report)
+				result.report(report)
+
+				return
+			}
+
+			publishCtx, cancelPublish := policy.retryContext(client.ctx)
 			defer cancelPublish()
 
-			err = backoff.RetryNotify(func() error {
+			attempts := 0
+			var delivery *messageDelivery
+
+			err := retry(publishCtx, policy, func() error {
+				attempts++
+				delivery = &messageDelivery{}
+
+				message := message
+				message.WriterData = delivery
+				message.Time = time.Now()
+
 				return client.publishEvent(publishCtx, topic, publishBuilder.eventName, config, message)
-			}, backoff.WithContext(newPublishBackoff(), publishCtx),
-				func(err error, d time.Duration) {
-					logrus.
-						WithField("Topic Name", topic).
-						WithField("Event Name", publishBuilder.eventName).
-						WithField("backoff-duration", d).
-						Warn("retrying publish event: ", err)
-				})
-			if err != nil {
+			}, func(err error, attempt int, delay time.Duration) {
+				client.retryMetrics.ObserveRetry(retryOperationPublish, topic, attempt)
+				logrus.
+					WithField("Topic Name", topic).
+					WithField("Event Name", publishBuilder.eventName).
+					WithField("Attempt", attempt).
+					WithField("backoff-duration", delay).
+					Warn("retrying publish event: ", err)
+			})
+			endSpan(err)
+
+			report := newDeliveryReport(event.ID, topic, delivery, attempts, err)
+
+			switch {
+			case err == nil:
+				logrus.
+					WithField("Topic Name", topic).
+					WithField("Event Name", publishBuilder.eventName).
+					Debug("successfully publish event")
+			case client.spoolMessage(topic, message, err):
+				report = newSpooledReport(event.ID, topic, attempts)
+			default:
 				logrus.
 					WithField("Topic Name", topic).
 					WithField("Event Name", publishBuilder.eventName).
 					Error("giving up publishing event: ", err)
 
+				report.DeadLettered = client.deadLetterPublish(topic, publishBuilder.eventName, message, attempts, err)
+
 				if publishBuilder.errorCallback != nil {
 					publishBuilder.errorCallback(event, err)
 				}
-
-				return
 			}
 
-			logrus.
-				WithField("Topic Name", topic).
-				WithField("Event Name", publishBuilder.eventName).
-				Debug("successfully publish event")
+			sendDeliveryReport(client.deliveries, report)
+			result.report(report)
 		}(topic)
 	}
 
-	return nil
+	return result, nil
 }
 
 // PublishSync send an event synchronously (blocking, without retry)
@@ -293,17 +456,201 @@ func (client *KafkaClient) PublishSync(publishBuilder *PublishBuilder) error {
 
 	config := client.publishConfig
 	if len(publishBuilder.topic) != 1 {
-		return fmt.Errorf("incorrect number of topics for sync publish")
+		return errPubSyncTopics
 	}
 
 	topic := constructTopic(client.prefix, publishBuilder.topic[0])
 
-	return client.publishEvent(publishBuilder.ctx, topic, publishBuilder.eventName, config, message)
+	if err = client.addPublishers(1); err != nil {
+		return err
+	}
+	defer client.publishers.Done()
+
+	message, endSpan := client.tracing.startPublishMessage(publishBuilder.ctx, topic, message)
+
+	err = client.publishEvent(publishBuilder.ctx, topic, publishBuilder.eventName, config, message)
+	endSpan(err)
+
+	return err
+}
+
+// PublishBatch send events with exponential backoff retry, writing the messages of each topic with a single
+// WriteMessages call. The results report the invalid events, the valid ones are published in the background and
+// the ErrorCallback of their publish builder is called if they can't be published.
+func (client *KafkaClient) PublishBatch(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	results, groups := constructBatch(client.prefix, client.strictValidation, publishBuilders)
+	if len(groups) == 0 {
+		return results, batchError(results)
+	}
+
+	if err := client.addPublishers(len(groups)); err != nil {
+		failBatch(results, err)
+		return results, err
+	}
+
+	config := client.publishConfig
+
+	for _, group := range groups {
+		// the longest timeout of the events, if any, replaces the budget of the policy
+		policy := client.publishRetryPolicy
+		var timeout time.Duration
+		for _, batchMsg := range group.messages {
+			if publishTimeout := publishBuilders[batchMsg.index].timeout; publishTimeout > timeout {
+				timeout = publishTimeout
+			}
+		}
+		if timeout > 0 {
+			policy = policy.withBudget(timeout)
+		}
+
+		go func(group *batchGroup, policy *RetryPolicy) {
+			defer client.publishers.Done()
+
+			// the span covers the retries of the batch, its context is sent in the headers of the messages
+			endSpan := client.tracing.startPublishBatch(publishBuilders[group.messages[0].index].ctx, group)
+
+			// keep the order of the spooled messages, they are forwarded first
+			pending := &batchGroup{topic: group.topic}
+			for _, batchMsg := range group.messages {
+				if len(pending.messages) == 0 && client.spoolFirst(group.topic, batchMsg.message) {
+					batchMsg.spooled = true
+					continue
+				}
+				pending.messages = append(pending.messages, batchMsg)
+			}
+
+			publishCtx, cancelPublish := policy.retryContext(client.ctx)
+			defer cancelPublish()
+
+			var err error
+			if len(pending.messages) > 0 {
+				err = retry(publishCtx, policy, func() error {
+					messages := pending.kafkaMessages()
+					for i, batchMsg := range pending.messages {
+						batchMsg.attempts++
+						batchMsg.delivery = &messageDelivery{}
+						messages[i].WriterData = batchMsg.delivery
+						messages[i].Time = time.Now()
+					}
+
+					err := client.publishEvent(publishCtx, group.topic, "", config, messages...)
+					if err != nil {
+						// only retry the failed messages
+						pending = &batchGroup{topic: group.topic, messages: pending.setError(err)}
+					}
+
+					return err
+				}, func(err error, attempt int, delay time.Duration) {
+					client.retryMetrics.ObserveRetry(retryOperationPublishBatch, group.topic, attempt)
+					logrus.
+						WithField("Topic Name", group.topic).
+						WithField("Event Count", len(pending.messages)).
+						WithField("Attempt", attempt).
+						WithField("backoff-duration", delay).
+						Warn("retrying publish batch: ", err)
+				})
+			}
+			endSpan(err)
+
+			if err != nil {
+				for _, batchMsg := range pending.messages {
+					if client.spoolMessage(group.topic, batchMsg.message, batchMsg.err) {
+						batchMsg.spooled = true
+						continue
+					}
+
+					batchMsg.deadLettered = client.deadLetterPublish(group.topic, batchMsg.event.EventName,
+						batchMsg.message, batchMsg.attempts, batchMsg.err)
+
+					batchMessageFailed(publishBuilders[batchMsg.index], group.topic, batchMsg.event, batchMsg.err)
+				}
+			} else {
+				logrus.
+					WithField("Topic Name", group.topic).
+					WithField("Event Count", len(group.messages)).
+					Debug("successfully publish batch")
+			}
+
+			for _, batchMsg := range group.messages {
+				report := newDeliveryReport(batchMsg.event.ID, group.topic, batchMsg.delivery, batchMsg.attempts,
+					batchMsg.err)
+				if batchMsg.spooled {
+					report = newSpooledReport(batchMsg.event.ID, group.topic, batchMsg.attempts)
+				}
+				report.DeadLettered = batchMsg.deadLettered
+
+				sendDeliveryReport(client.deliveries, report)
+			}
+		}(group, policy)
+	}
+
+	return results, batchError(results)
+}
+
+// PublishBatchSync send events synchronously (blocking, without retry), writing the messages of each topic with
+// a single WriteMessages call. The results report the events that weren't published, the ErrorCallback of their
+// publish builder is called if they failed to be written.
+func (client *KafkaClient) PublishBatchSync(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	results, groups := constructBatch(client.prefix, client.strictValidation, publishBuilders)
+	if len(groups) == 0 {
+		return results, batchError(results)
+	}
+
+	if err := client.addPublishers(1); err != nil {
+		failBatch(results, err)
+		return results, err
+	}
+	defer client.publishers.Done()
+
+	config := client.publishConfig
+
+	for _, group := range groups {
+		ctx := publishBuilders[group.messages[0].index].ctx
+		endSpan := client.tracing.startPublishBatch(ctx, group)
+
+		err := client.publishEvent(ctx, group.topic, "", config, group.kafkaMessages()...)
+		endSpan(err)
+
+		if err == nil {
+			continue
+		}
+
+		for _, batchMsg := range group.setError(err) {
+			if results[batchMsg.index].Err == nil {
+				results[batchMsg.index].Err = batchMsg.err
+			}
+
+			batchMessageFailed(publishBuilders[batchMsg.index], group.topic, batchMsg.event, batchMsg.err)
+		}
+	}
+
+	return results, batchError(results)
+}
+
+// Deliveries returns the reports of the events published asynchronously, nil if delivery reports aren't enabled
+// in the config. The channel is closed by Close once the pending publishes are done.
+func (client *KafkaClient) Deliveries() <-chan DeliveryReport {
+	return client.deliveries
+}
+
+// addPublishers registers pending publishes so Close can wait for them.
+// Returns ErrClientClosed if the client no longer accepts new publishes.
+func (client *KafkaClient) addPublishers(n int) error {
+	client.closeLock.RLock()
+	defer client.closeLock.RUnlock()
+
+	if client.closed {
+		return ErrClientClosed
+	}
+
+	client.publishers.Add(n)
+
+	return nil
 }
 
-// Publish send event to a topic
+// Publish send events to a topic
 func (client *KafkaClient) publishEvent(ctx context.Context, topic, eventName string, config kafka.WriterConfig,
-	message kafka.Message) (err error) {
+	messages ...kafka.Message) (err error) {
 	writer := &kafka.Writer{}
 
 	logFields := logrus.
@@ -331,13 +678,26 @@ func (client *KafkaClient) publishEvent(ctx context.Context, topic, eventName st
 	}()
 
 	config.Topic = topic
-	writer = client.getWriter(config)
-	err = writer.WriteMessages(ctx, message)
+
+	finishWrite := client.startWrite(topic, len(messages))
+	defer func() {
+		finishWrite(err)
+	}()
+
+	writer, err = client.getWriter(config)
+	if err != nil {
+		return err
+	}
+
+	err = writer.WriteMessages(ctx, messages...)
 	if err != nil {
 		if errors.Is(err, io.ErrClosedPipe) {
 			// new a writer and retry
-			writer = client.newWriter(config)
-			err = writer.WriteMessages(ctx, message)
+			if writer, err = client.newWriter(config); err != nil {
+				return err
+			}
+
+			err = writer.WriteMessages(ctx, messages...)
 		}
 
 		if err != nil {
@@ -363,26 +723,51 @@ func (client *KafkaClient) PublishAuditLog(auditLogBuilder *AuditLogBuilder) err
 		if err != nil {
 			return err
 		}
-		return client.publishAndRetryFailure(context.Background(), topic, "", message, auditLogBuilder.errorCallback)
+		policy := retryPolicyOrDefault(auditLogBuilder.retryPolicy, func() *RetryPolicy { return client.auditLogRetryPolicy })
+
+		return client.publishAndRetryFailure(auditLogBuilder.ctx, topic, "", message, policy, auditLogBuilder.errorCallback)
 	}
 	return nil
 }
 
-// publishAndRetryFailure will publish message to kafka, if it fails, will retry at most 3 times.
+// publishAndRetryFailure will publish message to kafka, if it fails, will retry with the retry policy.
 // If the message finally failed to publish, will call the error callback function to process this failure.
-func (client *KafkaClient) publishAndRetryFailure(context context.Context, topic, eventName string, message kafka.Message, failureCallback PublishErrorCallbackFunc) error {
+// The producer span is started from ctx, the retries are stopped once the client is closed.
+func (client *KafkaClient) publishAndRetryFailure(ctx context.Context, topic, eventName string, message kafka.Message, policy *RetryPolicy, failureCallback PublishErrorCallbackFunc) error {
 
 	config := client.publishConfig
 	topic = constructTopic(client.prefix, topic)
 
+	if err := client.addPublishers(1); err != nil {
+		return err
+	}
+
 	go func() {
-		err := backoff.RetryNotify(func() error {
-			return client.publishEvent(context, topic, eventName, config, message)
-		}, backoff.WithMaxRetries(newPublishBackoff(), maxBackOffCount),
-			func(err error, _ time.Duration) {
-				logrus.WithField("topic", topic).
-					Warn("retrying publish message: ", err)
-			})
+		defer client.publishers.Done()
+
+		message, endSpan := client.tracing.startPublishMessage(ctx, topic, message)
+
+		if client.spoolFirst(topic, message) {
+			endSpan(nil)
+			return
+		}
+
+		publishCtx, cancelPublish := policy.retryContext(client.ctx)
+		defer cancelPublish()
+
+		err := retry(publishCtx, policy, func() error {
+			return client.publishEvent(publishCtx, topic, eventName, config, message)
+		}, func(err error, attempt int, _ time.Duration) {
+			client.retryMetrics.ObserveRetry(retryOperationAuditLog, topic, attempt)
+			logrus.WithField("topic", topic).
+				WithField("Attempt", attempt).
+				Warn("retrying publish message: ", err)
+		})
+		endSpan(err)
+
+		if err != nil && client.spoolMessage(topic, message, err) {
+			return
+		}
 		if err != nil {
 			logrus.WithField("topic", topic).
 				Error("retrying publish message failed: ", err)
@@ -399,8 +784,122 @@ func (client *KafkaClient) publishAndRetryFailure(context context.Context, topic
 	return nil
 }
 
+// newAckTracker creates the tracker of a manual acknowledgement or concurrent subscriber,
+// committing the offsets with the reader
+func (client *KafkaClient) newAckTracker(sub *subscription, reader messageReader, groupID string,
+	policy *RetryPolicy, loggerFields *logrus.Entry) *ackTracker {
+	tracker := newAckTracker(sub, groupID, policy, loggerFields)
+	tracker.retryMetrics = client.retryMetrics
+
+	tracker.commit = func(message kafka.Message) {
+		if groupID == "" {
+			// offsets are only committed for consumer groups
+			return
+		}
+
+		if err := reader.CommitMessages(sub.ctx, message); err != nil {
+			loggerFields.Error("unable to commit the event: ", err)
+		}
+	}
+
+	tracker.publish = func(publishTopic string, message kafka.Message) error {
+		return client.publishWithRetry(sub.ctx, constructTopic(client.prefix, publishTopic),
+			sub.builder.subscribedEventName(), message, client.publishRetryPolicy)
+	}
+
+	return tracker
+}
+
+// newBatchConsumer creates the batch consumer of a CallbackBatch subscriber, fetching and committing with the reader
+func (client *KafkaClient) newBatchConsumer(sub *subscription, reader messageReader, groupID string,
+	policy *RetryPolicy, loggerFields *logrus.Entry) *batchConsumer {
+	return &batchConsumer{
+		sub:          sub,
+		groupID:      groupID,
+		policy:       policy,
+		loggerFields: loggerFields,
+		retryMetrics: client.retryMetrics,
+		fetch:        reader.FetchMessage,
+		commit: func(messages []kafka.Message) {
+			if groupID == "" {
+				// offsets are only committed for consumer groups
+				return
+			}
+
+			// the callback context is used, so a processed batch is committed even when unsubscribing
+			if err := reader.CommitMessages(sub.ctx, messages...); err != nil {
+				loggerFields.Error("unable to commit the batch: ", err)
+			}
+		},
+		publish: func(publishTopic string, message kafka.Message) error {
+			return client.publishWithRetry(sub.ctx, constructTopic(client.prefix, publishTopic),
+				sub.builder.subscribedEventName(), message, client.publishRetryPolicy)
+		},
+	}
+}
+
+// deadLetterPublish publishes a message given up by a publish to the dead letter topic of its topic if
+// PublishDeadLetter is enabled. It returns true if the message is published.
+func (client *KafkaClient) deadLetterPublish(topic, eventName string, message kafka.Message, attempts int, err error) bool {
+	if !client.publishDeadLetter {
+		return false
+	}
+
+	letter := deadLetter{
+		topic:     topic,
+		partition: -1,
+		offset:    -1,
+		attempts:  attempts,
+		err:       err,
+	}
+
+	// kafka is likely unreachable if the publish failed for a while, so it's only attempted once
+	return client.publishWithRetry(client.ctx, DeadLetterTopicName(topic), eventName,
+		newDeadLetterMessage(message, letter), &RetryPolicy{MaxAttempts: 1}) == nil
+}
+
+// publishWithRetry publishes a message forwarded by a subscriber or a publish, e.g. to a dead letter topic,
+// and waits until it's published or the policy gives up
+func (client *KafkaClient) publishWithRetry(ctx context.Context, topic, eventName string, message kafka.Message,
+	policy *RetryPolicy) error {
+	loggerFields := logrus.
+		WithField("Topic Name", topic).
+		WithField("Event Name", eventName)
+
+	publishCtx, cancelPublish := policy.retryContext(ctx)
+	defer cancelPublish()
+
+	err := retry(publishCtx, policy, func() error {
+		return client.publishEvent(publishCtx, topic, eventName, client.publishConfig, message)
+	}, func(err error, attempt int, delay time.Duration) {
+		client.retryMetrics.ObserveRetry(retryOperationPublish, topic, attempt)
+		loggerFields.
+			WithField("Attempt", attempt).
+			WithField("backoff-duration", delay).
+			Warn("retrying publish message: ", err)
+	})
+	if err != nil {
+		loggerFields.Error("unable to publish message: ", err)
+		return err
+	}
+
+	loggerFields.Debug("successfully publish message")
+
+	return nil
+}
+
 // ConstructEvent construct event message
 func ConstructEvent(publishBuilder *PublishBuilder) (kafka.Message, *Event, error) {
+	if publishBuilder.message != nil {
+		event := &Event{}
+		if err := json.Unmarshal(publishBuilder.message.Value, event); err != nil {
+			return kafka.Message{}, event, err
+		}
+		event.Headers = eventHeaders(publishBuilder.message.Headers)
+
+		return *publishBuilder.message, event, nil
+	}
+
 	id := generateID()
 	key := publishBuilder.key
 	if publishBuilder.key == "" {
@@ -428,6 +927,7 @@ func ConstructEvent(publishBuilder *PublishBuilder) (kafka.Message, *Event, erro
 		Privacy:          publishBuilder.privacy,
 		AdditionalFields: publishBuilder.additionalFields,
 		Payload:          publishBuilder.payload,
+		Headers:          publishBuilder.headers,
 	}
 
 	eventBytes, err := marshal(event)
@@ -436,11 +936,46 @@ func ConstructEvent(publishBuilder *PublishBuilder) (kafka.Message, *Event, erro
 	}
 
 	return kafka.Message{
-		Key:   []byte(key),
-		Value: eventBytes,
+		Key:     []byte(key),
+		Value:   eventBytes,
+		Headers: constructHeaders(publishBuilder.headers),
 	}, event, nil
 }
 
+// constructHeaders converts the headers into kafka headers, sorted by key
+func constructHeaders(headers map[string]string) []kafka.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kafkaHeaders := make([]kafka.Header, 0, len(headers))
+	for _, key := range keys {
+		kafkaHeaders = append(kafkaHeaders, kafka.Header{Key: key, Value: []byte(headers[key])})
+	}
+
+	return kafkaHeaders
+}
+
+// eventHeaders converts the kafka headers into a map, the last value of a duplicated key is kept
+func eventHeaders(headers []kafka.Header) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	eventHeaders := make(map[string]string, len(headers))
+	for _, header := range headers {
+		eventHeaders[header.Key] = string(header.Value)
+	}
+
+	return eventHeaders
+}
+
 // unregister unregister subscriber
 func (client *KafkaClient) unregister(subscribeBuilder *SubscribeBuilder) {
 	client.ReadersLock.Lock()
@@ -449,140 +984,621 @@ func (client *KafkaClient) unregister(subscribeBuilder *SubscribeBuilder) {
 }
 
 // Register register callback function and then subscribe topic
-// nolint: gocognit,funlen
 func (client *KafkaClient) Register(subscribeBuilder *SubscribeBuilder) error {
+	_, err := client.Subscribe(subscribeBuilder)
+	return err
+}
+
+// Subscribe register callback function and then subscribe topic.
+// The returned Subscription can be used to stop the subscriber and to check its state.
+func (client *KafkaClient) Subscribe(subscribeBuilder *SubscribeBuilder) (Subscription, error) {
 	if subscribeBuilder == nil {
 		logrus.Error(errSubNilEvent)
-		return errSubNilEvent
+		return nil, errSubNilEvent
 	}
 
 	logrus.
-		WithField("Topic Name", subscribeBuilder.topic).
-		WithField("Event Name", subscribeBuilder.eventName).
+		WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+		WithField("Event Name", subscribeBuilder.subscribedEventName()).
 		Info("register callback")
 
 	err := validateSubscribeEvent(subscribeBuilder)
 	if err != nil {
 		logrus.
-			WithField("Topic Name", subscribeBuilder.topic).
-			WithField("Event Name", subscribeBuilder.eventName).
+			WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+			WithField("Event Name", subscribeBuilder.subscribedEventName()).
 			Error("incorrect subscriber event: ", err)
 
-		return err
+		return nil, err
 	}
 
-	topic := constructTopic(client.prefix, subscribeBuilder.topic)
-	groupID := constructGroupID(client.prefix, subscribeBuilder.groupID)
-
-	loggerFields := logrus.
-		WithField("Topic Name", topic).
-		WithField("Event Name", subscribeBuilder.eventName)
+	subscribeBuilder.prefix = client.prefix
+	subscribeBuilder.tracing = client.tracing
+	subscribeBuilder.setEventNames()
+	// the topics of a multi topic subscriber are the ones of its reader, they're described by the logs
+	topic := subscribeBuilder.subscribedTopic()
+	if !subscribeBuilder.isMultiTopic() {
+		topic = constructTopic(client.prefix, topic)
+	}
 
 	isRegistered := client.registerSubscriber(subscribeBuilder)
 	if isRegistered {
-		return fmt.Errorf(
+		return nil, fmt.Errorf(
 			"topic and event already registered. topic: %s , event: %s",
 			topic,
-			subscribeBuilder.eventName,
+			subscribeBuilder.subscribedEventName(),
 		)
 	}
 
-	go func() {
-		config := client.subscribeConfig
-		config.Topic = topic
-		config.GroupID = groupID
-		config.StartOffset = subscribeBuilder.offset
-		reader := kafka.NewReader(config)
-		client.setSubscriberReader(subscribeBuilder, reader)
+	sub, err := client.addSubscription(subscribeBuilder)
+	if err != nil {
+		client.unregister(subscribeBuilder)
+		return nil, err
+	}
 
-		var eventProcessingFailed bool
+	go client.runSubscription(sub, topic)
 
-		defer func() {
+	return sub, nil
+}
 
-			reader.Close() // nolint: errcheck
-			client.unregister(subscribeBuilder)
+// runSubscription consumes events until the subscription is stopped.
+// When a callback fails the reader is closed and the subscriber restarts.
+func (client *KafkaClient) runSubscription(sub *subscription, topic string) {
+	loggerFields := logrus.
+		WithField("Topic Name", topic).
+		WithField("Event Name", sub.builder.subscribedEventName())
 
-			if eventProcessingFailed {
-				if subscribeBuilder.ctx.Err() != nil {
-					// the subscription is shutting down. triggered by an external context cancellation
-					loggerFields.Warn("triggered an external context cancellation. Cancelling the subscription")
-					return
-				}
+	var err error
 
-				// current worker can't process the event and we need to unblock the event for other workers
-				// as we use kafka in the explicit commit mode - we can't send the "acknowledge" and have to interrupt connection
-				time.Sleep(time.Second)
+	stopRetryTopics := subscribeRetryTopics(sub, client.Subscribe)
 
-				loggerFields.Warn("trying to re-register because event processing failed")
-				err := client.Register(subscribeBuilder)
-				if err != nil {
-					loggerFields.Error(err)
-				}
+	defer func() {
+		stopRetryTopics()
+		client.unregister(sub.builder)
+		sub.finish(err)
+		client.removeSubscription(sub)
+	}()
+
+	for {
+		err = client.consume(sub, topic, loggerFields)
+		if err == errSubscribedTopicsChanged || err == errSeekRequested {
+			// the reader is recreated with the topics matching the pattern, or from the position of the seek
+			continue
+		}
+
+		if err == nil || sub.stopped() {
+			if sub.ctx.Err() != nil {
+				// the subscription is shutting down. triggered by an external context cancellation
+				loggerFields.Warn("triggered an external context cancellation. Cancelling the subscription")
+				err = sub.ctx.Err()
 			}
-		}()
 
-		for {
+			return
+		}
+
+		sub.setState(SubscriptionRestarting)
+
+		// current worker can't process the event and we need to unblock the event for other workers
+		// as we use kafka in the explicit commit mode - we can't send the "acknowledge" and have to interrupt connection
+		select {
+		case <-time.After(sub.restartDelay):
+		case <-sub.stopCtx.Done():
+			err = sub.ctx.Err()
+			return
+		}
+
+		loggerFields.Warn("trying to re-register because event processing failed")
+
+		sub.setState(SubscriptionRunning)
+	}
+}
+
+// consume reads and processes events with a new reader until the subscription is stopped
+// or a callback fails. Returns the callback error.
+// nolint: gocognit,funlen
+func (client *KafkaClient) consume(sub *subscription, topic string, loggerFields *logrus.Entry) error {
+	subscribeBuilder := sub.builder
+	groupID := constructGroupID(client.prefix, subscribeBuilder.groupID)
+
+	config := client.subscribeConfig
+	config.Topic = topic
+	config.GroupID = groupID
+
+	// the topics matching the pattern of the subscriber are watched until the reader is closed,
+	// the reader is recreated once they change
+	consumeCtx := sub.stopCtx
+	readerTopics := []string{topic}
+	if subscribeBuilder.isMultiTopic() {
+		topics, ok := client.subscribeTopics(sub, loggerFields)
+		if !ok {
+			if sub.ctx.Err() != nil {
+				sub.notifyCancelled()
+			}
+
+			return nil
+		}
+
+		config.Topic = ""
+		config.GroupTopics = topics
+		readerTopics = topics
+
+		if subscribeBuilder.topicPattern != nil {
+			var cancelConsume context.CancelFunc
+			consumeCtx, cancelConsume = context.WithCancel(sub.stopCtx)
+			defer cancelConsume()
+
+			go client.watchTopics(consumeCtx, sub, topics, cancelConsume, loggerFields)
+		}
+	}
+
+	retryPolicy := subscribeBuilder.subscribeRetryPolicy(client.subscribeRetryPolicy)
+
+	// the seek is applied before the reader joins the group, so it fetches from the committed offsets
+	var seekOffsets map[topicPartition]int64
+	if request := sub.takeSeek(); request != nil {
+		var err error
+		seekOffsets, err = client.seek(sub.stopCtx, readerTopics, groupID, request.position)
+		request.finish(err)
+
+		if err != nil {
+			loggerFields.Error("unable to seek: ", err)
+
+			if request.retried() {
+				sub.retrySeek(request)
+				sub.restartDelay = retryPolicy.InitialInterval
+				return err
+			}
+		}
+	}
+
+	config.StartOffset = subscribeBuilder.offset
+
+	// the generations of the group are only exposed by the reader of a subscriber with partition hooks,
+	// its partitions are revoked once the consumer releases them
+	var reader messageReader
+	var rebalance *groupReader
+	if subscribeBuilder.hasPartitionHooks() {
+		var err error
+		rebalance, err = newGroupReader(sub, config, loggerFields)
+		if err != nil {
+			loggerFields.Error("unable to create the reader: ", err)
+			sub.restartDelay = retryPolicy.InitialInterval
+			return err
+		}
+
+		client.setSubscriberReader(subscribeBuilder, rebalance)
+
+		defer client.setSubscriberReader(subscribeBuilder, nil)
+
+		reader = rebalance
+	} else {
+		kafkaReader := kafka.NewReader(config)
+		client.setSubscriberReader(subscribeBuilder, kafkaReader)
+
+		defer client.setSubscriberReader(subscribeBuilder, nil)
+
+		// a reader without group only consumes the first partition
+		if offset, ok := seekOffsets[topicPartition{topic: topic, partition: 0}]; ok && groupID == "" {
+			_ = kafkaReader.SetOffset(offset)
+		}
+
+		// the partitions the reader fetches from are reported, they aren't exposed by it
+		sub.resetPartitions(nil)
+
+		reader = kafkaReader
+	}
+
+	defer reader.Close() // nolint: errcheck
+
+	reader = describedReader{messageReader: reader, sub: sub}
+
+	deadLetterTopic := subscribeBuilder.deadLetterTopicName()
+	retryTopicDelay := subscribeBuilder.retryTopicDelay()
+	var fetchRetrier *retrier
+
+	// the deliveries of the manual acknowledgement, and the events of the workers of a concurrent subscriber,
+	// are committed until the reader is closed
+	var tracker *ackTracker
+	if subscribeBuilder.callbackDelivery != nil || subscribeBuilder.concurrency > 1 {
+		tracker = client.newAckTracker(sub, reader, groupID, retryPolicy, loggerFields)
+		defer tracker.close()
+	}
+
+	var workers *keyWorkers
+	if subscribeBuilder.concurrency > 1 {
+		workers = newKeyWorkers(subscribeBuilder.concurrency, tracker.process)
+		defer workers.close()
+	}
+
+	var batch *batchConsumer
+	if subscribeBuilder.callbackBatch != nil {
+		batch = client.newBatchConsumer(sub, reader, groupID, retryPolicy, loggerFields)
+	}
+
+	for {
+		if sub.ctx.Err() != nil {
+			sub.notifyCancelled()
+
+			return nil
+		}
+
+		if sub.stopped() {
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+			}
+
+			loggerFields.Info("unsubscribed")
+
+			return nil
+		}
+
+		if consumeCtx.Err() != nil {
+			// the processing events are finished first so they're committed before the reader is recreated
+			if workers != nil {
+				workers.drain()
+			}
+
+			return errSubscribedTopicsChanged
+		}
+
+		if sub.seekPending() {
+			// the processing events are finished first so their offsets don't override the seek
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+			}
+
+			return errSeekRequested
+		}
+
+		if rebalance != nil && rebalance.revoking() {
+			// the processing events are finished and committed before the partitions are revoked
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+				tracker.reset()
+			}
+
+			rebalance.release()
+			continue
+		}
+
+		if sub.paused() {
+			// the reader keeps sending the heartbeats of the group while it's not fetching,
+			// the partitions are still released when they're revoked
+			waitCtx, cancelWait := consumeCtx, context.CancelFunc(func() {})
+			if rebalance != nil {
+				waitCtx, cancelWait = rebalance.revokingContext(consumeCtx)
+			}
+
+			sub.waitResumed(waitCtx)
+			cancelWait()
+			continue
+		}
+
+		controlCtx, cancelControl := sub.fetchContext(consumeCtx)
+		fetchCtx, cancelFetch := controlCtx, context.CancelFunc(func() {})
+		if tracker != nil {
+			fetchCtx, cancelFetch = tracker.waitContext(controlCtx)
+		}
+
+		consumerMessage, errRead := reader.FetchMessage(fetchCtx)
+		woken := errRead != nil && fetchCtx.Err() != nil && !sub.stopped()
+		cancelFetch()
+		cancelControl()
+
+		if errRead == errPartitionsRevoked {
+			// the partitions are released at the start of the loop
+			continue
+		}
+
+		if woken {
+			if tracker != nil {
+				// a requeued delivery or an ack deadline is due
+				tracker.processDue()
+			}
+
+			continue
+		}
+
+		if errRead != nil {
+			if errRead == context.Canceled {
+				loggerFields.Infof("subscriber shut down because context cancelled")
+			} else {
+				loggerFields.Errorf("subscriber unable to fetch message: %v", errRead)
+				sub.fetchFailed(errRead)
+			}
+
+			if sub.stopped() {
+				// the subscription is shutting down. triggered by an external context cancellation or unsubscribe
+				continue
+			}
+
+			// On read error we just retry (after the delay of the fetch retry policy).
+			// Typical errors from the cluster include: consumer group is rebalancing, or leader re-election.
+			// Those aren't hard errors so we should just call FetchMessage again.
+			// It can also return IO errors like EOF, but not that reader automatically handles reconnecting to the cluster.
+			if fetchRetrier == nil {
+				fetchRetrier = newRetrier(client.fetchRetryPolicy)
+			}
+
+			delay, ok := fetchRetrier.next(errRead)
+			if !ok {
+				// recreate the reader
+				sub.restartDelay = 0
+				return errRead
+			}
+
+			for _, readerTopic := range readerTopics {
+				client.retryMetrics.ObserveRetry(retryOperationFetch, readerTopic, fetchRetrier.attempt)
+			}
+			loggerFields.WithField("Attempt", fetchRetrier.attempt).Debugf("retrying fetch in %s", delay)
+
 			select {
-			case <-subscribeBuilder.ctx.Done():
-				// ignore error because client isn't processing events
-				if subscribeBuilder.callback != nil {
-					err = subscribeBuilder.callback(subscribeBuilder.ctx, nil, subscribeBuilder.ctx.Err())
+			case <-time.After(delay):
+			case <-sub.stopCtx.Done():
+			}
+
+			continue
+		}
+
+		fetchRetrier = nil
+
+		if batch != nil {
+			if err := batch.process(batch.collect(consumerMessage)); err != nil {
+				// the batch isn't committed, it's processed again by a new reader
+				sub.restartDelay = retryPolicy.InitialInterval
+				return err
+			}
+
+			continue
+		}
+
+		if workers == nil && tracker != nil {
+			tracker.deliver(consumerMessage)
+			tracker.processDue()
+			continue
+		}
+
+		if retryTopicDelay > 0 && !sub.waitUntil(consumerMessage.Time.Add(retryTopicDelay)) {
+			// the subscription is stopped before the event of the retry topic is due
+			continue
+		}
+
+		if workers != nil {
+			if err := workers.failed(); err != nil {
+				// the events after the failed one aren't committed until it's processed again by a new reader
+				workers.drain()
+				sub.restartDelay = retryPolicy.InitialInterval
+				return err
+			}
+
+			if tracker.fetchedBefore(consumerMessage) {
+				// the reader restarted from the committed offsets after a rebalance,
+				// the processing events are finished first so they're committed and not processed again
+				workers.drain()
+			}
+
+			if tracker.track(consumerMessage) {
+				workers.dispatch(sub.stopCtx, consumerMessage)
+			}
+
+			continue
+		}
+
+		err := processMessage(sub.ctx, subscribeBuilder, consumerMessage, topic)
+		sub.failed(err)
+		if err != nil && len(subscribeBuilder.retryDelays) > 0 {
+			// the event is published to the next retry topic and committed, even when unsubscribing
+			forwardTopic, forwardMessage := subscribeBuilder.forwardFailed(consumerMessage, consumerMessage.Topic, groupID, err)
+			loggerFields.WithField("Retry Topic", forwardTopic).Warn("unable to process the event: ", err)
+
+			err = client.publishWithRetry(sub.ctx, constructTopic(client.prefix, forwardTopic),
+				subscribeBuilder.subscribedEventName(), forwardMessage, client.publishRetryPolicy)
+			if err != nil {
+				// the event isn't committed, it's processed again once the subscriber restarts
+				sub.restartDelay = retryPolicy.InitialInterval
+				return err
+			}
+		} else if err != nil {
+			attempt, retry := sub.retryEvent(retryPolicy, consumerMessage, err)
+			if retry {
+				client.retryMetrics.ObserveRetry(retryOperationSubscribe, consumerMessage.Topic, attempt)
+				loggerFields.WithField("Attempt", attempt).Error("unable to process the event: ", err)
+
+				// shutdown current reader and mark the subscriber for restarting
+				return err
+			}
+
+			// the event is committed, so it's not delivered again
+			loggerFields.WithField("Attempt", attempt).Error("giving up processing the event: ", err)
+
+			// the callback context is used, so the event is dead lettered even when unsubscribing
+			if deadLetterTopic != "" {
+				letter := deadLetter{
+					topic:     consumerMessage.Topic,
+					groupID:   groupID,
+					partition: consumerMessage.Partition,
+					offset:    consumerMessage.Offset,
+					attempts:  attempt,
+					err:       err,
 				}
-				if subscribeBuilder.callbackRaw != nil {
-					err = subscribeBuilder.callbackRaw(subscribeBuilder.ctx, nil, subscribeBuilder.ctx.Err())
+
+				err = client.publishWithRetry(sub.ctx, constructTopic(client.prefix, deadLetterTopic),
+					subscribeBuilder.subscribedEventName(), newDeadLetterMessage(consumerMessage, letter), client.publishRetryPolicy)
+				if err != nil {
+					// the event isn't committed, it's given up again once it's delivered again
+					sub.restartDelay = retryPolicy.InitialInterval
+					return err
 				}
+			}
+		}
 
+		if groupID == "" {
+			// offsets are only committed for consumer groups
+			continue
+		}
+
+		// the callback context is used, so the offset of a processed event is committed even when unsubscribing
+		err = reader.CommitMessages(sub.ctx, consumerMessage)
+		if err != nil {
+			if sub.ctx.Err() != nil {
+				// the subscription is shutting down. triggered by an external context cancellation
 				loggerFields.Warn("triggered an external context cancellation. Cancelling the subscription")
+				continue
+			}
 
-				return
-			default:
-				consumerMessage, errRead := reader.FetchMessage(subscribeBuilder.ctx)
-				if errRead != nil {
-					if errRead == context.Canceled {
-						loggerFields.Infof("subscriber shut down because context cancelled")
-					} else {
-						loggerFields.Errorf("subscriber unable to fetch message: %v", errRead)
-					}
+			loggerFields.Error("unable to commit the event: ", err)
+		}
+	}
+}
 
-					if subscribeBuilder.ctx.Err() != nil {
-						// the subscription is shutting down. triggered by an external context cancellation
-						loggerFields.Warn("triggered an external context cancellation. Cancelling the subscription")
-						continue // Shutting down because ctx expired
-					}
+// PauseAll pauses every subscriber of the client, see Subscription.Pause
+func (client *KafkaClient) PauseAll() {
+	for _, sub := range client.listSubscriptions() {
+		sub.Pause()
+	}
+}
 
-					// On read error we just retry (after slight delay).
-					// Typical errors from the cluster include: consumer group is rebalancing, or leader re-election.
-					// Those aren't hard errors so we should just call FetchMessage again.
-					// It can also return IO errors like EOF, but not that reader automatically handles reconnecting to the cluster.
-					time.Sleep(200 * time.Millisecond)
-					continue
-				}
+// ResumeAll resumes every subscriber of the client
+func (client *KafkaClient) ResumeAll() {
+	for _, sub := range client.listSubscriptions() {
+		sub.Resume()
+	}
+}
 
-				err := client.processMessage(subscribeBuilder, consumerMessage, topic)
-				if err != nil {
-					loggerFields.Error("unable to process the event: ", err)
+// listSubscriptions returns the running subscriptions
+func (client *KafkaClient) listSubscriptions() []*subscription {
+	client.ReadersLock.RLock()
+	defer client.ReadersLock.RUnlock()
 
-					// shutdown current subscriber and mark it for restarting
-					eventProcessingFailed = true
+	subscriptions := make([]*subscription, 0, len(client.subscriptions))
+	for sub := range client.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
 
-					return
-				}
+	return subscriptions
+}
 
-				err = reader.CommitMessages(subscribeBuilder.ctx, consumerMessage)
-				if err != nil {
-					if subscribeBuilder.ctx.Err() == nil {
-						// the subscription is shutting down. triggered by an external context cancellation
-						loggerFields.Warn("triggered an external context cancellation. Cancelling the subscription")
-						continue
-					}
+// addSubscription creates a subscription and tracks it so Close can stop and wait for it
+func (client *KafkaClient) addSubscription(subscribeBuilder *SubscribeBuilder) (*subscription, error) {
+	client.closeLock.RLock()
+	defer client.closeLock.RUnlock()
 
-					loggerFields.Error("unable to commit the event: ", err)
-				}
+	if client.closed {
+		return nil, ErrClientClosed
+	}
+
+	sub := newSubscription(subscribeBuilder)
+
+	client.ReadersLock.Lock()
+	client.subscriptions[sub] = struct{}{}
+	client.ReadersLock.Unlock()
+
+	client.subscribers.Add(1)
+
+	return sub, nil
+}
+
+// removeSubscription releases a subscription added by addSubscription
+func (client *KafkaClient) removeSubscription(sub *subscription) {
+	client.ReadersLock.Lock()
+	delete(client.subscriptions, sub)
+	client.ReadersLock.Unlock()
+
+	client.subscribers.Done()
+}
+
+// Close stops accepting new publishes and subscriptions and waits for pending publishes until ctx is done.
+// Publishes still pending at that point are aborted and reported through their error callbacks.
+// Afterwards all subscriptions are unsubscribed, then all writers are flushed and closed.
+func (client *KafkaClient) Close(ctx context.Context) error {
+	client.closeLock.Lock()
+	if client.closed {
+		client.closeLock.Unlock()
+		return nil
+	}
+	client.closed = true
+	client.closeLock.Unlock()
+
+	logrus.Info("closing kafka client")
+
+	err := waitWithContext(ctx, &client.publishers)
+	if err != nil {
+		logrus.Warn("aborting pending publishes: ", err)
+
+		// pending publishes give up and call their error callbacks
+		client.cancel()
+		client.publishers.Wait()
+	}
+
+	if client.deliveries != nil {
+		close(client.deliveries)
+	}
+
+	if client.spool != nil {
+		// the spooled messages left are forwarded by the next client using the spool dir
+		client.spoolCancel()
+		client.spoolForwarder.Wait()
+
+		if errClose := client.spool.close(); errClose != nil {
+			logrus.Error("unable to close spool: ", errClose)
+		}
+	}
+
+	client.ReadersLock.RLock()
+	subscriptions := make([]*subscription, 0, len(client.subscriptions))
+	for sub := range client.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	client.ReadersLock.RUnlock()
+
+	for _, sub := range subscriptions {
+		sub.stopWithReason(ErrClientClosed)
+	}
+
+	if errWait := waitWithContext(ctx, &client.subscribers); errWait != nil {
+		logrus.Warn("cancelling running callbacks: ", errWait)
+
+		for _, sub := range subscriptions {
+			sub.cancel()
+		}
+
+		if err == nil {
+			err = errWait
+		}
+	}
+
+	// the writers are closed once the subscribers are stopped, they may forward events to retry and dead letter
+	// topics until then
+	client.WritersLock.Lock()
+	client.writersClosed = true
+	for topic, writer := range client.writers {
+		if writer != nil {
+			if errClose := writer.Close(); errClose != nil {
+				logrus.WithField("Topic Name", topic).Error("unable to close writer: ", errClose)
 			}
 		}
-	}()
+		delete(client.writers, topic)
+	}
+	for topic := range client.writerActivities {
+		client.pruneWriterActivity(topic)
+	}
+	client.WritersLock.Unlock()
 
-	return nil
+	client.cancel()
+
+	return err
 }
 
 // registerSubscriber add callback to map with topic and eventName as a key
@@ -608,37 +1624,98 @@ func (client *KafkaClient) registerSubscriber(subscribeBuilder *SubscribeBuilder
 	return false
 }
 
-func (client *KafkaClient) setSubscriberReader(subscribeBuilder *SubscribeBuilder, reader *kafka.Reader) {
+// statsReader is the reader of a subscriber reporting its stats, kafka.Reader or groupReader
+type statsReader interface {
+	Stats() kafka.ReaderStats
+}
+
+func (client *KafkaClient) setSubscriberReader(subscribeBuilder *SubscribeBuilder, reader statsReader) {
 	slug := subscribeBuilder.Slug()
 	client.ReadersLock.Lock()
 	defer client.ReadersLock.Unlock()
 	client.readers[slug] = reader
 }
 
-// getWriter get a writer based on config
-func (client *KafkaClient) getWriter(config kafka.WriterConfig) *kafka.Writer {
+// getWriter get a writer based on config, returns ErrClientClosed once the writers are closed
+func (client *KafkaClient) getWriter(config kafka.WriterConfig) (*kafka.Writer, error) {
 	client.WritersLock.Lock()
 	defer client.WritersLock.Unlock()
 
+	if client.writersClosed {
+		return nil, ErrClientClosed
+	}
+
 	if writer, ok := client.writers[config.Topic]; ok {
-		return writer
+		return writer, nil
 	}
 
 	writer := kafka.NewWriter(config)
+	writer.Completion = completeDeliveries
 	client.writers[config.Topic] = writer
 
-	return writer
+	return writer, nil
 }
 
-// newWriter new a writer
-func (client *KafkaClient) newWriter(config kafka.WriterConfig) *kafka.Writer {
-	writer := kafka.NewWriter(config)
+// startWrite records the messages being written to the topic, the returned function records the result
+func (client *KafkaClient) startWrite(topic string, messages int) func(err error) {
+	client.WritersLock.Lock()
+	defer client.WritersLock.Unlock()
+
+	activity, ok := client.writerActivities[topic]
+	if !ok {
+		activity = &writerActivity{}
+		client.writerActivities[topic] = activity
+	}
+
+	activity.pending += int64(messages)
+
+	return func(err error) {
+		client.WritersLock.Lock()
+		defer client.WritersLock.Unlock()
+
+		activity.pending -= int64(messages)
+		if err != nil {
+			activity.lastError = err
+			activity.lastErrorTime = time.Now()
+
+			return
+		}
+
+		if client.writerActivities[topic] == activity {
+			client.pruneWriterActivity(topic)
+		}
+	}
+}
+
+// pruneWriterActivity drops the activity of the topic once its writer is closed and no write is pending,
+// client.WritersLock must be held
+func (client *KafkaClient) pruneWriterActivity(topic string) {
+	activity, ok := client.writerActivities[topic]
+	if !ok || activity.pending > 0 {
+		return
+	}
+
+	if _, ok = client.writers[topic]; ok {
+		return
+	}
+
+	delete(client.writerActivities, topic)
+}
 
+// newWriter new a writer, returns ErrClientClosed once the writers are closed
+func (client *KafkaClient) newWriter(config kafka.WriterConfig) (*kafka.Writer, error) {
 	client.WritersLock.Lock()
 	defer client.WritersLock.Unlock()
+
+	if client.writersClosed {
+		return nil, ErrClientClosed
+	}
+
+	writer := kafka.NewWriter(config)
+	writer.Completion = completeDeliveries
 	client.writers[config.Topic] = writer
 
-	return writer
+	return writer, nil
 }
 
 // deleteWriter delete writer
@@ -653,12 +1730,21 @@ func (client *KafkaClient) deleteWriter(topic string) {
 
 	// we only delete the writer from the slice but no close, should close in some interval?
 	delete(client.writers, topic)
+	client.pruneWriterActivity(topic)
 }
 
 // processMessage process a message from kafka
-func (client *KafkaClient) processMessage(subscribeBuilder *SubscribeBuilder, message kafka.Message, topic string) error {
+func processMessage(ctx context.Context, subscribeBuilder *SubscribeBuilder, message kafka.Message, topic string) error {
 	if subscribeBuilder.callbackRaw != nil {
-		return subscribeBuilder.callbackRaw(subscribeBuilder.ctx, message.Value, nil)
+		return subscribeBuilder.tracing.process(ctx, subscribeBuilder, message, func(ctx context.Context) error {
+			return subscribeBuilder.callbackRaw(ctx, message.Value, nil)
+		})
+	}
+
+	if subscribeBuilder.callbackTyped != nil {
+		return subscribeBuilder.tracing.process(ctx, subscribeBuilder, message, func(ctx context.Context) error {
+			return subscribeBuilder.callbackTyped(ctx, &message, nil)
+		})
 	}
 
 	event, err := unmarshal(message)
@@ -666,20 +1752,28 @@ func (client *KafkaClient) processMessage(subscribeBuilder *SubscribeBuilder, me
 	if err != nil {
 		logrus.
 			WithField("Topic Name", topic).
-			WithField("Event Name", subscribeBuilder.eventName).
+			WithField("Event Name", subscribeBuilder.subscribedEventName()).
 			Error("unable to unmarshal message from subscribe in kafka: ", err)
 
 		// as retry will fail infinitely - return nil to ACK the event
 		return nil
 	}
 
-	if subscribeBuilder.eventName != "" && subscribeBuilder.eventName != event.EventName {
-		// don't send events if consumer subscribed on a non-empty event name
+	event.Topic = subscribeBuilder.eventTopic(message)
+	if !subscribeBuilder.subscribes(event) {
+		// don't send events if consumer didn't subscribe on their event name, or they're filtered out
 		// return nil to ACK the event
 		return nil
 	}
 
-	return client.runCallback(subscribeBuilder, event)
+	// the trace of a traced publisher is reported to the subscribers without tracer
+	if event.TraceID == "" {
+		event.TraceID = headerTraceID(message.Headers)
+	}
+
+	return subscribeBuilder.tracing.process(ctx, subscribeBuilder, message, func(ctx context.Context) error {
+		return runCallback(ctx, subscribeBuilder, event)
+	})
 }
 
 // unmarshal unmarshal received message into event struct
@@ -691,19 +1785,33 @@ func unmarshal(message kafka.Message) (*Event, error) {
 		return &Event{}, err
 	}
 
+	setMessageFields(event, message)
+
+	return event, nil
+}
+
+// setMessageFields sets the fields of the event coming from the kafka message
+func setMessageFields(event *Event, message kafka.Message) {
 	event.Partition = message.Partition
 	event.Offset = message.Offset
 	event.Key = string(message.Key)
-
-	return event, nil
+	event.Headers = eventHeaders(message.Headers)
+	event.KafkaTimestamp = message.Time
 }
 
 // runCallback run callback function when receive an event
-func (client *KafkaClient) runCallback(
+func runCallback(
+	ctx context.Context,
 	subscribeBuilder *SubscribeBuilder,
 	event *Event,
 ) error {
-	return subscribeBuilder.callback(subscribeBuilder.ctx, &Event{
+	callback := subscribeBuilder.eventCallback(event.EventName)
+	if callback == nil {
+		// the event name is only subscribed by the handlers of other event names
+		return nil
+	}
+
+	return callback(ctx, &Event{
 		ID:               event.ID,
 		EventName:        event.EventName,
 		Namespace:        event.Namespace,
@@ -722,12 +1830,14 @@ func (client *KafkaClient) runCallback(
 		TargetUserIDs:    event.TargetUserIDs,
 		TargetNamespace:  event.TargetNamespace,
 		Privacy:          event.Privacy,
-		Topic:            subscribeBuilder.topic,
+		Topic:            event.Topic,
 		AdditionalFields: event.AdditionalFields,
 		Payload:          event.Payload,
 		Partition:        event.Partition,
 		Offset:           event.Offset,
 		Key:              event.Key,
+		Headers:          event.Headers,
+		KafkaTimestamp:   event.KafkaTimestamp,
 	}, nil)
 }
 
@@ -779,11 +1889,138 @@ func (client *KafkaClient) GetReaderStats() (stats []kafka.ReaderStats, slugs []
 	return stats, slugs
 }
 
-func newPublishBackoff() *backoff.ExponentialBackOff {
-	backoff := backoff.NewExponentialBackOff()
-	// We increase the default multiplier, because kafka cluster operations can take quite long to complete,
-	// e.g. auto-creating topics, leader re-election, or rebalancing.
-	// For maxBackoffCount=4 we will get attempts: 0ms, 500ms, 2s, 8s, 16s.
-	backoff.Multiplier = 4.0
-	return backoff
+// Describe returns a snapshot of the writers and the subscriptions of the client
+func (client *KafkaClient) Describe() ClientDescription {
+	description := ClientDescription{Writers: []WriterDescription{}, Subscriptions: []SubscriptionDescription{}}
+
+	client.WritersLock.RLock()
+	for topic, activity := range client.writerActivities {
+		description.Writers = append(description.Writers, activity.describe(trimTopicPrefix(client.prefix, topic)))
+	}
+	client.WritersLock.RUnlock()
+
+	for _, sub := range client.listSubscriptions() {
+		description.Subscriptions = append(description.Subscriptions, sub.describe())
+	}
+
+	sortDescription(description)
+
+	return description
+}
+
+// Health checks the brokers can be reached and authenticate the client, their metadata can be fetched and the topics
+// of the subscribers exist, and that no subscriber is stalled
+func (client *KafkaClient) Health(ctx context.Context) HealthReport {
+	report := newHealthReport()
+
+	client.closeLock.RLock()
+	closed := client.closed
+	client.closeLock.RUnlock()
+
+	if closed {
+		report.add(healthCheckClient, "", ErrClientClosed)
+		return report
+	}
+
+	report.add(healthCheckClient, "", nil)
+
+	subscriptions := client.listSubscriptions()
+	client.checkBroker(ctx, &report, subscriptions)
+	report.checkSubscribers(subscriptions, client.subscriberStallThreshold)
+
+	return report
+}
+
+// subscriberHealth checks the client and its subscribers without dialing the brokers
+func (client *KafkaClient) subscriberHealth() HealthReport {
+	report := newHealthReport()
+
+	client.closeLock.RLock()
+	closed := client.closed
+	client.closeLock.RUnlock()
+
+	if closed {
+		report.add(healthCheckClient, "", ErrClientClosed)
+		return report
+	}
+
+	report.add(healthCheckClient, "", nil)
+	report.checkSubscribers(client.listSubscriptions(), client.subscriberStallThreshold)
+
+	return report
+}
+
+// checkBroker adds the checks of the brokers and of the topics of the subscriptions to the report
+func (client *KafkaClient) checkBroker(ctx context.Context, report *HealthReport, subscriptions []*subscription) {
+	dialer := client.dialer()
+
+	conn, err := client.dialBroker(ctx)
+	if err != nil {
+		if isAuthenticationError(err) {
+			report.add(healthCheckAuthentication, "", err)
+		} else {
+			report.add(healthCheckBroker, "", err)
+		}
+
+		return
+	}
+	defer conn.Close() // nolint: errcheck
+
+	report.add(healthCheckBroker, "", nil)
+	if dialer.SASLMechanism != nil || dialer.TLS != nil {
+		report.add(healthCheckAuthentication, "", nil)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	partitions, err := conn.ReadPartitions()
+	report.add(healthCheckMetadata, "", err)
+	if err != nil {
+		return
+	}
+
+	existing := make(map[string]bool, len(partitions))
+	for _, partition := range partitions {
+		existing[partition.Topic] = true
+	}
+
+	// the topics matching a pattern are discovered, they exist
+	topics := make(map[string]bool)
+	for _, sub := range subscriptions {
+		if sub.builder.topicPattern != nil {
+			continue
+		}
+
+		for _, topic := range sub.builder.subscribedTopics() {
+			topics[topic] = true
+		}
+	}
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+
+	for _, topic := range names {
+		if existing[constructTopic(client.prefix, topic)] {
+			report.add(healthCheckTopic, topic, nil)
+		} else {
+			report.add(healthCheckTopic, topic, errTopicNotFound)
+		}
+	}
+}
+
+// GetSubscriberStats returns the state of each subscriber
+func (client *KafkaClient) GetSubscriberStats() []kafkaprometheus.SubscriberStats {
+	subscriptions := client.listSubscriptions()
+
+	stats := make([]kafkaprometheus.SubscriberStats, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		stats = append(stats, kafkaprometheus.SubscriberStats{Slug: sub.builder.Slug(), Paused: sub.paused()})
+	}
+
+	return stats
 }
@@ -23,9 +23,12 @@ import (
 	"path"
 	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkatest"
 	"github.com/mitchellh/mapstructure"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
@@ -41,6 +44,14 @@ const (
 	errorTimeout   = "timeout while executing test"
 	errorPublish   = "error when publish event"
 	errorSubscribe = "error when subscribe event"
+
+	testStreamEnvKey  = "EVENTSTREAM_TEST_STREAM"
+	testBrokersEnvKey = "EVENTSTREAM_TEST_BROKERS"
+)
+
+var (
+	testBroker     *kafkatest.Broker
+	testBrokerOnce sync.Once
 )
 
 type Payload struct {
@@ -59,12 +70,52 @@ func createKafkaClient(t *testing.T) Client {
 		BaseWriterConfig: &kafka.WriterConfig{BatchSize: 5},
 	}
 
-	brokerList := []string{"localhost:9092"}
-	client, _ := NewClient(prefix, eventStreamKafka, brokerList, config)
+	client, _ := NewClient(prefix, testStream(), testBrokers(), config)
 
 	return client
 }
 
+// testStream returns the stream the integration tests run against, set by the EVENTSTREAM_TEST_STREAM env.
+// default: kafka
+func testStream() string {
+	if stream := loadEnv(testStreamEnvKey); stream != "" {
+		return stream
+	}
+
+	return eventStreamKafka
+}
+
+// testBrokers returns the brokers the integration tests run against, set by the EVENTSTREAM_TEST_BROKERS env
+// as a comma separated list. default: an in-process kafkatest broker
+func testBrokers() []string {
+	if brokers := loadEnv(testBrokersEnvKey); brokers != "" {
+		return strings.Split(brokers, ",")
+	}
+
+	testBrokerOnce.Do(func() {
+		testBroker = kafkatest.NewBroker()
+	})
+
+	return testBroker.Addrs()
+}
+
+// createTestTopic creates the prefixed topic through the broker topic auto creation,
+// so it can be published to synchronously
+func createTestTopic(t *testing.T, topic string) {
+	t.Helper()
+
+	if testStream() != eventStreamKafka {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer cancel()
+
+	conn, err := kafka.DialLeader(ctx, "tcp", testBrokers()[0], constructTopic(prefix, topic), 0)
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
 func createInvalidKafkaClient(t *testing.T) Client {
 	t.Helper()
 
@@ -215,6 +266,49 @@ func TestKafkaPubSubSuccess(t *testing.T) {
 }
 
 // nolint dupl
+func TestKafkaPubSubHeaders(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	publishedAt := time.Now().Add(-time.Second)
+	err := client.PublishSync(
+		NewPublish().
+			Topic(topicName).
+			EventName("testEvent").
+			Header("routing", "eu").
+			Headers(map[string]string{"traceparent": "00-trace-span-01"}).
+			Context(ctx))
+	require.NoError(t, err)
+
+	received := make(chan *Event, 1)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, map[string]string{"routing": "eu", "traceparent": "00-trace-span-01"}, event.Headers)
+		assert.True(t, event.KafkaTimestamp.After(publishedAt), "kafka timestamp should be set")
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
 func TestKafkaNonConsumerGroupSuccess(t *testing.T) {
 	t.Parallel()
 	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
@@ -382,6 +476,10 @@ func TestKafkaNonConsumerGroupSuccess(t *testing.T) {
 
 // nolint dupl
 func TestKafkaPubFailed(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
 	t.Parallel()
 	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
 	defer done()
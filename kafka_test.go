@@ -18,9 +18,12 @@ package eventstream
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // nolint dupl
@@ -98,6 +101,18 @@ func TestKafkaPubWithEmptyTopic(t *testing.T) {
 	assert.NotNil(t, err, "error should not be nil")
 }
 
+func TestKafkaPublishSyncMultipleTopics(t *testing.T) {
+	t.Parallel()
+	client := createKafkaClient(t)
+
+	err := client.PublishSync(
+		NewPublish().
+			Topic(constructTopicTest(), constructTopicTest()).
+			EventName("testEvent").
+			Context(context.Background()))
+	assert.ErrorIs(t, err, errPubSyncTopics, "a sync publish should have a single topic")
+}
+
 // nolint dupl
 func TestKafkaPubInvalidEventStruct(t *testing.T) {
 	t.Parallel()
@@ -329,3 +344,122 @@ func TestKafkaSubNilCallback(t *testing.T) {
 
 	assert.Equal(t, errInvalidCallback, err, "error should be equal")
 }
+
+// nolint dupl
+func TestKafkaClosedClient(t *testing.T) {
+	t.Parallel()
+	client := createKafkaClient(t)
+
+	err := client.Close(context.Background())
+	assert.NoError(t, err, "error should be nil")
+
+	err = client.Close(context.Background())
+	assert.NoError(t, err, "closing twice should be a no-op")
+
+	err = client.Publish(
+		NewPublish().
+			Topic(constructTopicTest()).
+			EventName("testEvent"))
+	assert.Equal(t, ErrClientClosed, err, "error should be equal")
+
+	err = client.PublishSync(
+		NewPublish().
+			Topic(constructTopicTest()).
+			EventName("testEvent"))
+	assert.Equal(t, ErrClientClosed, err, "error should be equal")
+
+	err = client.Register(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.Equal(t, ErrClientClosed, err, "error should be equal")
+}
+
+// nolint dupl
+func TestKafkaCloseReportsPendingPublish(t *testing.T) {
+	t.Parallel()
+	client := createInvalidKafkaClient(t)
+
+	var failedEvent *Event
+
+	err := client.Publish(
+		NewPublish().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			ErrorCallback(func(event *Event, err error) {
+				failedEvent = event
+			}))
+	assert.NoError(t, err, "error should be nil")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = client.Close(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "error should be equal")
+
+	// Close waits for aborted publishes to report their failure
+	if assert.NotNil(t, failedEvent, "error callback should be called") {
+		assert.Equal(t, "testEvent", failedEvent.EventName, "event name should be equal")
+	}
+}
+
+func TestKafkaCloseWhileDeadLettering(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream has no writers")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	createTestTopic(t, DeadLetterTopicName(topicName))
+
+	err := client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent"))
+	require.NoError(t, err)
+
+	processing := make(chan struct{})
+	closing := make(chan struct{})
+	var once sync.Once
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			DeadLetter(1).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				once.Do(func() { close(processing) })
+
+				// the event is dead lettered while the client is closing
+				<-closing
+				time.Sleep(100 * time.Millisecond)
+
+				return errRetryTest
+			}))
+	require.NoError(t, err)
+
+	select {
+	case <-processing:
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+
+	close(closing)
+	require.NoError(t, client.Close(ctx))
+
+	kafkaClient := client.(*KafkaClient)
+	kafkaClient.WritersLock.RLock()
+	defer kafkaClient.WritersLock.RUnlock()
+	assert.Empty(t, kafkaClient.writers, "the writers of the dead letter publishes should be closed")
+}
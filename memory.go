@@ -0,0 +1,1224 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	memoryPartitionCount = 4 // partitions of every in-memory topic
+)
+
+// MemoryClient keeps events in process. Topics are partitioned logs and subscribers with the same group ID
+// share the partitions like kafka consumer groups. It's meant for tests, events are lost when the process exits.
+type MemoryClient struct {
+	// topic prefix
+	prefix string
+
+	// enable strict validation for event fields
+	strictValidation bool
+
+	// balancer choosing the partition from the message key
+	balancer kafka.Balancer
+
+	// lock guards topics, subscribers slugs and closed
+	lock   sync.Mutex
+	topics map[string]*memoryTopic
+	slugs  map[string]int
+	closed bool
+
+	// topicsUpdated is closed and replaced when a topic is created
+	topicsUpdated chan struct{}
+
+	// running subscriptions with their member, stopped by Close
+	subscribers   sync.WaitGroup
+	subscriptions map[*subscription]*memoryMember
+
+	// called with every published event and audit log, see OnPublish
+	onPublish func(topic string, message kafka.Message, event *Event)
+
+	// reports of the published events, nil unless enabled in the config
+	deliveries chan DeliveryReport
+
+	// redelivery of the events failed by the subscriber callback, the builders can override it
+	subscribeRetryPolicy *RetryPolicy
+
+	// spans of the publishes and the subscribers, nil unless a TracerProvider is configured
+	tracing *tracing
+
+	// time a subscriber can go without fetching before Health reports it
+	subscriberStallThreshold time.Duration
+}
+
+// memoryTopic is a partitioned log of messages
+type memoryTopic struct {
+	name       string
+	partitions [][]kafka.Message
+	groups     map[string]*memoryGroup
+
+	// updated is closed and replaced when messages are appended or a group is rebalanced
+	updated chan struct{}
+}
+
+// memoryGroup tracks the committed offsets and the members of a consumer group
+type memoryGroup struct {
+	committed  []int64
+	members    []*memoryMember
+	generation int
+}
+
+// memoryMember is a consumer of a topic, either alone or as a member of a group
+type memoryMember struct {
+	topic      *memoryTopic
+	group      *memoryGroup
+	generation int
+	partitions []int
+	positions  map[int]int64 // next offset to fetch per assigned partition
+	cursor     int           // round robin over the assigned partitions
+}
+
+// newMemoryClient creates a new instance of MemoryClient
+func newMemoryClient(prefix string, configList ...*BrokerConfig) *MemoryClient {
+	loadAuditEnv()
+
+	client := &MemoryClient{
+		prefix:        prefix,
+		balancer:      &kafka.Hash{},
+		topics:        make(map[string]*memoryTopic),
+		slugs:         make(map[string]int),
+		subscriptions: make(map[*subscription]*memoryMember),
+		topicsUpdated: make(chan struct{}),
+
+		subscribeRetryPolicy:     DefaultSubscribeRetryPolicy(),
+		subscriberStallThreshold: defaultSubscriberStallThreshold,
+	}
+
+	if len(configList) > 0 && configList[0] != nil {
+		client.strictValidation = configList[0].StrictValidation
+		if configList[0].SubscriberStallThreshold > 0 {
+			client.subscriberStallThreshold = configList[0].SubscriberStallThreshold
+		}
+		if configList[0].SubscribeRetryPolicy != nil {
+			client.subscribeRetryPolicy = configList[0].SubscribeRetryPolicy
+		}
+		if configList[0].Balancer != nil {
+			client.balancer = configList[0].Balancer
+		}
+		client.tracing = newTracing(configList[0].TracerProvider)
+		if configList[0].DeliveryReports {
+			size := configList[0].DeliveryReportsSize
+			if size <= 0 {
+				size = defaultDeliveryReportsSize
+			}
+			client.deliveries = make(chan DeliveryReport, size)
+		}
+	}
+
+	return client
+}
+
+// Publish appends the event to the topics, it never fails once the event is valid
+func (client *MemoryClient) Publish(publishBuilder *PublishBuilder) error {
+	_, err := client.PublishAsync(publishBuilder)
+	return err
+}
+
+// PublishAsync appends the event to the topics, the returned result is already completed. A topic the event
+// can't be appended to, once the client is closed, is reported by the result and the ErrorCallback.
+func (client *MemoryClient) PublishAsync(publishBuilder *PublishBuilder) (PublishResult, error) {
+	message, event, err := client.constructEvent(publishBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	client.lock.Lock()
+	closed := client.closed
+	client.lock.Unlock()
+
+	if closed {
+		return nil, ErrClientClosed
+	}
+
+	result := newPublishResult(event, len(publishBuilder.topic))
+
+	for _, pubTopic := range publishBuilder.topic {
+		published, err := client.publishTraced(publishBuilder.ctx, pubTopic, message, event)
+		if err != nil {
+			logrus.
+				WithField("Topic Name", pubTopic).
+				WithField("Event Name", publishBuilder.eventName).
+				Error("unable to publish event: ", err)
+
+			if publishBuilder.errorCallback != nil {
+				publishBuilder.errorCallback(event, err)
+			}
+
+			report := newDeliveryReport(event.ID, constructTopic(client.prefix, pubTopic), nil, 1, err)
+			client.reportDelivery(report)
+			result.report(report)
+
+			continue
+		}
+
+		report := newDeliveryReport(event.ID, published.Topic, &messageDelivery{
+			partition: published.Partition,
+			offset:    published.Offset,
+			time:      published.Time,
+		}, 1, nil)
+		client.reportDelivery(report)
+		result.report(report)
+	}
+
+	return result, nil
+}
+
+// PublishSync appends the event to the topics
+func (client *MemoryClient) PublishSync(publishBuilder *PublishBuilder) error {
+	message, event, err := client.constructEvent(publishBuilder)
+	if err != nil {
+		return err
+	}
+
+	if len(publishBuilder.topic) != 1 {
+		return errPubSyncTopics
+	}
+
+	_, err = client.publishTraced(publishBuilder.ctx, publishBuilder.topic[0], message, event)
+
+	return err
+}
+
+// constructEvent validates the publish builder and constructs its event
+func (client *MemoryClient) constructEvent(publishBuilder *PublishBuilder) (kafka.Message, *Event, error) {
+	if publishBuilder == nil {
+		logrus.Error(errPubNilEvent)
+		return kafka.Message{}, nil, errPubNilEvent
+	}
+
+	err := validatePublishEvent(publishBuilder, client.strictValidation)
+	if err != nil {
+		logrus.
+			WithField("Topic Name", publishBuilder.topic).
+			WithField("Event Name", publishBuilder.eventName).
+			Error("incorrect publisher event: ", err)
+		return kafka.Message{}, nil, err
+	}
+
+	message, event, err := ConstructEvent(publishBuilder)
+	if err != nil {
+		return kafka.Message{}, nil, fmt.Errorf("unable to construct event : %s , error : %v",
+			publishBuilder.eventName, err)
+	}
+
+	return message, event, nil
+}
+
+// PublishBatch appends the events to the topics, it never fails once the events are valid
+func (client *MemoryClient) PublishBatch(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	return client.publishBatch(publishBuilders, true)
+}
+
+// PublishBatchSync appends the events to the topics. The results report the events that weren't published.
+func (client *MemoryClient) PublishBatchSync(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	return client.publishBatch(publishBuilders, false)
+}
+
+// publishBatch appends the events to the topics, reporting their deliveries if the batch is asynchronous
+func (client *MemoryClient) publishBatch(publishBuilders []*PublishBuilder, async bool) ([]BatchResult, error) {
+	// the prefix is added by publish
+	results, groups := constructBatch("", client.strictValidation, publishBuilders)
+
+	for _, group := range groups {
+		for _, batchMsg := range group.messages {
+			published, err := client.publishTraced(publishBuilders[batchMsg.index].ctx, group.topic, batchMsg.message,
+				batchMsg.event)
+			if err != nil {
+				if results[batchMsg.index].Err == nil {
+					results[batchMsg.index].Err = err
+				}
+
+				batchMessageFailed(publishBuilders[batchMsg.index], group.topic, batchMsg.event, err)
+
+				continue
+			}
+
+			if async {
+				client.reportDelivery(newDeliveryReport(batchMsg.event.ID, published.Topic, &messageDelivery{
+					partition: published.Partition,
+					offset:    published.Offset,
+					time:      published.Time,
+				}, 1, nil))
+			}
+		}
+	}
+
+	return results, batchError(results)
+}
+
+// PublishAuditLog appends the audit log to the audit log topic
+func (client *MemoryClient) PublishAuditLog(auditLogBuilder *AuditLogBuilder) error {
+	if !auditEnabled {
+		return nil
+	}
+
+	var topic = auditLogTopicDefault
+	if auditLogTopic != "" {
+		topic = auditLogTopic
+	}
+
+	message, err := auditLogBuilder.Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.publishTraced(auditLogBuilder.ctx, topic, message, nil)
+
+	return err
+}
+
+// PublishMessage appends a raw message to the topic without any validation, e.g. to inject events in tests.
+// The topic prefix is added to the topic.
+func (client *MemoryClient) PublishMessage(topic string, message kafka.Message) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.closed {
+		return ErrClientClosed
+	}
+
+	client.appendMessage(constructTopic(client.prefix, topic), message)
+
+	return nil
+}
+
+// OnPublish sets a function that is called with every event and audit log published through the client,
+// e.g. to record them in tests. The topic is given without prefix and event is nil for audit logs.
+func (client *MemoryClient) OnPublish(f func(topic string, message kafka.Message, event *Event)) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.onPublish = f
+}
+
+// publish appends the message to the topic and calls the OnPublish function.
+// It returns the message with its topic, partition and offset.
+func (client *MemoryClient) publish(topic string, message kafka.Message, event *Event) (kafka.Message, error) {
+	client.lock.Lock()
+
+	if client.closed {
+		client.lock.Unlock()
+		return kafka.Message{}, ErrClientClosed
+	}
+
+	message = client.appendMessage(constructTopic(client.prefix, topic), message)
+	onPublish := client.onPublish
+
+	client.lock.Unlock()
+
+	if onPublish != nil {
+		onPublish(topic, message, event)
+	}
+
+	return message, nil
+}
+
+// publishTraced publishes the message with a producer span if the client is traced, see publish
+func (client *MemoryClient) publishTraced(ctx context.Context, topic string, message kafka.Message, event *Event) (
+	kafka.Message, error) {
+	message, endSpan := client.tracing.startPublishMessage(ctx, constructTopic(client.prefix, topic), message)
+
+	published, err := client.publish(topic, message, event)
+	endSpan(err)
+
+	return published, err
+}
+
+// Deliveries returns the reports of the events published with Publish, PublishAsync and PublishBatch, nil if
+// delivery reports aren't enabled in the config. The channel is closed by Close.
+func (client *MemoryClient) Deliveries() <-chan DeliveryReport {
+	return client.deliveries
+}
+
+// reportDelivery sends the report to the deliveries channel unless the client is closed
+func (client *MemoryClient) reportDelivery(report DeliveryReport) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if !client.closed {
+		sendDeliveryReport(client.deliveries, report)
+	}
+}
+
+// appendMessage appends the message to the partition chosen by the balancer and returns it with
+// its partition and offset. client.lock must be held
+func (client *MemoryClient) appendMessage(topicName string, message kafka.Message) kafka.Message {
+	topic := client.getTopic(topicName)
+
+	partitions := make([]int, len(topic.partitions))
+	for i := range partitions {
+		partitions[i] = i
+	}
+
+	partition := client.balancer.Balance(message, partitions...)
+
+	message.Topic = topicName
+	message.Partition = partition
+	message.Offset = int64(len(topic.partitions[partition]))
+	message.Time = time.Now().UTC()
+
+	topic.partitions[partition] = append(topic.partitions[partition], message)
+	topic.notify()
+
+	return message
+}
+
+// getTopic returns the topic, creating it if needed. client.lock must be held
+func (client *MemoryClient) getTopic(name string) *memoryTopic {
+	topic, ok := client.topics[name]
+	if !ok {
+		topic = &memoryTopic{
+			name:       name,
+			partitions: make([][]kafka.Message, memoryPartitionCount),
+			groups:     make(map[string]*memoryGroup),
+			updated:    make(chan struct{}),
+		}
+		client.topics[name] = topic
+
+		close(client.topicsUpdated)
+		client.topicsUpdated = make(chan struct{})
+	}
+
+	return topic
+}
+
+// Register register callback function and then subscribe topic
+func (client *MemoryClient) Register(subscribeBuilder *SubscribeBuilder) error {
+	_, err := client.Subscribe(subscribeBuilder)
+	return err
+}
+
+// Subscribe register callback function and then subscribe topic.
+// Events are delivered from the offset set in the SubscribeBuilder, or from the committed offset of the group.
+func (client *MemoryClient) Subscribe(subscribeBuilder *SubscribeBuilder) (Subscription, error) {
+	if subscribeBuilder == nil {
+		logrus.Error(errSubNilEvent)
+		return nil, errSubNilEvent
+	}
+
+	err := validateSubscribeEvent(subscribeBuilder)
+	if err != nil {
+		logrus.
+			WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+			WithField("Event Name", subscribeBuilder.subscribedEventName()).
+			Error("incorrect subscriber event: ", err)
+
+		return nil, err
+	}
+
+	subscribeBuilder.prefix = client.prefix
+	subscribeBuilder.tracing = client.tracing
+	subscribeBuilder.setEventNames()
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.closed {
+		return nil, ErrClientClosed
+	}
+
+	if subscribeBuilder.isMultiTopic() {
+		return client.subscribeTopics(subscribeBuilder), nil
+	}
+
+	return client.subscribe(subscribeBuilder)
+}
+
+// subscribe starts the subscriber of a topic. client.lock must be held
+func (client *MemoryClient) subscribe(subscribeBuilder *SubscribeBuilder) (*subscription, error) {
+	topic := constructTopic(client.prefix, subscribeBuilder.subscribedTopic())
+	slug := subscribeBuilder.Slug()
+
+	if client.slugs[slug] > 0 && subscribeBuilder.groupID == "" {
+		return nil, fmt.Errorf(
+			"topic and event already registered. topic: %s , event: %s",
+			topic,
+			subscribeBuilder.subscribedEventName(),
+		)
+	}
+	client.slugs[slug]++
+
+	// join synchronously, so events published right after Subscribe are delivered
+	member := client.join(topic, subscribeBuilder)
+
+	sub := newSubscription(subscribeBuilder)
+	if request := sub.takeSeek(); request != nil {
+		client.seekMember(member, request.position)
+	}
+
+	client.subscriptions[sub] = member
+	client.subscribers.Add(1)
+
+	go client.runSubscription(sub, topic, member)
+
+	return sub, nil
+}
+
+// subscribeTopics starts a multi topic subscriber, consuming every topic with an internal subscriber.
+// client.lock must be held
+func (client *MemoryClient) subscribeTopics(subscribeBuilder *SubscribeBuilder) *subscription {
+	sub := newSubscription(subscribeBuilder)
+	client.subscriptions[sub] = nil
+	client.subscribers.Add(1)
+
+	// subscribe synchronously, so events published right after Subscribe are delivered.
+	// The internal subscribers start at the position of StartAt and seek with the subscriber.
+	subscribed := make(map[string]*subscription)
+	client.subscribeMatchingTopics(sub, subscribed)
+
+	sub.takeSeek()
+	sub.topicSubscriptions = func() []*subscription {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+
+		topicSubscriptions := make([]*subscription, 0, len(subscribed))
+		for _, topicSubscription := range subscribed {
+			topicSubscriptions = append(topicSubscriptions, topicSubscription)
+		}
+
+		return topicSubscriptions
+	}
+
+	go client.runTopics(sub, subscribed)
+
+	return sub
+}
+
+// subscribeMatchingTopics subscribes the topics of the multi topic subscriber that aren't subscribed yet: its topics,
+// or the existing topics matching its pattern. client.lock must be held
+func (client *MemoryClient) subscribeMatchingTopics(sub *subscription, subscribed map[string]*subscription) {
+	topics := sub.builder.subscribedTopics()
+	if sub.builder.topicPattern != nil {
+		names := make([]string, 0, len(client.topics))
+		for name := range client.topics {
+			names = append(names, name)
+		}
+
+		topics = topics[:0]
+		for _, name := range matchTopics(client.prefix, sub.builder.topicPattern, names) {
+			topics = append(topics, trimTopicPrefix(client.prefix, name))
+		}
+	}
+
+	for _, topic := range topics {
+		if _, ok := subscribed[topic]; ok {
+			continue
+		}
+
+		topicSubscription, err := client.subscribe(sub.builder.topicBuilder(topic, sub.ctx))
+		if err != nil {
+			logrus.
+				WithField("Topic Name", topic).
+				WithField("Event Name", sub.builder.subscribedEventName()).
+				Error("unable to subscribe topic: ", err)
+
+			continue
+		}
+
+		if sub.paused() {
+			topicSubscription.Pause()
+		}
+
+		subscribed[topic] = topicSubscription
+	}
+}
+
+// runTopics subscribes the topics created afterwards matching the pattern of a multi topic subscriber,
+// until it's stopped. The internal subscribers are unsubscribed then.
+func (client *MemoryClient) runTopics(sub *subscription, subscribed map[string]*subscription) {
+	defer func() {
+		for _, topicSubscription := range subscribed {
+			_ = topicSubscription.Unsubscribe(context.Background())
+		}
+
+		if sub.ctx.Err() != nil {
+			sub.notifyCancelled()
+		}
+
+		client.lock.Lock()
+		delete(client.subscriptions, sub)
+		client.lock.Unlock()
+
+		sub.finish(sub.ctx.Err())
+		client.subscribers.Done()
+	}()
+
+	for {
+		var updated chan struct{}
+		if sub.builder.topicPattern != nil {
+			client.lock.Lock()
+			if !client.closed {
+				client.subscribeMatchingTopics(sub, subscribed)
+			}
+			updated = client.topicsUpdated
+			client.lock.Unlock()
+		}
+
+		select {
+		case <-updated:
+		case <-sub.stopCtx.Done():
+			return
+		}
+	}
+}
+
+// runSubscription delivers events to the callback until the subscription is stopped.
+// When a callback fails the event is redelivered after a delay, to another group member if there's one.
+// nolint: gocognit,funlen
+func (client *MemoryClient) runSubscription(sub *subscription, topic string, member *memoryMember) {
+	loggerFields := logrus.
+		WithField("Topic Name", topic).
+		WithField("Event Name", sub.builder.subscribedEventName())
+
+	var err error
+
+	retryPolicy := sub.builder.subscribeRetryPolicy(client.subscribeRetryPolicy)
+	deadLetterTopic := sub.builder.deadLetterTopicName()
+	retryTopicDelay := sub.builder.retryTopicDelay()
+	groupID := constructGroupID(client.prefix, sub.builder.groupID)
+
+	stopRetryTopics := subscribeRetryTopics(sub, client.Subscribe)
+
+	var tracker *ackTracker
+	if sub.builder.callbackDelivery != nil || sub.builder.concurrency > 1 {
+		tracker = client.newAckTracker(sub, member, groupID, retryPolicy, loggerFields)
+		defer tracker.close()
+	}
+
+	// the generation and the partitions of the member reported to the partition hooks, nil until assigned
+	var assignedGeneration int
+	var assigned []topicPartition
+
+	defer func() {
+		stopRetryTopics()
+
+		if assigned != nil {
+			sub.partitionsRevoked(int32(assignedGeneration), assigned)
+		}
+
+		client.lock.Lock()
+		client.leave(member)
+		client.slugs[sub.builder.Slug()]--
+		delete(client.subscriptions, sub)
+		client.lock.Unlock()
+
+		sub.finish(err)
+		client.subscribers.Done()
+	}()
+
+	// the workers are stopped before the member leaves, so the processed events are committed
+	var workers *keyWorkers
+	if sub.builder.concurrency > 1 {
+		workers = newKeyWorkers(sub.builder.concurrency, tracker.process)
+		defer workers.close()
+	}
+
+	var batch *batchConsumer
+	if sub.builder.callbackBatch != nil {
+		batch = client.newBatchConsumer(sub, member, groupID, retryPolicy, loggerFields)
+	}
+
+	for {
+		if sub.ctx.Err() != nil {
+			sub.notifyCancelled()
+
+			err = sub.ctx.Err()
+
+			return
+		}
+
+		if sub.stopped() {
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+			}
+
+			return
+		}
+
+		if sub.seekPending() {
+			// the processing events are finished first so their offsets don't override the seek
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+				tracker.reset()
+			}
+
+			if request := sub.takeSeek(); request != nil {
+				client.lock.Lock()
+				client.seekMember(member, request.position)
+				client.lock.Unlock()
+
+				request.finish(nil)
+			}
+
+			continue
+		}
+
+		if sub.builder.hasPartitionHooks() && client.rebalanced(member, assignedGeneration) {
+			// the processing events are finished and committed before the partitions are revoked
+			if workers != nil {
+				workers.drain()
+			}
+
+			if tracker != nil {
+				tracker.drain(sub.ctx)
+				tracker.reset()
+			}
+
+			if assigned != nil {
+				sub.partitionsRevoked(int32(assignedGeneration), assigned)
+			}
+
+			assignedGeneration, assigned = client.assignment(member)
+			sub.partitionsAssigned(int32(assignedGeneration), assigned)
+
+			continue
+		}
+
+		if sub.paused() {
+			// the member stays in its group while it's not fetching
+			sub.waitResumed(sub.stopCtx)
+			continue
+		}
+
+		message, ok, updated := client.fetch(member)
+		if ok {
+			sub.fetched(message)
+		}
+
+		if !ok {
+			controlCtx, cancelControl := sub.fetchContext(sub.stopCtx)
+			waitCtx, cancelWait := controlCtx, context.CancelFunc(func() {})
+			if tracker != nil {
+				waitCtx, cancelWait = tracker.waitContext(controlCtx)
+			}
+
+			select {
+			case <-updated:
+			case <-waitCtx.Done():
+			}
+			if controlCtx.Err() == context.DeadlineExceeded {
+				sub.polled()
+			}
+			cancelWait()
+			cancelControl()
+
+			if tracker != nil {
+				// a requeued delivery or an ack deadline may be due
+				tracker.processDue()
+			}
+
+			continue
+		}
+
+		if batch != nil {
+			// the batch consumer only fails to dead letter an event when the client is closed, the subscription
+			// stops then
+			_ = batch.process(batch.collect(message))
+			continue
+		}
+
+		if workers == nil && tracker != nil {
+			tracker.deliver(message)
+			tracker.processDue()
+			continue
+		}
+
+		if retryTopicDelay > 0 && !sub.waitUntil(message.Time.Add(retryTopicDelay)) {
+			// the subscription is stopped before the event of the retry topic is due
+			continue
+		}
+
+		if workers != nil {
+			// the workers only fail to forward an event when the client is closed, the subscription stops then
+			if tracker.fetchedBefore(message) {
+				// the positions are reset to the committed offsets after a rebalance,
+				// the processing events are finished first so they're committed and not processed again
+				workers.drain()
+			}
+
+			if tracker.track(message) {
+				workers.dispatch(sub.stopCtx, message)
+			}
+
+			continue
+		}
+
+		errProcess := processMessage(sub.ctx, sub.builder, message, topic)
+		if errProcess == nil {
+			client.commit(member, message)
+			continue
+		}
+
+		sub.failed(errProcess)
+
+		if len(sub.builder.retryDelays) > 0 {
+			// the event is published to the next retry topic and committed
+			forwardTopic, forwardMessage := sub.builder.forwardFailed(message, message.Topic, groupID, errProcess)
+			loggerFields.WithField("Retry Topic", forwardTopic).Warn("unable to process the event: ", errProcess)
+
+			if _, errPublish := client.publish(forwardTopic, forwardMessage, nil); errPublish != nil {
+				// the client is closed, the subscription stops
+				continue
+			}
+
+			client.commit(member, message)
+			continue
+		}
+
+		attempt, retry := sub.retryEvent(retryPolicy, message, errProcess)
+		if !retry {
+			// the event is committed, so it's not delivered again
+			loggerFields.WithField("Attempt", attempt).Error("giving up processing the event: ", errProcess)
+
+			if deadLetterTopic != "" {
+				letter := deadLetter{
+					topic:     topic,
+					groupID:   groupID,
+					partition: message.Partition,
+					offset:    message.Offset,
+					attempts:  attempt,
+					err:       errProcess,
+				}
+
+				if _, errPublish := client.publish(deadLetterTopic, newDeadLetterMessage(message, letter), nil); errPublish != nil {
+					// the client is closed, the subscription stops
+					continue
+				}
+			}
+
+			client.commit(member, message)
+			continue
+		}
+
+		loggerFields.WithField("Attempt", attempt).Error("unable to process the event: ", errProcess)
+
+		// hand the event over to another member, or deliver it again to this one after the delay
+		sub.setState(SubscriptionRestarting)
+		client.lock.Lock()
+		client.leave(member)
+		client.lock.Unlock()
+
+		select {
+		case <-time.After(sub.restartDelay):
+		case <-sub.stopCtx.Done():
+			continue
+		}
+
+		client.lock.Lock()
+		member = client.rejoin(member, message)
+		client.lock.Unlock()
+
+		loggerFields.Warn("re-registered because event processing failed")
+		sub.setState(SubscriptionRunning)
+	}
+}
+
+// newAckTracker creates the tracker of a manual acknowledgement or concurrent subscriber,
+// committing the offsets of the member
+func (client *MemoryClient) newAckTracker(sub *subscription, member *memoryMember, groupID string,
+	policy *RetryPolicy, loggerFields *logrus.Entry) *ackTracker {
+	tracker := newAckTracker(sub, groupID, policy, loggerFields)
+
+	tracker.commit = func(message kafka.Message) {
+		client.commit(member, message)
+	}
+
+	tracker.publish = func(publishTopic string, message kafka.Message) error {
+		_, err := client.publish(publishTopic, message, nil)
+		return err
+	}
+
+	return tracker
+}
+
+// newBatchConsumer creates the batch consumer of a CallbackBatch subscriber, fetching and committing with the member
+func (client *MemoryClient) newBatchConsumer(sub *subscription, member *memoryMember, groupID string,
+	policy *RetryPolicy, loggerFields *logrus.Entry) *batchConsumer {
+	return &batchConsumer{
+		sub:          sub,
+		groupID:      groupID,
+		policy:       policy,
+		loggerFields: loggerFields,
+		fetch: func(ctx context.Context) (kafka.Message, error) {
+			message, err := client.fetchContext(ctx, member)
+			if err == nil {
+				sub.fetched(message)
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				sub.polled()
+			}
+
+			return message, err
+		},
+		commit: func(messages []kafka.Message) {
+			for _, message := range messages {
+				client.commit(member, message)
+			}
+		},
+		publish: func(publishTopic string, message kafka.Message) error {
+			_, err := client.publish(publishTopic, message, nil)
+			return err
+		},
+	}
+}
+
+// join creates a member of the topic, in the group if the subscriber has a group ID. client.lock must be held
+func (client *MemoryClient) join(topicName string, subscribeBuilder *SubscribeBuilder) *memoryMember {
+	topic := client.getTopic(topicName)
+	member := &memoryMember{
+		topic:     topic,
+		positions: make(map[int]int64),
+	}
+
+	if subscribeBuilder.groupID == "" {
+		for partition, messages := range topic.partitions {
+			member.partitions = append(member.partitions, partition)
+			member.positions[partition] = resolveMemoryOffset(subscribeBuilder.offset, len(messages))
+		}
+
+		return member
+	}
+
+	groupID := constructGroupID(client.prefix, subscribeBuilder.groupID)
+	group, ok := topic.groups[groupID]
+	if !ok {
+		// like a kafka reader without committed offsets, the group starts at the subscriber offset
+		group = &memoryGroup{committed: make([]int64, len(topic.partitions))}
+		for partition, messages := range topic.partitions {
+			group.committed[partition] = resolveMemoryOffset(subscribeBuilder.offset, len(messages))
+		}
+		topic.groups[groupID] = group
+	}
+
+	member.group = group
+	group.members = append(group.members, member)
+	topic.rebalance(group)
+
+	return member
+}
+
+// rejoin adds a member back after a failed callback. Without a group the failed message is fetched again.
+// client.lock must be held
+func (client *MemoryClient) rejoin(member *memoryMember, failed kafka.Message) *memoryMember {
+	if member.group == nil {
+		member.positions[failed.Partition] = failed.Offset
+		return member
+	}
+
+	member.group.members = append(member.group.members, member)
+	member.topic.rebalance(member.group)
+
+	return member
+}
+
+// leave removes the member from its group, its partitions are assigned to the other members.
+// client.lock must be held
+func (client *MemoryClient) leave(member *memoryMember) {
+	group := member.group
+	if group == nil {
+		return
+	}
+
+	for i, m := range group.members {
+		if m == member {
+			group.members = append(group.members[:i], group.members[i+1:]...)
+			member.topic.rebalance(group)
+
+			return
+		}
+	}
+}
+
+// rebalanced returns true if the group of the member is rebalanced after the generation
+func (client *MemoryClient) rebalanced(member *memoryMember, generation int) bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	return member.generation != generation
+}
+
+// assignment returns the generation of the group of the member and its partitions
+func (client *MemoryClient) assignment(member *memoryMember) (int, []topicPartition) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	partitions := make([]topicPartition, 0, len(member.partitions))
+	for _, partition := range member.partitions {
+		partitions = append(partitions, topicPartition{topic: member.topic.name, partition: partition})
+	}
+
+	return member.generation, partitions
+}
+
+// fetch returns the next message of the member. If there's none, it returns a channel closed on the next update.
+func (client *MemoryClient) fetch(member *memoryMember) (kafka.Message, bool, <-chan struct{}) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for i := 0; i < len(member.partitions); i++ {
+		partition := member.partitions[(member.cursor+i)%len(member.partitions)]
+		position := member.positions[partition]
+
+		if position < int64(len(member.topic.partitions[partition])) {
+			member.cursor = (member.cursor + i + 1) % len(member.partitions)
+			member.positions[partition] = position + 1
+
+			return member.topic.partitions[partition][position], true, nil
+		}
+	}
+
+	return kafka.Message{}, false, member.topic.updated
+}
+
+// fetchContext waits for the next message of the member until ctx is done
+func (client *MemoryClient) fetchContext(ctx context.Context, member *memoryMember) (kafka.Message, error) {
+	for {
+		message, ok, updated := client.fetch(member)
+		if ok {
+			return message, nil
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// commit marks the message as processed by the group. Commits of a stale generation are ignored
+// as the partition may already be processed by another member.
+func (client *MemoryClient) commit(member *memoryMember, message kafka.Message) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	group := member.group
+	if group == nil || member.generation != group.generation {
+		return
+	}
+
+	if message.Offset+1 > group.committed[message.Partition] {
+		group.committed[message.Partition] = message.Offset + 1
+	}
+}
+
+// rebalance assigns the partitions to the group members and resets their positions to the committed offsets
+func (topic *memoryTopic) rebalance(group *memoryGroup) {
+	group.generation++
+
+	for i, member := range group.members {
+		member.generation = group.generation
+		member.partitions = nil
+		member.positions = make(map[int]int64)
+		member.cursor = 0
+
+		for partition := i; partition < len(topic.partitions); partition += len(group.members) {
+			member.partitions = append(member.partitions, partition)
+			member.positions[partition] = group.committed[partition]
+		}
+	}
+
+	topic.notify()
+}
+
+// notify wakes up the members waiting for an update of the topic
+func (topic *memoryTopic) notify() {
+	close(topic.updated)
+	topic.updated = make(chan struct{})
+}
+
+// resolveMemoryOffset converts the subscriber offset to a position in a partition of the given size
+func resolveMemoryOffset(offset int64, size int) int64 {
+	switch {
+	case offset == kafka.FirstOffset:
+		return 0
+	case offset == kafka.LastOffset || offset > int64(size):
+		return int64(size)
+	case offset < 0:
+		return 0
+	default:
+		return offset
+	}
+}
+
+// PauseAll pauses every subscriber of the client, see Subscription.Pause
+func (client *MemoryClient) PauseAll() {
+	for _, sub := range client.listSubscriptions() {
+		sub.Pause()
+	}
+}
+
+// ResumeAll resumes every subscriber of the client
+func (client *MemoryClient) ResumeAll() {
+	for _, sub := range client.listSubscriptions() {
+		sub.Resume()
+	}
+}
+
+// listSubscriptions returns the running subscriptions
+func (client *MemoryClient) listSubscriptions() []*subscription {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	subscriptions := make([]*subscription, 0, len(client.subscriptions))
+	for sub := range client.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+
+	return subscriptions
+}
+
+// Health reports the client isn't ready once it's closed, the subscribers of the memory stream don't stall
+func (client *MemoryClient) Health(ctx context.Context) HealthReport {
+	return client.subscriberHealth()
+}
+
+// subscriberHealth checks the client and its subscribers, there's no broker
+func (client *MemoryClient) subscriberHealth() HealthReport {
+	report := newHealthReport()
+
+	client.lock.Lock()
+	closed := client.closed
+	client.lock.Unlock()
+
+	if closed {
+		report.add(healthCheckClient, "", ErrClientClosed)
+		return report
+	}
+
+	report.add(healthCheckClient, "", nil)
+	report.checkSubscribers(client.listSubscriptions(), client.subscriberStallThreshold)
+
+	return report
+}
+
+// Describe returns a snapshot of the subscriptions of the client, it doesn't have writers.
+// The internal subscribers of the topics of a multi topic subscriber are described with it.
+func (client *MemoryClient) Describe() ClientDescription {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	description := ClientDescription{Writers: []WriterDescription{}, Subscriptions: []SubscriptionDescription{}}
+
+	topicSubscriptions := make(map[*SubscribeBuilder][]*subscription)
+	for sub := range client.subscriptions {
+		if parent := sub.builder.topicParent; parent != nil {
+			topicSubscriptions[parent] = append(topicSubscriptions[parent], sub)
+		}
+	}
+
+	for sub, member := range client.subscriptions {
+		if sub.builder.topicParent != nil {
+			continue
+		}
+
+		subDescription := client.describeSubscription(sub, member)
+		for _, topicSubscription := range topicSubscriptions[sub.builder] {
+			subDescription.merge(client.describeSubscription(topicSubscription, client.subscriptions[topicSubscription]))
+		}
+
+		description.Subscriptions = append(description.Subscriptions, subDescription)
+	}
+
+	sortDescription(description)
+
+	return description
+}
+
+// describeSubscription returns the description of the subscription with the partitions of its member.
+// client.lock must be held
+func (client *MemoryClient) describeSubscription(sub *subscription, member *memoryMember) SubscriptionDescription {
+	description := sub.describe()
+	if member == nil {
+		return description
+	}
+
+	description.Partitions = make([]PartitionDescription, 0, len(member.partitions))
+	for _, partition := range member.partitions {
+		committed := int64(-1)
+		if member.group != nil {
+			committed = member.group.committed[partition]
+		}
+
+		description.Partitions = append(description.Partitions, PartitionDescription{
+			Topic:           trimTopicPrefix(client.prefix, member.topic.name),
+			Partition:       partition,
+			CommittedOffset: committed,
+			Lag:             int64(len(member.topic.partitions[partition])) - member.positions[partition],
+		})
+	}
+
+	return description
+}
+
+// Close stops accepting new events and subscriptions and waits until the running callbacks finish
+// or ctx is done.
+func (client *MemoryClient) Close(ctx context.Context) error {
+	client.lock.Lock()
+	if client.closed {
+		client.lock.Unlock()
+		return nil
+	}
+	client.closed = true
+
+	if client.deliveries != nil {
+		close(client.deliveries)
+	}
+
+	subscriptions := make([]*subscription, 0, len(client.subscriptions))
+	for sub := range client.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	client.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.stopWithReason(ErrClientClosed)
+	}
+
+	err := waitWithContext(ctx, &client.subscribers)
+	if err != nil {
+		for _, sub := range subscriptions {
+			sub.cancel()
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createMemoryClient(t *testing.T) Client {
+	t.Helper()
+
+	client, err := NewClient(prefix, eventStreamMemory, nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	return client
+}
+
+func publishMemoryEvents(t *testing.T, client Client, topic, key string, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		err := client.Publish(
+			NewPublish().
+				Topic(topic).
+				EventName("testEvent").
+				Key(key).
+				EventID(i))
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryKeyPartitioning(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+
+	publishMemoryEvents(t, client, topicName, testKey, 10)
+
+	events := make(chan *Event, 10)
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(kafka.FirstOffset).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					events <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	var partition int
+	for i := 0; i < 10; i++ {
+		select {
+		case event := <-events:
+			if i == 0 {
+				partition = event.Partition
+			}
+			assert.Equal(t, partition, event.Partition, "events with the same key should be in one partition")
+			assert.Equal(t, i, event.EventID, "events with the same key should be ordered")
+			assert.Equal(t, int64(i), event.Offset)
+		case <-time.After(time.Second):
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+}
+
+func TestMemoryGroupSharesPartitions(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+	groupID := generateID()
+
+	var lock sync.Mutex
+	received := make(map[int]int)            // event ID -> deliveries
+	partitions := make(map[int]map[int]bool) // member -> partitions
+	done := make(chan bool, 100)
+
+	for member := 0; member < 2; member++ {
+		member := member
+		partitions[member] = make(map[int]bool)
+
+		err := client.Register(
+			NewSubscribe().
+				Topic(topicName).
+				EventName("testEvent").
+				GroupID(groupID).
+				Callback(func(ctx context.Context, event *Event, err error) error {
+					if event == nil {
+						return nil
+					}
+
+					lock.Lock()
+					received[event.EventID]++
+					partitions[member][event.Partition] = true
+					lock.Unlock()
+
+					done <- true
+
+					return nil
+				}))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 40; i++ {
+		require.NoError(t, client.Publish(NewPublish().Topic(topicName).EventName("testEvent").EventID(i)))
+	}
+
+	for i := 0; i < 40; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Len(t, received, 40)
+	for id, count := range received {
+		assert.Equal(t, 1, count, fmt.Sprintf("event %d should be delivered once", id))
+	}
+	for partition := range partitions[0] {
+		assert.False(t, partitions[1][partition], "group members should not share a partition")
+	}
+}
+
+func TestMemoryOffset(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+
+	publishMemoryEvents(t, client, topicName, testKey, 3)
+
+	events := make(chan int, 10)
+	for _, offset := range []int64{kafka.FirstOffset, kafka.LastOffset} {
+		offset := offset
+		err := client.Register(
+			NewSubscribe().
+				Topic(topicName).
+				EventName("testEvent").
+				GroupID(generateID()).
+				Offset(offset).
+				Callback(func(ctx context.Context, event *Event, err error) error {
+					if event != nil {
+						events <- event.EventID
+					}
+					return nil
+				}))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, client.Publish(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(3)))
+
+	// first offset subscriber receives 0, 1, 2, 3 and last offset subscriber only 3
+	received := make(map[int]int)
+	for i := 0; i < 5; i++ {
+		select {
+		case id := <-events:
+			received[id]++
+		case <-time.After(time.Second):
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1, 3: 2}, received)
+}
+
+func TestMemoryRedeliveryOnError(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	topicName := constructTopicTest()
+
+	var attempts int
+	done := make(chan bool, 1)
+
+	sub, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				attempts++
+				if attempts == 1 {
+					return fmt.Errorf("failed to process event %s", event.ID)
+				}
+
+				done <- true
+
+				return nil
+			}))
+	require.NoError(t, err)
+
+	publishMemoryEvents(t, client, topicName, testKey, 1)
+
+	select {
+	case <-done:
+	case <-time.After(3 * DefaultSubscribeRetryPolicy().InitialInterval):
+		assert.FailNow(t, errorTimeout)
+	}
+
+	assert.Equal(t, 2, attempts, "failed event should be delivered again")
+	assert.Equal(t, SubscriptionRunning, sub.State())
+}
+
+func TestMemoryPublishAsyncPartialFailure(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, err := NewClient(prefix, eventStreamMemory, nil, &BrokerConfig{DeliveryReports: true})
+	require.NoError(t, err)
+
+	memoryClient, ok := client.(*MemoryClient)
+	require.True(t, ok)
+
+	// the client is closed once the event is appended to the first topic
+	memoryClient.OnPublish(func(topic string, message kafka.Message, event *Event) {
+		_ = client.Close(ctx)
+	})
+
+	firstTopic, secondTopic := constructTopicTest(), constructTopicTest()
+
+	callbackErr := make(chan error, 1)
+	result, err := client.PublishAsync(
+		NewPublish().
+			Topic(firstTopic, secondTopic).
+			EventName("testEvent").
+			ErrorCallback(func(event *Event, err error) {
+				callbackErr <- err
+			}))
+	require.NoError(t, err)
+
+	reports, err := result.Wait(ctx)
+	assert.ErrorIs(t, err, ErrClientClosed)
+	require.Len(t, reports, 2, "the result should report every topic")
+
+	assert.Equal(t, constructTopic(prefix, firstTopic), reports[0].Topic)
+	assert.NoError(t, reports[0].Err)
+	assert.Equal(t, constructTopic(prefix, secondTopic), reports[1].Topic)
+	assert.ErrorIs(t, reports[1].Err, ErrClientClosed)
+
+	select {
+	case err = <-callbackErr:
+		assert.ErrorIs(t, err, ErrClientClosed)
+	default:
+		assert.Fail(t, "the error callback should be called")
+	}
+}
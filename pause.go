@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"time"
+)
+
+const subscriberPollInterval = time.Second // maximum duration of a fetch, the subscriber polls again afterwards
+
+// Pause stops fetching events once the current callback finishes, it interrupts the current fetch
+func (sub *subscription) Pause() {
+	sub.pauseLock.Lock()
+	if sub.resumed == nil {
+		sub.resumed = make(chan struct{})
+	}
+	sub.pauseLock.Unlock()
+
+	sub.seekLock.Lock()
+	if sub.wakeFetch != nil {
+		sub.wakeFetch()
+	}
+	sub.seekLock.Unlock()
+
+	if sub.topicSubscriptions != nil {
+		for _, topicSubscription := range sub.topicSubscriptions() {
+			topicSubscription.Pause()
+		}
+	}
+}
+
+// Resume fetches events again after Pause
+func (sub *subscription) Resume() {
+	sub.pauseLock.Lock()
+	if sub.resumed != nil {
+		close(sub.resumed)
+		sub.resumed = nil
+	}
+	sub.pauseLock.Unlock()
+
+	// the subscriber isn't stalled for the time it was paused
+	sub.polled()
+
+	if sub.topicSubscriptions != nil {
+		for _, topicSubscription := range sub.topicSubscriptions() {
+			topicSubscription.Resume()
+		}
+	}
+}
+
+// paused returns true if the subscription is paused
+func (sub *subscription) paused() bool {
+	sub.pauseLock.Lock()
+	defer sub.pauseLock.Unlock()
+
+	return sub.resumed != nil
+}
+
+// waitResumed waits while the subscription is paused, until it's resumed, a seek is requested or ctx is done
+func (sub *subscription) waitResumed(ctx context.Context) {
+	sub.pauseLock.Lock()
+	resumed := sub.resumed
+	sub.pauseLock.Unlock()
+
+	if resumed == nil {
+		return
+	}
+
+	waitCtx, cancelWait := sub.seekContext(ctx)
+	defer cancelWait()
+
+	select {
+	case <-resumed:
+	case <-waitCtx.Done():
+	}
+}
+
+// fetchContext returns a context done once a seek or a pause is requested, when parent is done, or after the poll
+// interval, see polled. The consumer fetches the next message with it.
+func (sub *subscription) fetchContext(parent context.Context) (context.Context, context.CancelFunc) {
+	seekCtx, cancelSeek := sub.seekContext(parent)
+	if sub.paused() {
+		cancelSeek()
+	}
+
+	ctx, cancel := context.WithTimeout(seekCtx, subscriberPollInterval)
+
+	return ctx, func() {
+		cancel()
+		cancelSeek()
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionPause(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	received := make(chan *Event, 10)
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	publishSeekTestEvents(t, client, topicName, 0)
+	assert.Equal(t, []int{0}, receiveEventIDs(ctx, t, received, 1))
+
+	subscription.Pause()
+	assert.Equal(t, SubscriptionPaused, subscription.State())
+
+	if kafkaClient, ok := client.(*KafkaClient); ok {
+		stats := kafkaClient.GetSubscriberStats()
+		require.Len(t, stats, 1)
+		assert.True(t, stats[0].Paused, "the metrics should report the paused subscriber")
+	}
+
+	publishSeekTestEvents(t, client, topicName, 1)
+
+	select {
+	case event := <-received:
+		assert.Fail(t, "a paused subscriber shouldn't receive events", "received: %d", event.EventID)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	subscription.Resume()
+	assert.Equal(t, SubscriptionRunning, subscription.State())
+	assert.Equal(t, []int{1}, receiveEventIDs(ctx, t, received, 1), "the event should be received once resumed")
+}
+
+func TestClientPauseAll(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+
+	subscriptions := make([]Subscription, 0, 2)
+	for i := 0; i < 2; i++ {
+		subscription, err := client.Subscribe(
+			NewSubscribe().
+				Topic(constructTopicTest()).
+				EventName("testEvent").
+				Context(ctx).
+				Callback(func(ctx context.Context, event *Event, err error) error {
+					return nil
+				}))
+		require.NoError(t, err)
+
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	client.PauseAll()
+	for _, subscription := range subscriptions {
+		assert.Equal(t, SubscriptionPaused, subscription.State())
+	}
+
+	client.ResumeAll()
+	for _, subscription := range subscriptions {
+		assert.Equal(t, SubscriptionRunning, subscription.State())
+	}
+}
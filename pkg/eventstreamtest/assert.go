@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstreamtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+)
+
+// updateGoldenEnvKey rewrites the golden files with the actual values when set to true
+const updateGoldenEnvKey = "EVENTSTREAM_UPDATE_GOLDEN"
+
+// AssertPublished asserts that an event matching the topic, event name and matcher was published and returns it.
+// Empty eventName and nil matcher match any event.
+func (recorder *RecorderClient) AssertPublished(t testing.TB, topic, eventName string,
+	matcher Matcher) *eventstream.Event {
+	t.Helper()
+
+	recorder.lock.Lock()
+	events := filterEvents(recorder.events, topic, eventName, matcher)
+	published := filterEvents(recorder.events, topic, "", nil)
+	recorder.lock.Unlock()
+
+	if len(events) == 0 {
+		t.Errorf("event %s was not published to topic %s, published events:\n%s",
+			eventName, topic, formatEvents(published))
+		return nil
+	}
+
+	return events[0]
+}
+
+// AssertNotPublished asserts that no event matching the topic, event name and matcher was published
+func (recorder *RecorderClient) AssertNotPublished(t testing.TB, topic, eventName string, matcher Matcher) bool {
+	t.Helper()
+
+	recorder.lock.Lock()
+	events := filterEvents(recorder.events, topic, eventName, matcher)
+	recorder.lock.Unlock()
+
+	if len(events) > 0 {
+		t.Errorf("event %s was published to topic %s:\n%s", eventName, topic, formatEvents(events))
+		return false
+	}
+
+	return true
+}
+
+// AssertGoldenEvents compares the events with the JSON golden file, ignoring the generated ID, Timestamp,
+// Partition and Offset. The golden file is written when EVENTSTREAM_UPDATE_GOLDEN=true.
+func AssertGoldenEvents(t testing.TB, goldenFile string, events []*eventstream.Event) bool {
+	t.Helper()
+
+	normalized := make([]eventstream.Event, 0, len(events))
+	for _, event := range events {
+		normalizedEvent := *event
+		if normalizedEvent.Key == normalizedEvent.ID {
+			normalizedEvent.Key = ""
+		}
+		normalizedEvent.ID = ""
+		normalizedEvent.Timestamp = ""
+		normalizedEvent.Partition = 0
+		normalizedEvent.Offset = 0
+		normalized = append(normalized, normalizedEvent)
+	}
+
+	return assertGolden(t, goldenFile, normalized)
+}
+
+// AssertGoldenAuditLogs compares the audit logs with the JSON golden file, ignoring the generated ID and
+// Timestamp. The golden file is written when EVENTSTREAM_UPDATE_GOLDEN=true.
+func AssertGoldenAuditLogs(t testing.TB, goldenFile string, auditLogs []*eventstream.AuditLog) bool {
+	t.Helper()
+
+	normalized := make([]eventstream.AuditLog, 0, len(auditLogs))
+	for _, auditLog := range auditLogs {
+		normalizedAuditLog := *auditLog
+		normalizedAuditLog.ID = ""
+		normalizedAuditLog.Timestamp = 0
+		normalized = append(normalized, normalizedAuditLog)
+	}
+
+	return assertGolden(t, goldenFile, normalized)
+}
+
+// assertGolden compares the indented JSON of value with the golden file
+func assertGolden(t testing.TB, goldenFile string, value interface{}) bool {
+	t.Helper()
+
+	actual, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Errorf("unable to marshal golden value: %v", err)
+		return false
+	}
+	actual = append(actual, '\n')
+
+	if strings.EqualFold(os.Getenv(updateGoldenEnvKey), "true") {
+		if err = os.MkdirAll(filepath.Dir(goldenFile), 0o755); err == nil {
+			err = os.WriteFile(goldenFile, actual, 0o644)
+		}
+		if err != nil {
+			t.Errorf("unable to write golden file %s: %v", goldenFile, err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Errorf("unable to read golden file %s, run with %s=true to create it: %v",
+			goldenFile, updateGoldenEnvKey, err)
+		return false
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(expected), bytes.TrimSpace(actual)) {
+		t.Errorf("golden file %s mismatch\nexpected:\n%s\nactual:\n%s", goldenFile, expected, actual)
+		return false
+	}
+
+	return true
+}
+
+// formatEvents formats the events for assertion messages
+func formatEvents(events []*eventstream.Event) string {
+	if len(events) == 0 {
+		return "  (none)"
+	}
+
+	var builder strings.Builder
+	for _, event := range events {
+		value, _ := json.Marshal(event)
+		builder.WriteString("  ")
+		builder.Write(value)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
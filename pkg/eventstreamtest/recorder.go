@@ -0,0 +1,187 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package eventstreamtest provides a recording eventstream client and assertions for testing services
+// that publish and consume events.
+package eventstreamtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/segmentio/kafka-go"
+)
+
+// Matcher reports whether a recorded event is the expected one
+type Matcher func(event *eventstream.Event) bool
+
+// RecorderClient is an eventstream client that records every published event and audit log.
+// It's backed by the memory stream, so events are validated like the other clients and
+// registered subscribers receive the published and injected events.
+type RecorderClient struct {
+	*eventstream.MemoryClient
+
+	lock      sync.Mutex
+	events    []*eventstream.Event
+	auditLogs []*eventstream.AuditLog
+	recorded  chan struct{}
+}
+
+// NewRecorderClient creates a recorder client
+func NewRecorderClient(prefix string, config ...*eventstream.BrokerConfig) *RecorderClient {
+	client, err := eventstream.NewClient(prefix, "memory", nil, config...)
+	if err != nil {
+		panic(err)
+	}
+
+	recorder := &RecorderClient{
+		MemoryClient: client.(*eventstream.MemoryClient),
+		recorded:     make(chan struct{}),
+	}
+	recorder.MemoryClient.OnPublish(recorder.record)
+
+	return recorder
+}
+
+// record stores the published event or audit log, event is nil for audit logs
+func (recorder *RecorderClient) record(topic string, message kafka.Message, event *eventstream.Event) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if event == nil {
+		auditLog := &eventstream.AuditLog{}
+		if err := json.Unmarshal(message.Value, auditLog); err != nil {
+			return
+		}
+		recorder.auditLogs = append(recorder.auditLogs, auditLog)
+	} else {
+		recordedEvent := *event
+		recordedEvent.Topic = topic
+		recordedEvent.Partition = message.Partition
+		recordedEvent.Offset = message.Offset
+		recordedEvent.Key = string(message.Key)
+		recorder.events = append(recorder.events, &recordedEvent)
+	}
+
+	close(recorder.recorded)
+	recorder.recorded = make(chan struct{})
+}
+
+// Events returns the events published so far, in publish order
+func (recorder *RecorderClient) Events() []*eventstream.Event {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return append([]*eventstream.Event(nil), recorder.events...)
+}
+
+// EventsOf returns the events published so far to the topic with the event name.
+// Empty eventName matches any event.
+func (recorder *RecorderClient) EventsOf(topic, eventName string) []*eventstream.Event {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return filterEvents(recorder.events, topic, eventName, nil)
+}
+
+// AuditLogs returns the audit logs published so far, in publish order
+func (recorder *RecorderClient) AuditLogs() []*eventstream.AuditLog {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return append([]*eventstream.AuditLog(nil), recorder.auditLogs...)
+}
+
+// Reset forgets the recorded events and audit logs
+func (recorder *RecorderClient) Reset() {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.events = nil
+	recorder.auditLogs = nil
+}
+
+// Inject delivers the event to the subscribers of the topic as is, without recording it as published.
+// The event key is used as message key, or its ID if the key is empty.
+func (recorder *RecorderClient) Inject(topic string, event *eventstream.Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event : %s , error : %v", event.EventName, err)
+	}
+
+	key := event.Key
+	if key == "" {
+		key = event.ID
+	}
+
+	return recorder.InjectMessage(topic, kafka.Message{
+		Key:   []byte(key),
+		Value: value,
+	})
+}
+
+// InjectMessage delivers a raw message to the subscribers of the topic, e.g. a malformed event
+func (recorder *RecorderClient) InjectMessage(topic string, message kafka.Message) error {
+	return recorder.MemoryClient.PublishMessage(topic, message)
+}
+
+// WaitForEvent waits until an event matching the topic, event name and matcher is published and returns it.
+// Events published before the call are considered too. Empty eventName and nil matcher match any event.
+func (recorder *RecorderClient) WaitForEvent(ctx context.Context, topic, eventName string,
+	matcher Matcher) (*eventstream.Event, error) {
+	for {
+		recorder.lock.Lock()
+		events := filterEvents(recorder.events, topic, eventName, matcher)
+		recorded := recorder.recorded
+		recorder.lock.Unlock()
+
+		if len(events) > 0 {
+			return events[0], nil
+		}
+
+		select {
+		case <-recorded:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("event %s was not published to topic %s: %w", eventName, topic, ctx.Err())
+		}
+	}
+}
+
+// filterEvents returns the events matching the topic, event name and matcher
+func filterEvents(events []*eventstream.Event, topic, eventName string, matcher Matcher) []*eventstream.Event {
+	var filtered []*eventstream.Event
+
+	for _, event := range events {
+		if event.Topic != topic {
+			continue
+		}
+
+		if eventName != "" && event.EventName != eventName {
+			continue
+		}
+
+		if matcher != nil && !matcher(event) {
+			continue
+		}
+
+		filtered = append(filtered, event)
+	}
+
+	return filtered
+}
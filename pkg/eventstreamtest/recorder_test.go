@@ -0,0 +1,179 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstreamtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTopic     = "user"
+	testEventName = "userCreated"
+)
+
+func createRecorderClient(t *testing.T) *RecorderClient {
+	t.Helper()
+
+	recorder := NewRecorderClient("test")
+
+	t.Cleanup(func() {
+		_ = recorder.Close(context.Background())
+	})
+
+	return recorder
+}
+
+func TestRecorderClientRecordsPublishedEvents(t *testing.T) {
+	t.Parallel()
+	recorder := createRecorderClient(t)
+
+	var client eventstream.Client = recorder
+	err := client.Publish(
+		eventstream.NewPublish().
+			Topic(testTopic).
+			EventName(testEventName).
+			Namespace("accelbyte").
+			Key("user-1").
+			Payload(map[string]interface{}{"userId": "user-1"}))
+	require.NoError(t, err)
+
+	err = client.PublishSync(eventstream.NewPublish().Topic(testTopic))
+	assert.Error(t, err, "invalid event should be rejected")
+
+	event := recorder.AssertPublished(t, testTopic, testEventName, func(event *eventstream.Event) bool {
+		return event.Payload["userId"] == "user-1"
+	})
+	require.NotNil(t, event)
+	assert.NotEmpty(t, event.ID)
+	assert.NotEmpty(t, event.Timestamp)
+	assert.Equal(t, testTopic, event.Topic)
+	assert.Equal(t, "user-1", event.Key)
+	assert.Equal(t, "accelbyte", event.Namespace)
+
+	recorder.AssertNotPublished(t, testTopic, "userDeleted", nil)
+	assert.Len(t, recorder.Events(), 1)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Events())
+}
+
+func TestRecorderClientRecordsAuditLogs(t *testing.T) {
+	t.Parallel()
+	recorder := createRecorderClient(t)
+
+	err := recorder.PublishAuditLog(
+		eventstream.NewAuditLogBuilder().
+			Category("user").
+			ActionName("create").
+			Actor("c7dcf5ed3e6d4f1d9f5e3c3a7b0b4d8e").
+			IsActorTypeUser(true).
+			ClientID("9c8e5ba5a8e44a1ba4f3e1a0f9d7b2c6").
+			ActorNamespace("accelbyte").
+			ObjectNamespace("accelbyte"))
+	require.NoError(t, err)
+
+	auditLogs := recorder.AuditLogs()
+	require.Len(t, auditLogs, 1)
+	assert.Equal(t, "create", auditLogs[0].ActionName)
+	assert.NotEmpty(t, auditLogs[0].ID)
+	assert.Empty(t, recorder.Events())
+}
+
+func TestRecorderClientInject(t *testing.T) {
+	t.Parallel()
+	recorder := createRecorderClient(t)
+
+	received := make(chan *eventstream.Event, 1)
+	err := recorder.Register(
+		eventstream.NewSubscribe().
+			Topic(testTopic).
+			EventName(testEventName).
+			GroupID("service").
+			Callback(func(ctx context.Context, event *eventstream.Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	err = recorder.Inject(testTopic, &eventstream.Event{
+		ID:        "event-1",
+		EventName: testEventName,
+		Payload:   map[string]interface{}{"userId": "user-1"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "event-1", event.ID)
+		assert.Equal(t, "user-1", event.Payload["userId"])
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "injected event wasn't delivered")
+	}
+
+	assert.Empty(t, recorder.Events(), "injected events shouldn't be recorded as published")
+}
+
+func TestRecorderClientWaitForEvent(t *testing.T) {
+	t.Parallel()
+	recorder := createRecorderClient(t)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = recorder.Publish(
+			eventstream.NewPublish().
+				Topic(testTopic).
+				EventName(testEventName))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event, err := recorder.WaitForEvent(ctx, testTopic, testEventName, nil)
+	require.NoError(t, err)
+	assert.Equal(t, testEventName, event.EventName)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = recorder.WaitForEvent(ctx, testTopic, "userDeleted", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAssertGoldenEvents(t *testing.T) {
+	t.Parallel()
+	recorder := createRecorderClient(t)
+
+	for _, userID := range []string{"user-1", "user-2"} {
+		err := recorder.PublishSync(
+			eventstream.NewPublish().
+				Topic(testTopic).
+				EventName(testEventName).
+				Namespace("accelbyte").
+				Payload(map[string]interface{}{"userId": userID}))
+		require.NoError(t, err)
+	}
+
+	AssertGoldenEvents(t, "testdata/user_created.golden.json", recorder.EventsOf(testTopic, testEventName))
+}
@@ -0,0 +1,65 @@
+/*
+* Copyright 2023 AccelByte Inc
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package kafkaprometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OutboxStats are the stats of the relay publishing the events of an outbox
+type OutboxStats struct {
+	Pending int64         // records not sent yet, as of the last poll
+	Lag     time.Duration // age of the oldest record not sent yet, as of the last poll
+
+	Sent   int64 // total number of records published and marked as sent
+	Failed int64 // total number of failed publishes
+}
+
+// OutboxStatCollector is implemented by the outbox relay
+type OutboxStatCollector interface {
+	GetOutboxStats() OutboxStats
+}
+
+// OutboxCollector implements prometheus' Collector interface, for the outbox relay.
+type OutboxCollector struct {
+	Relay OutboxStatCollector
+}
+
+var (
+	outboxPending = prometheus.NewDesc(outboxPrefix+"pending_records", "Number of records waiting in the outbox.", nil, nil)
+	outboxLag     = prometheus.NewDesc(outboxPrefix+"lag_seconds", "Age of the oldest record waiting in the outbox.", nil, nil)
+	outboxSent    = prometheus.NewDesc(outboxPrefix+"sent_records_total", "Total number of outbox records published by the relay.", nil, nil)
+	outboxFailed  = prometheus.NewDesc(outboxPrefix+"failed_publishes_total", "Total number of outbox records the relay failed to publish.", nil, nil)
+)
+
+func (o *OutboxCollector) Collect(metrics chan<- prometheus.Metric) {
+	stats := o.Relay.GetOutboxStats()
+
+	metrics <- prometheus.MustNewConstMetric(outboxPending, prometheus.GaugeValue, float64(stats.Pending))
+	metrics <- prometheus.MustNewConstMetric(outboxLag, prometheus.GaugeValue, stats.Lag.Seconds())
+	metrics <- prometheus.MustNewConstMetric(outboxSent, prometheus.CounterValue, float64(stats.Sent))
+	metrics <- prometheus.MustNewConstMetric(outboxFailed, prometheus.CounterValue, float64(stats.Failed))
+}
+
+func (o *OutboxCollector) Describe(c chan<- *prometheus.Desc) {
+	c <- outboxPending
+	c <- outboxLag
+	c <- outboxSent
+	c <- outboxFailed
+}
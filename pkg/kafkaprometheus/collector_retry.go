@@ -0,0 +1,55 @@
+/*
+* Copyright 2023 AccelByte Inc
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package kafkaprometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryCollector implements prometheus' Collector interface, for the retries of the client.
+// Every retry observes the number of the failed attempt, so the count of the histogram is the number of retries.
+type RetryCollector struct {
+	attempts *prometheus.HistogramVec
+}
+
+// NewRetryCollector creates a collector without any retry
+func NewRetryCollector() *RetryCollector {
+	return &RetryCollector{
+		attempts: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    retryPrefix + "attempts",
+			Help:    "Number of the failed attempt of the operations that are retried.",
+			Buckets: []float64{1, 2, 3, 4, 5, 8, 13, 21},
+		}, []string{"operation", "topic"}),
+	}
+}
+
+// ObserveRetry reports the retry of an operation after the failed attempt, it does nothing on a nil collector
+func (r *RetryCollector) ObserveRetry(operation, topic string, attempt int) {
+	if r == nil {
+		return
+	}
+
+	r.attempts.WithLabelValues(operation, topic).Observe(float64(attempt))
+}
+
+func (r *RetryCollector) Collect(metrics chan<- prometheus.Metric) {
+	r.attempts.Collect(metrics)
+}
+
+func (r *RetryCollector) Describe(c chan<- *prometheus.Desc) {
+	r.attempts.Describe(c)
+}
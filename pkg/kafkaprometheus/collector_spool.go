@@ -0,0 +1,77 @@
+/*
+* Copyright 2023 AccelByte Inc
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package kafkaprometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SpoolStats are the stats of the disk spool keeping the messages that couldn't be published
+type SpoolStats struct {
+	Messages  int64         // messages waiting to be forwarded
+	Bytes     int64         // size of the spool segments on disk
+	OldestAge time.Duration // age of the oldest message waiting to be forwarded
+
+	Spooled   int64 // total number of messages stored in the spool
+	Forwarded int64 // total number of messages forwarded to kafka
+	Dropped   int64 // total number of messages dropped because kafka rejected them
+	Rejected  int64 // total number of messages rejected because the spool is full
+}
+
+// SpoolStatCollector is implemented by the clients with a spool
+type SpoolStatCollector interface {
+	GetSpoolStats() SpoolStats
+}
+
+// SpoolCollector implements prometheus' Collector interface, for the disk spool.
+type SpoolCollector struct {
+	Client SpoolStatCollector
+}
+
+var (
+	spoolMessages  = prometheus.NewDesc(spoolPrefix+"messages", "Number of messages waiting in the spool to be forwarded.", nil, nil)
+	spoolBytes     = prometheus.NewDesc(spoolPrefix+"bytes", "Size of the spool segments on disk.", nil, nil)
+	spoolOldestAge = prometheus.NewDesc(spoolPrefix+"oldest_message_age_seconds", "Age of the oldest message waiting in the spool.", nil, nil)
+	spoolSpooled   = prometheus.NewDesc(spoolPrefix+"spooled_messages_total", "Total number of messages stored in the spool.", nil, nil)
+	spoolForwarded = prometheus.NewDesc(spoolPrefix+"forwarded_messages_total", "Total number of spooled messages forwarded to kafka.", nil, nil)
+	spoolDropped   = prometheus.NewDesc(spoolPrefix+"dropped_messages_total", "Total number of spooled messages dropped because kafka rejected them.", nil, nil)
+	spoolRejected  = prometheus.NewDesc(spoolPrefix+"rejected_messages_total", "Total number of messages rejected because the spool is full.", nil, nil)
+)
+
+func (s *SpoolCollector) Collect(metrics chan<- prometheus.Metric) {
+	stats := s.Client.GetSpoolStats()
+
+	metrics <- prometheus.MustNewConstMetric(spoolMessages, prometheus.GaugeValue, float64(stats.Messages))
+	metrics <- prometheus.MustNewConstMetric(spoolBytes, prometheus.GaugeValue, float64(stats.Bytes))
+	metrics <- prometheus.MustNewConstMetric(spoolOldestAge, prometheus.GaugeValue, stats.OldestAge.Seconds())
+	metrics <- prometheus.MustNewConstMetric(spoolSpooled, prometheus.CounterValue, float64(stats.Spooled))
+	metrics <- prometheus.MustNewConstMetric(spoolForwarded, prometheus.CounterValue, float64(stats.Forwarded))
+	metrics <- prometheus.MustNewConstMetric(spoolDropped, prometheus.CounterValue, float64(stats.Dropped))
+	metrics <- prometheus.MustNewConstMetric(spoolRejected, prometheus.CounterValue, float64(stats.Rejected))
+}
+
+func (s *SpoolCollector) Describe(c chan<- *prometheus.Desc) {
+	c <- spoolMessages
+	c <- spoolBytes
+	c <- spoolOldestAge
+	c <- spoolSpooled
+	c <- spoolForwarded
+	c <- spoolDropped
+	c <- spoolRejected
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkaprometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SubscriberStats are the stats of a subscriber
+type SubscriberStats struct {
+	Slug   string // topic, event name and group ID of the subscriber, see SplitSlug
+	Paused bool   // the subscriber doesn't fetch events
+}
+
+// SubscriberStatCollector is implemented by the clients with subscribers
+type SubscriberStatCollector interface {
+	GetSubscriberStats() []SubscriberStats
+}
+
+// SubscriberCollector implements prometheus' Collector interface, for the subscribers.
+type SubscriberCollector struct {
+	Client SubscriberStatCollector
+}
+
+var (
+	subscriberPaused = prometheus.NewDesc(subscriberPrefix+"paused", "Whether the subscriber is paused (1) or fetching events (0).", []string{"topic", "event"}, nil)
+)
+
+func (s *SubscriberCollector) Collect(metrics chan<- prometheus.Metric) {
+	// like the readers, one metric per topic + eventName, it's paused if any of its subscribers is
+	paused := make(map[[2]string]bool)
+	for _, stats := range s.Client.GetSubscriberStats() {
+		topic, eventName, _ := SplitSlug(stats.Slug)
+		if topic == "" || eventName == "" {
+			continue
+		}
+
+		key := [2]string{topic, eventName}
+		paused[key] = paused[key] || stats.Paused
+	}
+
+	for key, isPaused := range paused {
+		value := 0.0
+		if isPaused {
+			value = 1
+		}
+
+		metrics <- prometheus.MustNewConstMetric(subscriberPaused, prometheus.GaugeValue, value, key[0], key[1])
+	}
+}
+
+func (s *SubscriberCollector) Describe(c chan<- *prometheus.Desc) {
+	c <- subscriberPaused
+}
@@ -28,8 +28,12 @@ import (
 )
 
 const (
-	writerPrefix = "ab_eventstream_kafka_writer_"
-	readerPrefix = "ab_eventstream_kafka_reader_"
+	writerPrefix     = "ab_eventstream_kafka_writer_"
+	readerPrefix     = "ab_eventstream_kafka_reader_"
+	spoolPrefix      = "ab_eventstream_spool_"
+	outboxPrefix     = "ab_eventstream_outbox_"
+	retryPrefix      = "ab_eventstream_retry_"
+	subscriberPrefix = "ab_eventstream_subscriber_"
 )
 
 const SlugSeparator = "$" // SlugSeparator is excluded by topicRegex.
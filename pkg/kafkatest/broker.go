@@ -0,0 +1,398 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kafkatest provides an in-process kafka broker speaking enough of the kafka wire protocol for the
+// segmentio/kafka-go Writer and Reader, so the kafka client can be tested without a real cluster.
+package kafkatest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/fetch"
+	"github.com/segmentio/kafka-go/protocol/findcoordinator"
+	"github.com/segmentio/kafka-go/protocol/heartbeat"
+	"github.com/segmentio/kafka-go/protocol/joingroup"
+	"github.com/segmentio/kafka-go/protocol/leavegroup"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/offsetcommit"
+	"github.com/segmentio/kafka-go/protocol/offsetfetch"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/segmentio/kafka-go/protocol/syncgroup"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nodeID             = 1
+	clusterID          = "kafkatest"
+	defaultPartitions  = 1
+	groupCheckInterval = 50 * time.Millisecond
+)
+
+// supportedVersions are the API versions advertised to the clients. Flexible versions aren't supported.
+var supportedVersions = []apiversions.ApiKeyResponse{
+	{ApiKey: int16(protocol.Produce), MinVersion: 0, MaxVersion: 8},
+	{ApiKey: int16(protocol.Fetch), MinVersion: 4, MaxVersion: 10},
+	{ApiKey: int16(protocol.ListOffsets), MinVersion: 1, MaxVersion: 5},
+	{ApiKey: int16(protocol.Metadata), MinVersion: 0, MaxVersion: 8},
+	{ApiKey: int16(protocol.OffsetCommit), MinVersion: 2, MaxVersion: 7},
+	{ApiKey: int16(protocol.OffsetFetch), MinVersion: 1, MaxVersion: 5},
+	{ApiKey: int16(protocol.FindCoordinator), MinVersion: 0, MaxVersion: 2},
+	{ApiKey: int16(protocol.JoinGroup), MinVersion: 0, MaxVersion: 5},
+	{ApiKey: int16(protocol.Heartbeat), MinVersion: 0, MaxVersion: 3},
+	{ApiKey: int16(protocol.LeaveGroup), MinVersion: 0, MaxVersion: 2},
+	{ApiKey: int16(protocol.SyncGroup), MinVersion: 0, MaxVersion: 3},
+	{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+}
+
+var errBrokerClosed = errors.New("kafkatest broker is closed")
+
+// BrokerConfig is the configuration of the test broker
+type BrokerConfig struct {
+	// Partitions is the partition count of the auto created topics. default: 1
+	Partitions int
+
+	// DisableAutoCreateTopics rejects metadata requests of unknown topics instead of creating them
+	DisableAutoCreateTopics bool
+}
+
+// Broker is a single node kafka cluster listening on a local port.
+// Topics and committed offsets are kept in memory.
+type Broker struct {
+	config   BrokerConfig
+	listener net.Listener
+	host     string
+	port     int32
+
+	lock    sync.Mutex
+	topics  map[string]*topic
+	groups  map[string]*group
+	updated chan struct{}
+	closed  bool
+	conns   map[net.Conn]struct{}
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewBroker starts a test broker on a random local port. It panics if the port can't be opened.
+func NewBroker(config ...*BrokerConfig) *Broker {
+	var brokerConfig BrokerConfig
+	if len(config) > 0 && config[0] != nil {
+		brokerConfig = *config[0]
+	}
+
+	if brokerConfig.Partitions <= 0 {
+		brokerConfig.Partitions = defaultPartitions
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("kafkatest: unable to listen on a port: %v", err))
+	}
+
+	addr := listener.Addr().(*net.TCPAddr)
+
+	broker := &Broker{
+		config:   brokerConfig,
+		listener: listener,
+		host:     addr.IP.String(),
+		port:     int32(addr.Port),
+		topics:   make(map[string]*topic),
+		groups:   make(map[string]*group),
+		updated:  make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	broker.wg.Add(2)
+	go broker.serve()
+	go broker.checkGroups()
+
+	return broker
+}
+
+// Addrs returns the broker addresses to pass to the kafka client
+func (broker *Broker) Addrs() []string {
+	return []string{net.JoinHostPort(broker.host, strconv.Itoa(int(broker.port)))}
+}
+
+// Close stops the broker and closes the client connections
+func (broker *Broker) Close() error {
+	broker.lock.Lock()
+	if broker.closed {
+		broker.lock.Unlock()
+		return nil
+	}
+
+	broker.closed = true
+	close(broker.done)
+	err := broker.listener.Close()
+
+	for conn := range broker.conns {
+		_ = conn.Close()
+	}
+	broker.lock.Unlock()
+
+	broker.wg.Wait()
+
+	return err
+}
+
+// serve accepts the client connections
+func (broker *Broker) serve() {
+	defer broker.wg.Done()
+
+	for {
+		conn, err := broker.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		broker.lock.Lock()
+		if broker.closed {
+			broker.lock.Unlock()
+			_ = conn.Close()
+
+			return
+		}
+		broker.conns[conn] = struct{}{}
+		broker.wg.Add(1)
+		broker.lock.Unlock()
+
+		go broker.handleConn(conn)
+	}
+}
+
+// handleConn serves the requests of a connection one at a time, like a kafka broker does
+func (broker *Broker) handleConn(conn net.Conn) {
+	defer broker.wg.Done()
+	defer func() {
+		broker.lock.Lock()
+		delete(broker.conns, conn)
+		broker.lock.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	for {
+		apiVersion, correlationID, clientID, request, err := protocol.ReadRequest(reader)
+		if err != nil {
+			// the clients close their connections without notice
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !broker.isClosed() {
+				logrus.Warn("kafkatest: unable to read request: ", err)
+			}
+
+			return
+		}
+
+		if err = broker.handleRequest(writer, apiVersion, correlationID, clientID, request); err != nil {
+			if !broker.isClosed() {
+				logrus.Warn("kafkatest: unable to write response: ", err)
+			}
+
+			return
+		}
+
+		if err = writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// handleRequest writes the response of the request
+func (broker *Broker) handleRequest(w io.Writer, apiVersion int16, correlationID int32, clientID string,
+	request protocol.Message) error {
+	var response protocol.Message
+
+	switch request := request.(type) {
+	case *apiversions.Request:
+		response = &apiversions.Response{ApiKeys: supportedVersions}
+	case *metadata.Request:
+		response = broker.metadata(apiVersion, request)
+	case *produce.Request:
+		response = broker.produce(request)
+		if request.Acks == 0 {
+			return nil
+		}
+	case *fetch.Request:
+		// fetch responses are encoded by hand to set the offsets of the record batches
+		return broker.fetch(w, apiVersion, correlationID, request)
+	case *listoffsets.Request:
+		response = broker.listOffsets(request)
+	case *findcoordinator.Request:
+		response = &findcoordinator.Response{NodeID: nodeID, Host: broker.host, Port: broker.port}
+	case *joingroup.Request:
+		response = broker.joinGroup(clientID, request)
+	case *syncgroup.Request:
+		response = broker.syncGroup(request)
+	case *heartbeat.Request:
+		response = broker.heartbeat(request)
+	case *leavegroup.Request:
+		response = broker.leaveGroup(request)
+	case *offsetcommit.Request:
+		response = broker.offsetCommit(request)
+	case *offsetfetch.Request:
+		response = broker.offsetFetch(request)
+	default:
+		return fmt.Errorf("unsupported api: %s", request.ApiKey())
+	}
+
+	return protocol.WriteResponse(w, apiVersion, correlationID, response)
+}
+
+// metadata returns the broker and the requested topics, creating the unknown ones if allowed
+func (broker *Broker) metadata(apiVersion int16, request *metadata.Request) *metadata.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	names := request.TopicNames
+	if names == nil {
+		for name := range broker.topics {
+			names = append(names, name)
+		}
+	}
+
+	autoCreate := !broker.config.DisableAutoCreateTopics && (apiVersion < 4 || request.AllowAutoTopicCreation)
+
+	response := &metadata.Response{
+		Brokers:      []metadata.ResponseBroker{{NodeID: nodeID, Host: broker.host, Port: broker.port}},
+		ClusterID:    clusterID,
+		ControllerID: nodeID,
+	}
+
+	for _, name := range names {
+		t, ok := broker.topics[name]
+		if !ok && autoCreate {
+			t = broker.createTopic(name, broker.config.Partitions)
+			ok = true
+		}
+
+		if !ok {
+			response.Topics = append(response.Topics, metadata.ResponseTopic{
+				Name:      name,
+				ErrorCode: int16(kafka.UnknownTopicOrPartition),
+			})
+
+			continue
+		}
+
+		responseTopic := metadata.ResponseTopic{Name: name}
+		for i := range t.partitions {
+			responseTopic.Partitions = append(responseTopic.Partitions, metadata.ResponsePartition{
+				PartitionIndex: int32(i),
+				LeaderID:       nodeID,
+				ReplicaNodes:   []int32{nodeID},
+				IsrNodes:       []int32{nodeID},
+			})
+		}
+		response.Topics = append(response.Topics, responseTopic)
+	}
+
+	return response
+}
+
+// CreateTopic creates a topic with the given partition count
+func (broker *Broker) CreateTopic(name string, partitions int) error {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	if broker.closed {
+		return errBrokerClosed
+	}
+
+	if _, ok := broker.topics[name]; ok {
+		return kafka.TopicAlreadyExists
+	}
+
+	if partitions <= 0 {
+		return kafka.InvalidPartitionNumber
+	}
+
+	broker.createTopic(name, partitions)
+
+	return nil
+}
+
+// createTopic adds an empty topic, broker.lock must be held
+func (broker *Broker) createTopic(name string, partitions int) *topic {
+	t := &topic{partitions: make([][]record, partitions)}
+	broker.topics[name] = t
+
+	return t
+}
+
+// Messages returns the messages of a topic partition
+func (broker *Broker) Messages(topicName string, partition int) []kafka.Message {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	t, ok := broker.topics[topicName]
+	if !ok || partition < 0 || partition >= len(t.partitions) {
+		return nil
+	}
+
+	messages := make([]kafka.Message, 0, len(t.partitions[partition]))
+	for _, r := range t.partitions[partition] {
+		messages = append(messages, r.message(topicName, partition))
+	}
+
+	return messages
+}
+
+// CommittedOffset returns the offset committed by a consumer group, or -1 if there is none
+func (broker *Broker) CommittedOffset(groupID, topicName string, partition int) int64 {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	g, ok := broker.groups[groupID]
+	if !ok {
+		return -1
+	}
+
+	offset, ok := g.offsets[topicPartition{topic: topicName, partition: int32(partition)}]
+	if !ok {
+		return -1
+	}
+
+	return offset
+}
+
+func (broker *Broker) isClosed() bool {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	return broker.closed
+}
+
+// notify wakes up the pending fetches, broker.lock must be held
+func (broker *Broker) notify() {
+	close(broker.updated)
+	broker.updated = make(chan struct{})
+}
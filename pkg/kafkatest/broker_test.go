@@ -0,0 +1,246 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTimeout = 30 * time.Second
+
+func createBroker(t *testing.T, config ...*BrokerConfig) *Broker {
+	t.Helper()
+
+	broker := NewBroker(config...)
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+
+	return broker
+}
+
+func writeMessages(t *testing.T, broker *Broker, topic string, count int) {
+	t.Helper()
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(broker.Addrs()...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	messages := make([]kafka.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messages = append(messages, kafka.Message{
+			Key:     []byte(fmt.Sprintf("key-%d", i)),
+			Value:   []byte(fmt.Sprintf("value-%d", i)),
+			Headers: []kafka.Header{{Key: "index", Value: []byte(fmt.Sprint(i))}},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	require.NoError(t, writer.WriteMessages(ctx, messages...))
+}
+
+func TestBrokerProduceFetch(t *testing.T) {
+	t.Parallel()
+	broker := createBroker(t)
+	require.NoError(t, broker.CreateTopic("produce-fetch", 1))
+
+	writeMessages(t, broker, "produce-fetch", 5)
+
+	messages := broker.Messages("produce-fetch", 0)
+	require.Len(t, messages, 5)
+	assert.Equal(t, int64(4), messages[4].Offset)
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   broker.Addrs(),
+		Topic:     "produce-fetch",
+		Partition: 0,
+		MaxWait:   100 * time.Millisecond,
+	})
+	defer reader.Close()
+
+	require.NoError(t, reader.SetOffset(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for i := 2; i < 5; i++ {
+		message, err := reader.ReadMessage(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), message.Offset)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(message.Value))
+		assert.Equal(t, []kafka.Header{{Key: "index", Value: []byte(fmt.Sprint(i))}}, message.Headers)
+	}
+}
+
+func TestBrokerAutoCreateTopic(t *testing.T) {
+	t.Parallel()
+	broker := createBroker(t, &BrokerConfig{Partitions: 3})
+
+	conn, err := kafka.DialLeader(context.Background(), "tcp", broker.Addrs()[0], "auto-created", 2)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions("auto-created")
+	require.NoError(t, err)
+	assert.Len(t, partitions, 3)
+
+	first, last, err := conn.ReadOffsets()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), first)
+	assert.Equal(t, int64(0), last)
+
+	broker = createBroker(t, &BrokerConfig{DisableAutoCreateTopics: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = kafka.DialLeader(ctx, "tcp", broker.Addrs()[0], "unknown", 0)
+	assert.Error(t, err)
+}
+
+func TestBrokerConsumerGroupCommit(t *testing.T) {
+	t.Parallel()
+	broker := createBroker(t)
+	require.NoError(t, broker.CreateTopic("group-commit", 1))
+
+	writeMessages(t, broker, "group-commit", 3)
+
+	newReader := func() *kafka.Reader {
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     broker.Addrs(),
+			GroupID:     "group",
+			Topic:       "group-commit",
+			StartOffset: kafka.FirstOffset,
+			MaxWait:     100 * time.Millisecond,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	reader := newReader()
+	message, err := reader.FetchMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), message.Offset)
+	require.NoError(t, reader.CommitMessages(ctx, message))
+	require.NoError(t, reader.Close())
+
+	assert.Equal(t, int64(1), broker.CommittedOffset("group", "group-commit", 0))
+
+	// a new member continues from the committed offset
+	reader = newReader()
+	defer reader.Close()
+
+	message, err = reader.FetchMessage(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), message.Offset)
+}
+
+func TestBrokerConsumerGroupRebalance(t *testing.T) {
+	t.Parallel()
+	broker := createBroker(t)
+	require.NoError(t, broker.CreateTopic("group-rebalance", 4))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	type delivery struct {
+		reader  int
+		message kafka.Message
+	}
+	deliveries := make(chan delivery, 100)
+
+	consume := func(index int) {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:           broker.Addrs(),
+			GroupID:           "group",
+			Topic:             "group-rebalance",
+			StartOffset:       kafka.FirstOffset,
+			MaxWait:           100 * time.Millisecond,
+			HeartbeatInterval: 100 * time.Millisecond,
+		})
+		t.Cleanup(func() {
+			_ = reader.Close()
+		})
+
+		go func() {
+			for {
+				message, err := reader.FetchMessage(ctx)
+				if err != nil {
+					return
+				}
+				_ = reader.CommitMessages(ctx, message)
+				deliveries <- delivery{reader: index, message: message}
+			}
+		}()
+	}
+
+	type position struct {
+		partition int
+		offset    int64
+	}
+	received := make(map[position]bool)
+	counts := make(map[int]int)
+
+	receive := func(count int) {
+		for i := 0; i < count; i++ {
+			select {
+			case d := <-deliveries:
+				received[position{d.message.Partition, d.message.Offset}] = true
+				counts[d.reader]++
+			case <-ctx.Done():
+				require.FailNow(t, "timeout while consuming messages", "received %v", counts)
+			}
+		}
+	}
+
+	// the first member owns every partition
+	consume(0)
+	writeMessages(t, broker, "group-rebalance", 10)
+	receive(10)
+
+	// the second member joins, the partitions are shared after the rebalance
+	consume(1)
+	for counts[1] == 0 {
+		writeMessages(t, broker, "group-rebalance", 10)
+		receive(10)
+	}
+
+	assert.NotZero(t, counts[0])
+
+	// every message is consumed at least once
+	for partition := 0; partition < 4; partition++ {
+		for _, message := range broker.Messages("group-rebalance", partition) {
+			for !received[position{partition, message.Offset}] {
+				receive(1)
+			}
+		}
+	}
+}
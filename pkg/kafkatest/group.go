@@ -0,0 +1,530 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkatest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol/heartbeat"
+	"github.com/segmentio/kafka-go/protocol/joingroup"
+	"github.com/segmentio/kafka-go/protocol/leavegroup"
+	"github.com/segmentio/kafka-go/protocol/offsetcommit"
+	"github.com/segmentio/kafka-go/protocol/offsetfetch"
+	"github.com/segmentio/kafka-go/protocol/syncgroup"
+)
+
+// groupState follows the states of the kafka group coordinator
+type groupState int
+
+const (
+	groupEmpty groupState = iota
+	groupPreparingRebalance
+	groupCompletingRebalance
+	groupStable
+)
+
+// group is a consumer group, its members and committed offsets
+type group struct {
+	state             groupState
+	generation        int32
+	protocol          string
+	leader            string
+	members           map[string]*member
+	rebalanceDeadline time.Time
+	assignments       map[string][]byte
+	pendingSyncs      map[string]chan *syncgroup.Response
+	offsets           map[topicPartition]int64
+	memberCount       int
+}
+
+// member is a consumer group member
+type member struct {
+	protocols        []joingroup.RequestProtocol
+	sessionTimeout   time.Duration
+	rebalanceTimeout time.Duration
+	lastSeen         time.Time
+
+	// joining receives the join response, it's set while the member waits for the rebalance to complete
+	joining chan *joingroup.Response
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// getGroup returns the group, creating it if needed. broker.lock must be held
+func (broker *Broker) getGroup(groupID string) *group {
+	g, ok := broker.groups[groupID]
+	if !ok {
+		g = &group{
+			members:      make(map[string]*member),
+			pendingSyncs: make(map[string]chan *syncgroup.Response),
+			offsets:      make(map[topicPartition]int64),
+		}
+		broker.groups[groupID] = g
+	}
+
+	return g
+}
+
+// joinGroup adds the member to the group and waits until every member rejoined or the rebalance timed out
+func (broker *Broker) joinGroup(clientID string, request *joingroup.Request) *joingroup.Response {
+	broker.lock.Lock()
+
+	g := broker.getGroup(request.GroupID)
+	memberID := request.MemberID
+
+	m, ok := g.members[memberID]
+	if memberID != "" && !ok {
+		broker.lock.Unlock()
+		return &joingroup.Response{ErrorCode: int16(kafka.UnknownMemberId), GenerationID: -1}
+	}
+
+	if !ok {
+		g.memberCount++
+		memberID = fmt.Sprintf("%s-%d", clientID, g.memberCount)
+		m = &member{}
+		g.members[memberID] = m
+	}
+
+	if m.joining != nil {
+		m.joining <- &joingroup.Response{ErrorCode: int16(kafka.RebalanceInProgress), GenerationID: -1}
+	}
+
+	joining := make(chan *joingroup.Response, 1)
+
+	m.protocols = request.Protocols
+	m.sessionTimeout = time.Duration(request.SessionTimeoutMS) * time.Millisecond
+	m.rebalanceTimeout = time.Duration(request.RebalanceTimeoutMS) * time.Millisecond
+	if m.rebalanceTimeout <= 0 {
+		m.rebalanceTimeout = m.sessionTimeout
+	}
+	m.lastSeen = time.Now()
+	m.joining = joining
+
+	g.prepareRebalance()
+	g.maybeCompleteJoin(memberID)
+
+	broker.lock.Unlock()
+
+	select {
+	case response := <-joining:
+		return response
+	case <-broker.done:
+		return &joingroup.Response{ErrorCode: int16(kafka.GroupCoordinatorNotAvailable), GenerationID: -1}
+	}
+}
+
+// prepareRebalance starts a rebalance, the members learn about it from the heartbeat responses
+func (g *group) prepareRebalance() {
+	if g.state == groupPreparingRebalance {
+		return
+	}
+
+	g.state = groupPreparingRebalance
+	g.rebalanceDeadline = time.Now()
+
+	for _, m := range g.members {
+		if deadline := m.lastSeen.Add(m.rebalanceTimeout); deadline.After(g.rebalanceDeadline) {
+			g.rebalanceDeadline = deadline
+		}
+	}
+
+	for memberID, pendingSync := range g.pendingSyncs {
+		pendingSync <- &syncgroup.Response{ErrorCode: int16(kafka.RebalanceInProgress)}
+		delete(g.pendingSyncs, memberID)
+	}
+}
+
+// maybeCompleteJoin completes the join once every member rejoined, preferredLeader is used if there is no leader
+func (g *group) maybeCompleteJoin(preferredLeader string) {
+	if g.state != groupPreparingRebalance {
+		return
+	}
+
+	for _, m := range g.members {
+		if m.joining == nil {
+			return
+		}
+	}
+
+	g.completeJoin(preferredLeader)
+}
+
+// completeJoin removes the members that didn't rejoin and starts a new generation
+func (g *group) completeJoin(preferredLeader string) {
+	for memberID, m := range g.members {
+		if m.joining == nil {
+			delete(g.members, memberID)
+		}
+	}
+
+	g.generation++
+	g.assignments = nil
+
+	if len(g.members) == 0 {
+		g.state = groupEmpty
+		g.leader = ""
+
+		return
+	}
+
+	memberIDs := make([]string, 0, len(g.members))
+	for memberID := range g.members {
+		memberIDs = append(memberIDs, memberID)
+	}
+	sort.Strings(memberIDs)
+
+	if _, ok := g.members[g.leader]; !ok {
+		g.leader = memberIDs[0]
+		if _, ok := g.members[preferredLeader]; ok {
+			g.leader = preferredLeader
+		}
+	}
+
+	g.protocol = g.selectProtocol(memberIDs)
+	g.state = groupCompletingRebalance
+
+	var members []joingroup.ResponseMember
+	for _, memberID := range memberIDs {
+		members = append(members, joingroup.ResponseMember{
+			MemberID: memberID,
+			Metadata: g.members[memberID].metadata(g.protocol),
+		})
+	}
+
+	now := time.Now()
+
+	for _, memberID := range memberIDs {
+		m := g.members[memberID]
+
+		response := &joingroup.Response{
+			GenerationID: g.generation,
+			ProtocolName: g.protocol,
+			LeaderID:     g.leader,
+			MemberID:     memberID,
+		}
+		if memberID == g.leader {
+			response.Members = members
+		}
+
+		m.joining <- response
+		m.joining = nil
+		m.lastSeen = now
+	}
+}
+
+// selectProtocol returns the first protocol of the leader supported by every member
+func (g *group) selectProtocol(memberIDs []string) string {
+	leader := g.members[g.leader]
+
+	for _, protocol := range leader.protocols {
+		supported := true
+
+		for _, memberID := range memberIDs {
+			if g.members[memberID].metadata(protocol.Name) == nil {
+				supported = false
+				break
+			}
+		}
+
+		if supported {
+			return protocol.Name
+		}
+	}
+
+	if len(leader.protocols) > 0 {
+		return leader.protocols[0].Name
+	}
+
+	return ""
+}
+
+// metadata returns the member metadata of the protocol, or nil if it's not supported
+func (m *member) metadata(protocol string) []byte {
+	for _, p := range m.protocols {
+		if p.Name == protocol {
+			if p.Metadata == nil {
+				return []byte{}
+			}
+
+			return p.Metadata
+		}
+	}
+
+	return nil
+}
+
+// syncGroup stores the leader assignments and returns the member assignment once the leader synced
+func (broker *Broker) syncGroup(request *syncgroup.Request) *syncgroup.Response {
+	broker.lock.Lock()
+
+	g, ok := broker.groups[request.GroupID]
+	if !ok || g.members[request.MemberID] == nil {
+		broker.lock.Unlock()
+		return &syncgroup.Response{ErrorCode: int16(kafka.UnknownMemberId)}
+	}
+
+	if request.GenerationID != g.generation {
+		broker.lock.Unlock()
+		return &syncgroup.Response{ErrorCode: int16(kafka.IllegalGeneration)}
+	}
+
+	g.members[request.MemberID].lastSeen = time.Now()
+
+	switch g.state {
+	case groupStable:
+		broker.lock.Unlock()
+		return &syncgroup.Response{Assignments: g.assignment(request.MemberID)}
+	case groupCompletingRebalance:
+	default:
+		broker.lock.Unlock()
+		return &syncgroup.Response{ErrorCode: int16(kafka.RebalanceInProgress)}
+	}
+
+	if request.MemberID == g.leader {
+		g.assignments = make(map[string][]byte, len(request.Assignments))
+		for _, assignment := range request.Assignments {
+			g.assignments[assignment.MemberID] = assignment.Assignment
+		}
+		g.state = groupStable
+
+		for memberID, pendingSync := range g.pendingSyncs {
+			pendingSync <- &syncgroup.Response{Assignments: g.assignment(memberID)}
+			delete(g.pendingSyncs, memberID)
+		}
+
+		response := &syncgroup.Response{Assignments: g.assignment(request.MemberID)}
+		broker.lock.Unlock()
+
+		return response
+	}
+
+	pendingSync := make(chan *syncgroup.Response, 1)
+	g.pendingSyncs[request.MemberID] = pendingSync
+
+	broker.lock.Unlock()
+
+	select {
+	case response := <-pendingSync:
+		return response
+	case <-broker.done:
+		return &syncgroup.Response{ErrorCode: int16(kafka.GroupCoordinatorNotAvailable)}
+	}
+}
+
+// assignment returns the assignment of the member in the current generation
+func (g *group) assignment(memberID string) []byte {
+	if assignment, ok := g.assignments[memberID]; ok && assignment != nil {
+		return assignment
+	}
+
+	return []byte{}
+}
+
+// heartbeat keeps the member alive and tells it when a rebalance started
+func (broker *Broker) heartbeat(request *heartbeat.Request) *heartbeat.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	g, ok := broker.groups[request.GroupID]
+	if !ok || g.members[request.MemberID] == nil {
+		return &heartbeat.Response{ErrorCode: int16(kafka.UnknownMemberId)}
+	}
+
+	g.members[request.MemberID].lastSeen = time.Now()
+
+	if g.state == groupPreparingRebalance {
+		return &heartbeat.Response{ErrorCode: int16(kafka.RebalanceInProgress)}
+	}
+
+	if request.GenerationID != g.generation {
+		return &heartbeat.Response{ErrorCode: int16(kafka.IllegalGeneration)}
+	}
+
+	return &heartbeat.Response{}
+}
+
+// leaveGroup removes the member and rebalances the remaining members
+func (broker *Broker) leaveGroup(request *leavegroup.Request) *leavegroup.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	g, ok := broker.groups[request.GroupID]
+	if !ok || g.members[request.MemberID] == nil {
+		return &leavegroup.Response{ErrorCode: int16(kafka.UnknownMemberId)}
+	}
+
+	g.removeMember(request.MemberID)
+
+	return &leavegroup.Response{}
+}
+
+// removeMember removes the member from the group and triggers a rebalance
+func (g *group) removeMember(memberID string) {
+	m := g.members[memberID]
+	if m.joining != nil {
+		m.joining <- &joingroup.Response{ErrorCode: int16(kafka.UnknownMemberId), GenerationID: -1}
+	}
+
+	if pendingSync, ok := g.pendingSyncs[memberID]; ok {
+		pendingSync <- &syncgroup.Response{ErrorCode: int16(kafka.UnknownMemberId)}
+		delete(g.pendingSyncs, memberID)
+	}
+
+	delete(g.members, memberID)
+	if g.leader == memberID {
+		g.leader = ""
+	}
+
+	if len(g.members) == 0 {
+		g.state = groupEmpty
+		g.generation++
+
+		return
+	}
+
+	g.prepareRebalance()
+	g.maybeCompleteJoin("")
+}
+
+// checkGroups expires the members that stopped sending heartbeats and completes the timed out rebalances
+func (broker *Broker) checkGroups() {
+	defer broker.wg.Done()
+
+	ticker := time.NewTicker(groupCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-broker.done:
+			return
+		case <-ticker.C:
+		}
+
+		broker.lock.Lock()
+
+		now := time.Now()
+		for _, g := range broker.groups {
+			for memberID, m := range g.members {
+				if m.joining == nil && now.Sub(m.lastSeen) > m.sessionTimeout {
+					g.removeMember(memberID)
+				}
+			}
+
+			if g.state == groupPreparingRebalance && now.After(g.rebalanceDeadline) {
+				g.completeJoin("")
+			}
+		}
+
+		broker.lock.Unlock()
+	}
+}
+
+// offsetCommit stores the offsets committed by a member of the current generation
+func (broker *Broker) offsetCommit(request *offsetcommit.Request) *offsetcommit.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	g := broker.getGroup(request.GroupID)
+
+	var errorCode int16
+
+	// generation -1 is used by consumers committing outside of the group membership
+	if request.GenerationID >= 0 || request.MemberID != "" {
+		m, ok := g.members[request.MemberID]
+
+		switch {
+		case !ok:
+			errorCode = int16(kafka.UnknownMemberId)
+		case request.GenerationID != g.generation:
+			errorCode = int16(kafka.IllegalGeneration)
+		case g.state == groupCompletingRebalance:
+			errorCode = int16(kafka.RebalanceInProgress)
+		default:
+			m.lastSeen = time.Now()
+		}
+	}
+
+	response := &offsetcommit.Response{}
+
+	for _, requestTopic := range request.Topics {
+		responseTopic := offsetcommit.ResponseTopic{Name: requestTopic.Name}
+
+		for _, requestPartition := range requestTopic.Partitions {
+			if errorCode == 0 {
+				g.offsets[topicPartition{topic: requestTopic.Name, partition: requestPartition.PartitionIndex}] =
+					requestPartition.CommittedOffset
+			}
+
+			responseTopic.Partitions = append(responseTopic.Partitions, offsetcommit.ResponsePartition{
+				PartitionIndex: requestPartition.PartitionIndex,
+				ErrorCode:      errorCode,
+			})
+		}
+
+		response.Topics = append(response.Topics, responseTopic)
+	}
+
+	return response
+}
+
+// offsetFetch returns the committed offsets, -1 if the partition has no committed offset
+func (broker *Broker) offsetFetch(request *offsetfetch.Request) *offsetfetch.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	g := broker.getGroup(request.GroupID)
+
+	requestTopics := request.Topics
+	if requestTopics == nil {
+		partitions := make(map[string][]int32)
+		for tp := range g.offsets {
+			partitions[tp.topic] = append(partitions[tp.topic], tp.partition)
+		}
+
+		for name, indexes := range partitions {
+			requestTopics = append(requestTopics, offsetfetch.RequestTopic{Name: name, PartitionIndexes: indexes})
+		}
+	}
+
+	response := &offsetfetch.Response{}
+
+	for _, requestTopic := range requestTopics {
+		responseTopic := offsetfetch.ResponseTopic{Name: requestTopic.Name}
+
+		for _, partition := range requestTopic.PartitionIndexes {
+			offset, ok := g.offsets[topicPartition{topic: requestTopic.Name, partition: partition}]
+			if !ok {
+				offset = -1
+			}
+
+			responseTopic.Partitions = append(responseTopic.Partitions, offsetfetch.ResponsePartition{
+				PartitionIndex:  partition,
+				CommittedOffset: offset,
+			})
+		}
+
+		response.Topics = append(response.Topics, responseTopic)
+	}
+
+	return response
+}
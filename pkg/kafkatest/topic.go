@@ -0,0 +1,388 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkatest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/fetch"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// topic keeps the records of every partition, the offset of a record is its index
+type topic struct {
+	partitions [][]record
+}
+
+// record is a stored message
+type record struct {
+	offset  int64
+	time    time.Time
+	key     []byte
+	value   []byte
+	headers []protocol.Header
+}
+
+// message converts the record to a kafka message
+func (r record) message(topicName string, partition int) kafka.Message {
+	headers := make([]kafka.Header, 0, len(r.headers))
+	for _, header := range r.headers {
+		headers = append(headers, kafka.Header{Key: header.Key, Value: header.Value})
+	}
+
+	return kafka.Message{
+		Topic:     topicName,
+		Partition: partition,
+		Offset:    r.offset,
+		Key:       r.key,
+		Value:     r.value,
+		Headers:   headers,
+		Time:      r.time,
+	}
+}
+
+// size returns the approximate encoded size of the record
+func (r record) size() int {
+	size := len(r.key) + len(r.value)
+	for _, header := range r.headers {
+		size += len(header.Key) + len(header.Value)
+	}
+
+	return size
+}
+
+// produce appends the records to the partitions
+func (broker *Broker) produce(request *produce.Request) *produce.Response {
+	response := &produce.Response{}
+
+	for _, requestTopic := range request.Topics {
+		responseTopic := produce.ResponseTopic{Topic: requestTopic.Topic}
+
+		for _, requestPartition := range requestTopic.Partitions {
+			records, err := readRecords(requestPartition.RecordSet.Records)
+
+			responsePartition := produce.ResponsePartition{Partition: requestPartition.Partition, BaseOffset: -1}
+			if err != nil {
+				responsePartition.ErrorCode = int16(kafka.InvalidMessage)
+			} else {
+				responsePartition.BaseOffset, responsePartition.ErrorCode = broker.append(
+					requestTopic.Topic, requestPartition.Partition, records)
+			}
+
+			responseTopic.Partitions = append(responseTopic.Partitions, responsePartition)
+		}
+
+		response.Topics = append(response.Topics, responseTopic)
+	}
+
+	return response
+}
+
+// readRecords copies the records of a produce request
+func readRecords(reader protocol.RecordReader) ([]record, error) {
+	var records []record
+
+	if reader == nil {
+		return records, nil
+	}
+
+	for {
+		r, err := reader.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := protocol.ReadAll(r.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := protocol.ReadAll(r.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		headers := make([]protocol.Header, len(r.Headers))
+		copy(headers, r.Headers)
+
+		records = append(records, record{time: r.Time, key: key, value: value, headers: headers})
+	}
+}
+
+// append stores the records and returns the offset of the first one
+func (broker *Broker) append(topicName string, partition int32, records []record) (int64, int16) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	t, ok := broker.topics[topicName]
+	if !ok || partition < 0 || int(partition) >= len(t.partitions) {
+		return -1, int16(kafka.UnknownTopicOrPartition)
+	}
+
+	now := time.Now()
+	baseOffset := int64(len(t.partitions[partition]))
+
+	for i, r := range records {
+		r.offset = baseOffset + int64(i)
+		if r.time.IsZero() || r.time.Unix() <= 0 {
+			r.time = now
+		}
+		t.partitions[partition] = append(t.partitions[partition], r)
+	}
+
+	if len(records) > 0 {
+		broker.notify()
+	}
+
+	return baseOffset, 0
+}
+
+// fetchPartition is the result of a fetch of a single partition
+type fetchPartition struct {
+	partition     int32
+	errorCode     int16
+	highWatermark int64
+	records       []record
+}
+
+// fetch waits up to the request max wait time for records and writes the response.
+// The response is encoded by hand since protocol.RecordSet always writes a zero base offset.
+func (broker *Broker) fetch(w io.Writer, apiVersion int16, correlationID int32, request *fetch.Request) error {
+	deadline := time.NewTimer(time.Duration(request.MaxWaitTime) * time.Millisecond)
+	defer deadline.Stop()
+
+	for {
+		results, updated, found := broker.readPartitions(request)
+		if found || request.MaxWaitTime <= 0 {
+			return writeFetchResponse(w, apiVersion, correlationID, request, results)
+		}
+
+		select {
+		case <-updated:
+		case <-deadline.C:
+			results, _, _ = broker.readPartitions(request)
+
+			return writeFetchResponse(w, apiVersion, correlationID, request, results)
+		case <-broker.done:
+			return errBrokerClosed
+		}
+	}
+}
+
+// readPartitions reads the requested partitions, it returns true if any record or error was found
+func (broker *Broker) readPartitions(request *fetch.Request) ([][]fetchPartition, <-chan struct{}, bool) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	found := false
+	results := make([][]fetchPartition, len(request.Topics))
+
+	for i, requestTopic := range request.Topics {
+		t := broker.topics[requestTopic.Topic]
+
+		for _, requestPartition := range requestTopic.Partitions {
+			result := fetchPartition{partition: requestPartition.Partition, highWatermark: -1}
+
+			switch {
+			case t == nil || requestPartition.Partition < 0 || int(requestPartition.Partition) >= len(t.partitions):
+				result.errorCode = int16(kafka.UnknownTopicOrPartition)
+			default:
+				log := t.partitions[requestPartition.Partition]
+				result.highWatermark = int64(len(log))
+
+				offset := requestPartition.FetchOffset
+				if offset < 0 || offset > result.highWatermark {
+					result.errorCode = int16(kafka.OffsetOutOfRange)
+					break
+				}
+
+				size := 0
+				for _, r := range log[offset:] {
+					if len(result.records) > 0 && size+r.size() > int(requestPartition.PartitionMaxBytes) {
+						break
+					}
+					size += r.size()
+					result.records = append(result.records, r)
+				}
+			}
+
+			if result.errorCode != 0 || len(result.records) > 0 {
+				found = true
+			}
+
+			results[i] = append(results[i], result)
+		}
+	}
+
+	return results, broker.updated, found
+}
+
+// writeFetchResponse writes a fetch response, versions 4 to 10
+func writeFetchResponse(w io.Writer, apiVersion int16, correlationID int32, request *fetch.Request,
+	results [][]fetchPartition) error {
+	body := &bytes.Buffer{}
+
+	writeInt32(body, correlationID)
+	writeInt32(body, 0) // throttle time
+	if apiVersion >= 7 {
+		writeInt16(body, 0) // error code
+		writeInt32(body, 0) // session id
+	}
+
+	writeInt32(body, int32(len(request.Topics)))
+	for i, requestTopic := range request.Topics {
+		writeString(body, requestTopic.Topic)
+		writeInt32(body, int32(len(results[i])))
+
+		for _, result := range results[i] {
+			writeInt32(body, result.partition)
+			writeInt16(body, result.errorCode)
+			writeInt64(body, result.highWatermark)
+			writeInt64(body, result.highWatermark) // last stable offset
+			if apiVersion >= 5 {
+				writeInt64(body, 0) // log start offset
+			}
+			writeInt32(body, 0) // aborted transactions
+
+			if err := writeRecordSet(body, result.records); err != nil {
+				return err
+			}
+		}
+	}
+
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(body.Len()))
+
+	if _, err := w.Write(size); err != nil {
+		return err
+	}
+
+	_, err := body.WriteTo(w)
+
+	return err
+}
+
+// writeRecordSet writes the records as a single record batch
+func writeRecordSet(w *bytes.Buffer, records []record) error {
+	if len(records) == 0 {
+		writeInt32(w, 0)
+		return nil
+	}
+
+	protocolRecords := make([]protocol.Record, 0, len(records))
+	for _, r := range records {
+		protocolRecords = append(protocolRecords, protocol.Record{
+			Offset:  r.offset,
+			Time:    r.time,
+			Key:     protocol.NewBytes(r.key),
+			Value:   protocol.NewBytes(r.value),
+			Headers: r.headers,
+		})
+	}
+
+	recordSet := &protocol.RecordSet{Version: 2, Records: protocol.NewRecordReader(protocolRecords...)}
+
+	start := w.Len()
+	if _, err := recordSet.WriteTo(w); err != nil {
+		return err
+	}
+
+	// the base offset follows the record set size, it isn't covered by the batch checksum
+	binary.BigEndian.PutUint64(w.Bytes()[start+4:start+12], uint64(records[0].offset))
+
+	return nil
+}
+
+// listOffsets returns the first offset (-2), the high watermark (-1) or the first offset at a timestamp
+func (broker *Broker) listOffsets(request *listoffsets.Request) *listoffsets.Response {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	response := &listoffsets.Response{}
+
+	for _, requestTopic := range request.Topics {
+		responseTopic := listoffsets.ResponseTopic{Topic: requestTopic.Topic}
+		t := broker.topics[requestTopic.Topic]
+
+		for _, requestPartition := range requestTopic.Partitions {
+			responsePartition := listoffsets.ResponsePartition{
+				Partition: requestPartition.Partition,
+				Timestamp: -1,
+				Offset:    -1,
+			}
+
+			if t == nil || requestPartition.Partition < 0 || int(requestPartition.Partition) >= len(t.partitions) {
+				responsePartition.ErrorCode = int16(kafka.UnknownTopicOrPartition)
+				responseTopic.Partitions = append(responseTopic.Partitions, responsePartition)
+
+				continue
+			}
+
+			log := t.partitions[requestPartition.Partition]
+
+			switch requestPartition.Timestamp {
+			case kafka.FirstOffset:
+				responsePartition.Offset = 0
+			case kafka.LastOffset:
+				responsePartition.Offset = int64(len(log))
+			default:
+				for _, r := range log {
+					if r.time.UnixMilli() >= requestPartition.Timestamp {
+						responsePartition.Offset = r.offset
+						responsePartition.Timestamp = r.time.UnixMilli()
+
+						break
+					}
+				}
+			}
+
+			responseTopic.Partitions = append(responseTopic.Partitions, responsePartition)
+		}
+
+		response.Topics = append(response.Topics, responseTopic)
+	}
+
+	return response
+}
+
+func writeInt16(w *bytes.Buffer, v int16) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeInt32(w *bytes.Buffer, v int32) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeInt64(w *bytes.Buffer, v int64) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	writeInt16(w, int16(len(s)))
+	w.WriteString(s)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store, for testing and as a reference implementation.
+// It isn't transactional: the records are appended right away and the Tx is ignored.
+type MemoryStore struct {
+	lock    sync.Mutex
+	records []Record
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Append adds the records, the Tx can be nil
+func (store *MemoryStore) Append(ctx context.Context, tx Tx, records ...Record) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.records = append(store.records, records...)
+
+	return nil
+}
+
+// Pending returns up to limit records not sent yet, in the order they were appended
+func (store *MemoryStore) Pending(ctx context.Context, limit int) ([]Record, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if limit > len(store.records) {
+		limit = len(store.records)
+	}
+
+	pending := make([]Record, limit)
+	copy(pending, store.records)
+
+	return pending, nil
+}
+
+// CountPending returns the number of records not sent yet
+func (store *MemoryStore) CountPending(ctx context.Context) (int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return int64(len(store.records)), nil
+}
+
+// MarkSent marks the records as sent, they are removed from the store
+func (store *MemoryStore) MarkSent(ctx context.Context, ids ...string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	sent := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+
+	records := store.records[:0]
+	for _, record := range store.records {
+		if !sent[record.ID] {
+			records = append(records, record)
+		}
+	}
+	store.records = records
+
+	return nil
+}
+
+// Records returns the records not sent yet
+func (store *MemoryStore) Records() []Record {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	records := make([]Record, len(store.records))
+	copy(records, store.records)
+
+	return records
+}
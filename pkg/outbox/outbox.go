@@ -0,0 +1,101 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package outbox implements the transactional outbox pattern: events are appended to a store within the
+// transaction of the database writes, and a relay publishes them to the event stream afterward.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	errNilPublishBuilder = errors.New("publish builder should not be nil")
+	errInvalidTopic      = errors.New("topic format isn't valid")
+	errInvalidEventName  = errors.New("eventname format isn't valid")
+)
+
+var topicRegex = regexp.MustCompile(eventstream.TopicEventPattern)
+
+// Record is an event kept in the outbox until the relay publishes it
+type Record struct {
+	ID        string // ID of the event
+	Topic     string // topic without the client prefix
+	EventName string
+	Key       []byte
+	Value     []byte // event serialized by eventstream.ConstructEvent
+	Headers   []kafka.Header
+	CreatedAt time.Time
+}
+
+// Tx is the transaction the records are appended in, implemented by *sql.Tx
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Store keeps the records until they are sent
+type Store interface {
+	// Append adds the records within the transaction of the caller
+	Append(ctx context.Context, tx Tx, records ...Record) error
+
+	// Pending returns up to limit records not sent yet, the oldest first
+	Pending(ctx context.Context, limit int) ([]Record, error)
+
+	// MarkSent marks the records as sent, they aren't returned by Pending anymore
+	MarkSent(ctx context.Context, ids ...string) error
+
+	// CountPending returns the number of records not sent yet
+	CountPending(ctx context.Context) (int64, error)
+}
+
+// NewRecord constructs the event of the publish builder into a record for the topic.
+// The event ID and timestamp are fixed when the record is created.
+func NewRecord(topic string, publishBuilder *eventstream.PublishBuilder) (Record, error) {
+	if publishBuilder == nil {
+		return Record{}, errNilPublishBuilder
+	}
+
+	if !topicRegex.MatchString(topic) {
+		return Record{}, errInvalidTopic
+	}
+
+	message, event, err := eventstream.ConstructEvent(publishBuilder)
+	if err != nil {
+		return Record{}, fmt.Errorf("unable to construct event : %s , error : %v", event.EventName, err)
+	}
+
+	if !topicRegex.MatchString(event.EventName) {
+		return Record{}, errInvalidEventName
+	}
+
+	return Record{
+		ID:        event.ID,
+		Topic:     topic,
+		EventName: event.EventName,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   message.Headers,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
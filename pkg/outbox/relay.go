@@ -0,0 +1,243 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPollInterval   = time.Second
+	defaultBatchSize      = 100
+	defaultPublishTimeout = 10 * time.Second
+)
+
+// RelayConfig is the configuration of the relay
+type RelayConfig struct {
+	PollInterval    time.Duration        // delay between the polls once the outbox is empty or after a failure. default: 1s
+	BatchSize       int                  // maximum number of records fetched per poll. default: 100
+	PublishTimeout  time.Duration        // timeout of the publish of a record. default: 10s
+	MetricsRegistry *prometheus.Registry // optional registry to report the outbox metrics to prometheus
+}
+
+// Relay polls the store and publishes the records with PublishSync in the order of the store.
+// A record is published at least once: it's published again if the relay stops before marking it as sent.
+// Only one relay should run per store.
+type Relay struct {
+	client eventstream.Client
+	store  Store
+
+	pollInterval   time.Duration
+	batchSize      int
+	publishTimeout time.Duration
+
+	lock    sync.Mutex
+	pending int64
+	oldest  time.Time // creation time of the oldest record not sent yet, zero if there is none
+	sent    int64
+	failed  int64
+}
+
+// NewRelay creates a relay publishing the records of the store through the client
+func NewRelay(client eventstream.Client, store Store, config ...*RelayConfig) *Relay {
+	relay := &Relay{
+		client:         client,
+		store:          store,
+		pollInterval:   defaultPollInterval,
+		batchSize:      defaultBatchSize,
+		publishTimeout: defaultPublishTimeout,
+	}
+
+	if len(config) > 0 && config[0] != nil {
+		if config[0].PollInterval > 0 {
+			relay.pollInterval = config[0].PollInterval
+		}
+
+		if config[0].BatchSize > 0 {
+			relay.batchSize = config[0].BatchSize
+		}
+
+		if config[0].PublishTimeout > 0 {
+			relay.publishTimeout = config[0].PublishTimeout
+		}
+
+		if config[0].MetricsRegistry != nil {
+			err := config[0].MetricsRegistry.Register(&kafkaprometheus.OutboxCollector{Relay: relay})
+			if err != nil {
+				logrus.Errorf("failed to register outbox metrics: %v", err)
+			}
+		}
+	}
+
+	return relay
+}
+
+// Run relays the records until the context is done, it returns the context error
+func (relay *Relay) Run(ctx context.Context) error {
+	for {
+		if _, err := relay.RelayPending(ctx); err != nil && ctx.Err() == nil {
+			logrus.Error("unable to relay outbox records: ", err)
+		}
+
+		select {
+		case <-time.After(relay.pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RelayPending publishes the records not sent yet until the store is empty.
+// It stops at the first failure, so the next records aren't published before the failed one.
+// It returns the number of records sent.
+func (relay *Relay) RelayPending(ctx context.Context) (int, error) {
+	total, err := relay.relayPending(ctx)
+	relay.countPending(ctx)
+
+	return total, err
+}
+
+// relayPending polls and relays the batches of records until the store is empty or a batch fails
+func (relay *Relay) relayPending(ctx context.Context) (int, error) {
+	total := 0
+
+	for {
+		records, err := relay.store.Pending(ctx, relay.batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		sent, err := relay.relay(ctx, records)
+		total += sent
+		if err != nil {
+			return total, err
+		}
+
+		if len(records) < relay.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// countPending updates the number of records not sent yet from the store, it's kept if the count fails
+func (relay *Relay) countPending(ctx context.Context) {
+	pending, err := relay.store.CountPending(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Warn("unable to count pending outbox records: ", err)
+		}
+
+		return
+	}
+
+	relay.lock.Lock()
+	relay.pending = pending
+	relay.lock.Unlock()
+}
+
+// relay publishes the records in order and marks the published ones as sent
+func (relay *Relay) relay(ctx context.Context, records []Record) (int, error) {
+	relay.updateStats(records, 0)
+
+	ids := make([]string, 0, len(records))
+
+	var publishErr error
+	for _, record := range records {
+		if publishErr = relay.publish(ctx, record); publishErr != nil {
+			relay.lock.Lock()
+			relay.failed++
+			relay.lock.Unlock()
+
+			break
+		}
+
+		ids = append(ids, record.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := relay.store.MarkSent(ctx, ids...); err != nil {
+			return 0, err
+		}
+	}
+
+	relay.updateStats(records, len(ids))
+
+	return len(ids), publishErr
+}
+
+// publish publishes the record as it was constructed
+func (relay *Relay) publish(ctx context.Context, record Record) error {
+	ctx, cancel := context.WithTimeout(ctx, relay.publishTimeout)
+	defer cancel()
+
+	err := relay.client.PublishSync(
+		eventstream.NewPublish().
+			Topic(record.Topic).
+			EventName(record.EventName).
+			Message(kafka.Message{Key: record.Key, Value: record.Value, Headers: record.Headers}).
+			Context(ctx))
+	if err != nil {
+		logrus.
+			WithField("Topic Name", record.Topic).
+			WithField("Event Name", record.EventName).
+			WithField("Event ID", record.ID).
+			Error("unable to publish outbox record: ", err)
+
+		return fmt.Errorf("unable to publish outbox record %s: %w", record.ID, err)
+	}
+
+	return nil
+}
+
+// updateStats updates the sent records and the lag from the polled records, the first sent ones are published
+func (relay *Relay) updateStats(records []Record, sent int) {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+
+	relay.sent += int64(sent)
+	relay.oldest = time.Time{}
+	if sent < len(records) {
+		relay.oldest = records[sent].CreatedAt
+	}
+}
+
+// GetOutboxStats returns the stats of the relay
+func (relay *Relay) GetOutboxStats() kafkaprometheus.OutboxStats {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+
+	stats := kafkaprometheus.OutboxStats{
+		Pending: relay.pending,
+		Sent:    relay.sent,
+		Failed:  relay.failed,
+	}
+
+	if !relay.oldest.IsZero() {
+		stats.Lag = time.Since(relay.oldest)
+	}
+
+	return stats
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTopic     = "user"
+	testEventName = "userCreated"
+)
+
+func createMemoryClient(t *testing.T) eventstream.Client {
+	t.Helper()
+
+	client, err := eventstream.NewClient("test", "memory", nil)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	return client
+}
+
+func appendRecords(t *testing.T, store Store, count int) []Record {
+	t.Helper()
+
+	records := make([]Record, 0, count)
+	for i := 0; i < count; i++ {
+		record, err := NewRecord(testTopic,
+			eventstream.NewPublish().
+				EventName(testEventName).
+				Key("user-1").
+				Header("routing", "eu").
+				EventID(i))
+		require.NoError(t, err)
+
+		records = append(records, record)
+	}
+
+	require.NoError(t, store.Append(context.Background(), nil, records...))
+
+	return records
+}
+
+func subscribe(t *testing.T, client eventstream.Client) <-chan *eventstream.Event {
+	t.Helper()
+
+	events := make(chan *eventstream.Event, 10)
+	err := client.Register(
+		eventstream.NewSubscribe().
+			Topic(testTopic).
+			EventName(testEventName).
+			Offset(kafka.FirstOffset).
+			Callback(func(ctx context.Context, event *eventstream.Event, err error) error {
+				if event != nil {
+					events <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	return events
+}
+
+func TestRelayPublishesRecordsInOrder(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	store := NewMemoryStore()
+	records := appendRecords(t, store, 5)
+
+	relay := NewRelay(client, store, &RelayConfig{BatchSize: 2})
+	sent, err := relay.RelayPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 5, sent)
+	assert.Empty(t, store.Records())
+
+	events := subscribe(t, client)
+	for _, record := range records {
+		select {
+		case event := <-events:
+			assert.Equal(t, record.ID, event.ID, "the event ID should be fixed when the record is created")
+			assert.Equal(t, "user-1", event.Key)
+			assert.Equal(t, map[string]string{"routing": "eu"}, event.Headers)
+		case <-time.After(5 * time.Second):
+			assert.FailNow(t, "timeout while waiting for the relayed events")
+		}
+	}
+
+	stats := relay.GetOutboxStats()
+	assert.Equal(t, int64(5), stats.Sent)
+	assert.Zero(t, stats.Pending)
+	assert.Zero(t, stats.Lag)
+}
+
+func TestRelayKeepsRecordsOnFailure(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	store := NewMemoryStore()
+	appendRecords(t, store, 3)
+
+	require.NoError(t, client.Close(context.Background()))
+
+	relay := NewRelay(client, store, &RelayConfig{BatchSize: 2})
+	sent, err := relay.RelayPending(context.Background())
+	assert.ErrorIs(t, err, eventstream.ErrClientClosed)
+	assert.Zero(t, sent)
+	assert.Len(t, store.Records(), 3)
+
+	stats := relay.GetOutboxStats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(3), stats.Pending, "all the records not sent should be pending, not only the polled ones")
+	assert.Positive(t, stats.Lag)
+}
+
+func TestRelayRun(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+	store := NewMemoryStore()
+	events := subscribe(t, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- NewRelay(client, store, &RelayConfig{PollInterval: 10 * time.Millisecond}).Run(ctx)
+	}()
+
+	records := appendRecords(t, store, 1)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, records[0].ID, event.ID)
+	case <-time.After(5 * time.Second):
+		assert.FailNow(t, "timeout while waiting for the relayed event")
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestNewRecordInvalidTopic(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewRecord("invalid topic", eventstream.NewPublish().EventName(testEventName))
+	assert.ErrorIs(t, err, errInvalidTopic)
+
+	_, err = NewRecord(testTopic, eventstream.NewPublish())
+	assert.ErrorIs(t, err, errInvalidEventName)
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTable  = "eventstream_outbox"
+	recordColumns = 7 // columns inserted per record
+)
+
+// QuestionPlaceholder is the placeholder of MySQL and SQLite
+func QuestionPlaceholder(int) string {
+	return "?"
+}
+
+// DollarPlaceholder is the placeholder of PostgreSQL
+func DollarPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// SQLStoreConfig is the configuration of the database/sql store
+type SQLStoreConfig struct {
+	Table       string             // name of the outbox table. default: eventstream_outbox
+	Placeholder func(n int) string // placeholder of the nth query argument, from 1. default: QuestionPlaceholder
+}
+
+// SQLStore is a Store keeping the records in a database/sql table with the columns:
+//
+//	id          VARCHAR(32) PRIMARY KEY
+//	topic       VARCHAR(255) NOT NULL
+//	event_name  VARCHAR(255) NOT NULL
+//	event_key   BLOB (BYTEA in PostgreSQL)
+//	event_value BLOB (BYTEA in PostgreSQL) NOT NULL
+//	headers     BLOB (BYTEA in PostgreSQL), the kafka headers as JSON
+//	created_at  BIGINT NOT NULL, unix time in nanoseconds
+//	sent_at     BIGINT, unix time in nanoseconds, NULL until the record is sent
+//
+// An index on (sent_at, created_at) keeps the pending query fast.
+type SQLStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+// NewSQLStore creates a store using the outbox table of the database
+func NewSQLStore(db *sql.DB, config ...*SQLStoreConfig) *SQLStore {
+	store := &SQLStore{
+		db:          db,
+		table:       defaultTable,
+		placeholder: QuestionPlaceholder,
+	}
+
+	if len(config) > 0 && config[0] != nil {
+		if config[0].Table != "" {
+			store.table = config[0].Table
+		}
+
+		if config[0].Placeholder != nil {
+			store.placeholder = config[0].Placeholder
+		}
+	}
+
+	return store
+}
+
+// Append inserts the records within the transaction of the caller
+func (store *SQLStore) Append(ctx context.Context, tx Tx, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(records)*recordColumns)
+	for _, record := range records {
+		var headers []byte
+		if len(record.Headers) > 0 {
+			var err error
+			if headers, err = json.Marshal(record.Headers); err != nil {
+				return fmt.Errorf("unable to marshal outbox record headers: %w", err)
+			}
+		}
+
+		args = append(args, record.ID, record.Topic, record.EventName, record.Key, record.Value, headers,
+			record.CreatedAt.UnixNano())
+	}
+
+	_, err := tx.ExecContext(ctx, store.appendQuery(len(records)), args...)
+	if err != nil {
+		return fmt.Errorf("unable to append outbox records: %w", err)
+	}
+
+	return nil
+}
+
+// Pending returns up to limit records not sent yet, the oldest first
+func (store *SQLStore) Pending(ctx context.Context, limit int) ([]Record, error) {
+	rows, err := store.db.QueryContext(ctx, store.pendingQuery(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query pending outbox records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var headers []byte
+		var createdAt int64
+
+		err = rows.Scan(&record.ID, &record.Topic, &record.EventName, &record.Key, &record.Value, &headers,
+			&createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan outbox record: %w", err)
+		}
+
+		if len(headers) > 0 {
+			if err = json.Unmarshal(headers, &record.Headers); err != nil {
+				return nil, fmt.Errorf("unable to unmarshal outbox record headers: %w", err)
+			}
+		}
+
+		record.CreatedAt = time.Unix(0, createdAt).UTC()
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to query pending outbox records: %w", err)
+	}
+
+	return records, nil
+}
+
+// CountPending returns the number of records not sent yet
+func (store *SQLStore) CountPending(ctx context.Context) (int64, error) {
+	var count int64
+
+	err := store.db.QueryRowContext(ctx, store.countPendingQuery()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count pending outbox records: %w", err)
+	}
+
+	return count, nil
+}
+
+// MarkSent sets the sent time of the records
+func (store *SQLStore) MarkSent(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, time.Now().UnixNano())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	_, err := store.db.ExecContext(ctx, store.markSentQuery(len(ids)), args...)
+	if err != nil {
+		return fmt.Errorf("unable to mark outbox records as sent: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeSent deletes the records sent before the time, returns the number of deleted records
+func (store *SQLStore) PurgeSent(ctx context.Context, before time.Time) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s",
+		store.table, store.placeholder(1))
+
+	result, err := store.db.ExecContext(ctx, query, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("unable to purge sent outbox records: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// appendQuery returns the query inserting the records
+func (store *SQLStore) appendQuery(records int) string {
+	values := make([]string, 0, records)
+	for i := 0; i < records; i++ {
+		placeholders := make([]string, recordColumns)
+		for j := range placeholders {
+			placeholders[j] = store.placeholder(i*recordColumns + j + 1)
+		}
+
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (id, topic, event_name, event_key, event_value, headers, created_at) VALUES %s",
+		store.table, strings.Join(values, ", "))
+}
+
+// pendingQuery returns the query selecting the records not sent yet
+func (store *SQLStore) pendingQuery() string {
+	return fmt.Sprintf("SELECT id, topic, event_name, event_key, event_value, headers, created_at FROM %s "+
+		"WHERE sent_at IS NULL ORDER BY created_at, id LIMIT %s", store.table, store.placeholder(1))
+}
+
+// countPendingQuery returns the query counting the records not sent yet
+func (store *SQLStore) countPendingQuery() string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE sent_at IS NULL", store.table)
+}
+
+// markSentQuery returns the query setting the sent time of the records
+func (store *SQLStore) markSentQuery(ids int) string {
+	placeholders := make([]string, ids)
+	for i := range placeholders {
+		placeholders[i] = store.placeholder(i + 2)
+	}
+
+	return fmt.Sprintf("UPDATE %s SET sent_at = %s WHERE id IN (%s)",
+		store.table, store.placeholder(1), strings.Join(placeholders, ", "))
+}
@@ -0,0 +1,396 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errUnsupportedQuery = errors.New("unsupported query")
+
+// fakeRow is a row of the fake outbox table
+type fakeRow struct {
+	values []driver.Value // the inserted columns, in the order of appendQuery
+	sentAt driver.Value
+}
+
+// fakeTable is a database/sql connector of a single outbox table, supporting only the queries of SQLStore
+type fakeTable struct {
+	lock sync.Mutex
+	rows []*fakeRow
+}
+
+// openFakeTable returns the store of a new fake table and the table
+func openFakeTable(t *testing.T) (*SQLStore, *fakeTable) {
+	t.Helper()
+
+	table := &fakeTable{}
+	db := sql.OpenDB(table)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return NewSQLStore(db), table
+}
+
+func (table *fakeTable) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{table: table}, nil
+}
+
+func (table *fakeTable) Driver() driver.Driver {
+	return nil
+}
+
+// row returns the row of the id, nil if there's none
+func (table *fakeTable) row(id string) *fakeRow {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	for _, row := range table.rows {
+		if row.values[0] == id {
+			return row
+		}
+	}
+
+	return nil
+}
+
+// fakeConn executes the queries on the table, a transaction restores the rows on rollback
+type fakeConn struct {
+	table    *fakeTable
+	snapshot []*fakeRow
+}
+
+func (conn *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errUnsupportedQuery
+}
+
+func (conn *fakeConn) Close() error {
+	return nil
+}
+
+func (conn *fakeConn) Begin() (driver.Tx, error) {
+	conn.table.lock.Lock()
+	defer conn.table.lock.Unlock()
+
+	conn.snapshot = make([]*fakeRow, 0, len(conn.table.rows))
+	for _, row := range conn.table.rows {
+		copied := *row
+		conn.snapshot = append(conn.snapshot, &copied)
+	}
+
+	return conn, nil
+}
+
+func (conn *fakeConn) Commit() error {
+	conn.snapshot = nil
+
+	return nil
+}
+
+func (conn *fakeConn) Rollback() error {
+	conn.table.lock.Lock()
+	defer conn.table.lock.Unlock()
+
+	conn.table.rows = conn.snapshot
+	conn.snapshot = nil
+
+	return nil
+}
+
+func (conn *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn.table.lock.Lock()
+	defer conn.table.lock.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "INSERT"):
+		for i := 0; i < len(args); i += recordColumns {
+			row := &fakeRow{}
+			for _, arg := range args[i : i+recordColumns] {
+				row.values = append(row.values, arg.Value)
+			}
+
+			conn.table.rows = append(conn.table.rows, row)
+		}
+
+		return driver.RowsAffected(len(args) / recordColumns), nil
+	case strings.HasPrefix(query, "UPDATE"):
+		var updated int64
+		for _, row := range conn.table.rows {
+			for _, arg := range args[1:] {
+				if row.values[0] == arg.Value {
+					row.sentAt = args[0].Value
+					updated++
+				}
+			}
+		}
+
+		return driver.RowsAffected(updated), nil
+	case strings.HasPrefix(query, "DELETE"):
+		rows := make([]*fakeRow, 0, len(conn.table.rows))
+		for _, row := range conn.table.rows {
+			if row.sentAt == nil || row.sentAt.(int64) >= args[0].Value.(int64) {
+				rows = append(rows, row)
+			}
+		}
+
+		deleted := len(conn.table.rows) - len(rows)
+		conn.table.rows = rows
+
+		return driver.RowsAffected(deleted), nil
+	}
+
+	return nil, errUnsupportedQuery
+}
+
+func (conn *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn.table.lock.Lock()
+	defer conn.table.lock.Unlock()
+
+	pending := make([]*fakeRow, 0, len(conn.table.rows))
+	for _, row := range conn.table.rows {
+		if row.sentAt == nil {
+			pending = append(pending, row)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(query, "SELECT COUNT(*)"):
+		return &fakeRows{columns: []string{"count"}, values: [][]driver.Value{{int64(len(pending))}}}, nil
+	case strings.HasPrefix(query, "SELECT id"):
+		sort.SliceStable(pending, func(i, j int) bool {
+			if pending[i].values[6] != pending[j].values[6] {
+				return pending[i].values[6].(int64) < pending[j].values[6].(int64)
+			}
+
+			return pending[i].values[0].(string) < pending[j].values[0].(string)
+		})
+
+		if limit := int(args[0].Value.(int64)); limit < len(pending) {
+			pending = pending[:limit]
+		}
+
+		rows := &fakeRows{columns: []string{"id", "topic", "event_name", "event_key", "event_value", "headers",
+			"created_at"}}
+		for _, row := range pending {
+			rows.values = append(rows.values, row.values)
+		}
+
+		return rows, nil
+	}
+
+	return nil, errUnsupportedQuery
+}
+
+// fakeRows are the rows returned by a query of fakeConn
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (rows *fakeRows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+
+	return nil
+}
+
+func TestSQLStoreQueries(t *testing.T) {
+	t.Parallel()
+
+	store := NewSQLStore(nil)
+	assert.Equal(t,
+		"INSERT INTO eventstream_outbox (id, topic, event_name, event_key, event_value, headers, created_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?, ?)",
+		store.appendQuery(2))
+	assert.Equal(t,
+		"SELECT id, topic, event_name, event_key, event_value, headers, created_at FROM eventstream_outbox "+
+			"WHERE sent_at IS NULL ORDER BY created_at, id LIMIT ?",
+		store.pendingQuery())
+
+	store = NewSQLStore(nil, &SQLStoreConfig{Table: "outbox", Placeholder: DollarPlaceholder})
+	assert.Equal(t,
+		"INSERT INTO outbox (id, topic, event_name, event_key, event_value, headers, created_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14)",
+		store.appendQuery(2))
+	assert.Equal(t, "UPDATE outbox SET sent_at = $1 WHERE id IN ($2, $3)", store.markSentQuery(2))
+	assert.Equal(t, "SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL", store.countPendingQuery())
+}
+
+func TestSQLStoreAppendAndPending(t *testing.T) {
+	t.Parallel()
+	store, table := openFakeTable(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2023, 5, 1, 10, 30, 0, 123456789, time.FixedZone("UTC+7", 7*60*60))
+	withHeaders := Record{
+		ID:        "b",
+		Topic:     testTopic,
+		EventName: testEventName,
+		Key:       []byte("user-1"),
+		Value:     []byte(`{"id":"b"}`),
+		Headers:   []kafka.Header{{Key: "routing", Value: []byte("eu")}, {Key: "tenant", Value: []byte("t1")}},
+		CreatedAt: createdAt,
+	}
+	withoutKey := Record{
+		ID:        "a",
+		Topic:     testTopic,
+		EventName: testEventName,
+		Value:     []byte(`{"id":"a"}`),
+		CreatedAt: createdAt.Add(time.Nanosecond),
+	}
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, store.Append(ctx, tx, withoutKey, withHeaders))
+	require.NoError(t, tx.Commit())
+
+	assert.Equal(t, createdAt.UnixNano(), table.row("b").values[6], "created_at should be in unix nanoseconds")
+
+	records, err := store.Pending(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "b", records[0].ID, "the records should be ordered by creation time")
+	assert.Equal(t, []byte("user-1"), records[0].Key)
+	assert.Equal(t, withHeaders.Headers, records[0].Headers)
+	assert.Equal(t, createdAt.UTC(), records[0].CreatedAt)
+
+	assert.Equal(t, "a", records[1].ID)
+	assert.Nil(t, records[1].Key)
+	assert.Nil(t, records[1].Headers)
+	assert.Equal(t, withoutKey.Value, records[1].Value)
+	assert.Equal(t, withoutKey.CreatedAt.UTC(), records[1].CreatedAt)
+}
+
+func TestSQLStorePendingOrder(t *testing.T) {
+	t.Parallel()
+	store, _ := openFakeTable(t)
+	ctx := context.Background()
+
+	createdAt := time.Now()
+	records := []Record{
+		{ID: "c", Topic: testTopic, EventName: testEventName, Value: []byte("c"), CreatedAt: createdAt},
+		{ID: "d", Topic: testTopic, EventName: testEventName, Value: []byte("d"), CreatedAt: createdAt.Add(-time.Second)},
+		{ID: "a", Topic: testTopic, EventName: testEventName, Value: []byte("a"), CreatedAt: createdAt},
+		{ID: "b", Topic: testTopic, EventName: testEventName, Value: []byte("b"), CreatedAt: createdAt.Add(time.Second)},
+	}
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, store.Append(ctx, tx, records...))
+	require.NoError(t, tx.Commit())
+
+	pending, err := store.Pending(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "a", "c"}, recordIDs(pending),
+		"the records should be ordered by creation time then ID, up to the limit")
+}
+
+func TestSQLStoreAppendRolledBack(t *testing.T) {
+	t.Parallel()
+	store, _ := openFakeTable(t)
+	ctx := context.Background()
+
+	record, err := NewRecord(testTopic, eventstream.NewPublish().EventName(testEventName))
+	require.NoError(t, err)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, store.Append(ctx, tx, record))
+	require.NoError(t, tx.Rollback())
+
+	count, err := store.CountPending(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count, "the records of a rolled back transaction shouldn't be pending")
+}
+
+func TestSQLStoreMarkSentAndPurge(t *testing.T) {
+	t.Parallel()
+	store, table := openFakeTable(t)
+	ctx := context.Background()
+
+	records := make([]Record, 0, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		records = append(records,
+			Record{ID: id, Topic: testTopic, EventName: testEventName, Value: []byte(id), CreatedAt: time.Now()})
+	}
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, store.Append(ctx, tx, records...))
+	require.NoError(t, tx.Commit())
+
+	beforeSent := time.Now()
+	require.NoError(t, store.MarkSent(ctx, "a", "c"))
+
+	assert.NotNil(t, table.row("a").sentAt)
+	assert.Nil(t, table.row("b").sentAt)
+
+	pending, err := store.Pending(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, recordIDs(pending))
+
+	count, err := store.CountPending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	purged, err := store.PurgeSent(ctx, beforeSent)
+	require.NoError(t, err)
+	assert.Zero(t, purged, "the records sent after the time shouldn't be purged")
+
+	purged, err = store.PurgeSent(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	assert.Nil(t, table.row("a"))
+	assert.NotNil(t, table.row("b"), "the records not sent shouldn't be purged")
+}
+
+func recordIDs(records []Record) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,546 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	partitionFetchRetryDelay = time.Second // delay before fetching a partition again after an error
+)
+
+var errPartitionsRevoked = errors.New("partitions revoked")
+
+// TopicPartition is a partition assigned to or revoked from a subscriber, the topic is without the client prefix
+type TopicPartition struct {
+	Topic     string
+	Partition int
+}
+
+// hasPartitionHooks returns true if the subscriber has OnPartitionsAssigned or OnPartitionsRevoked
+func (s *SubscribeBuilder) hasPartitionHooks() bool {
+	return s.onPartitionsAssigned != nil || s.onPartitionsRevoked != nil
+}
+
+// partitionsAssigned calls OnPartitionsAssigned with the partitions, their topics have the client prefix
+func (sub *subscription) partitionsAssigned(generationID int32, partitions []topicPartition) {
+	if sub.builder.onPartitionsAssigned != nil {
+		sub.builder.onPartitionsAssigned(sub.ctx, generationID, sub.topicPartitions(partitions))
+	}
+}
+
+// partitionsRevoked calls OnPartitionsRevoked with the partitions, their topics have the client prefix
+func (sub *subscription) partitionsRevoked(generationID int32, partitions []topicPartition) {
+	if sub.builder.onPartitionsRevoked != nil {
+		sub.builder.onPartitionsRevoked(sub.ctx, generationID, sub.topicPartitions(partitions))
+	}
+}
+
+// topicPartitions returns the partitions with the topics without the client prefix, sorted
+func (sub *subscription) topicPartitions(partitions []topicPartition) []TopicPartition {
+	result := make([]TopicPartition, 0, len(partitions))
+	for _, partition := range partitions {
+		result = append(result, TopicPartition{
+			Topic:     trimTopicPrefix(sub.builder.prefix, partition.topic),
+			Partition: partition.partition,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Topic != result[j].Topic {
+			return result[i].Topic < result[j].Topic
+		}
+
+		return result[i].Partition < result[j].Partition
+	})
+
+	return result
+}
+
+// messageReader fetches and commits the messages of a subscriber
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// groupReader is the reader of a subscriber with partition hooks. Unlike kafka.Reader it exposes the generations
+// of the group: a generation ends once the consumer released its partitions, see revoking and release, and the
+// offsets committed meanwhile are flushed, so the partitions are revoked after their events are processed.
+type groupReader struct {
+	sub          *subscription
+	config       kafka.ReaderConfig
+	group        *kafka.ConsumerGroup
+	loggerFields *logrus.Entry
+
+	// messages of the partition readers of the current generation
+	messages chan kafka.Message
+
+	// lock guards the partitions of the current generation, their offsets not flushed yet, revoking, closed once
+	// the generation ends and nil once the consumer released its partitions, released, closed by the consumer,
+	// and started, closed once the next generation starts
+	lock       sync.Mutex
+	partitions map[topicPartition]bool
+	offsets    map[topicPartition]int64
+	revokingCh chan struct{}
+	releasedCh chan struct{}
+	startedCh  chan struct{}
+
+	// partitionReaders are the readers of the partitions of the current generation, closedStats the stats of the
+	// closed ones not reported yet, guarded by lock
+	partitionReaders map[topicPartition]*kafka.Reader
+	closedStats      kafka.ReaderStats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newGroupReader joins the group of config with the topics of config
+func newGroupReader(sub *subscription, config kafka.ReaderConfig, loggerFields *logrus.Entry) (*groupReader, error) {
+	topics := config.GroupTopics
+	if len(topics) == 0 {
+		topics = []string{config.Topic}
+	}
+
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:                     config.GroupID,
+		Brokers:                config.Brokers,
+		Dialer:                 config.Dialer,
+		Topics:                 topics,
+		GroupBalancers:         config.GroupBalancers,
+		HeartbeatInterval:      config.HeartbeatInterval,
+		PartitionWatchInterval: config.PartitionWatchInterval,
+		WatchPartitionChanges:  config.WatchPartitionChanges,
+		SessionTimeout:         config.SessionTimeout,
+		RebalanceTimeout:       config.RebalanceTimeout,
+		JoinGroupBackoff:       config.JoinGroupBackoff,
+		RetentionTime:          config.RetentionTime,
+		StartOffset:            config.StartOffset,
+		Logger:                 config.Logger,
+		ErrorLogger:            config.ErrorLogger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &groupReader{
+		sub:          sub,
+		config:       config,
+		group:        group,
+		loggerFields: loggerFields,
+		messages:     make(chan kafka.Message),
+		startedCh:    make(chan struct{}),
+		done:         make(chan struct{}),
+
+		partitionReaders: make(map[topicPartition]*kafka.Reader),
+	}
+
+	reader.wg.Add(1)
+	go reader.run()
+
+	return reader, nil
+}
+
+// run starts the generations of the group until the reader is closed
+func (reader *groupReader) run() {
+	defer reader.wg.Done()
+
+	for {
+		generation, err := reader.group.Next(context.Background())
+		if err != nil {
+			if errors.Is(err, kafka.ErrGroupClosed) {
+				return
+			}
+
+			reader.loggerFields.Error("unable to join the consumer group: ", err)
+			reader.sub.fetchFailed(err)
+
+			continue
+		}
+
+		reader.start(generation)
+	}
+}
+
+// start calls OnPartitionsAssigned and fetches the assigned partitions until the generation ends
+func (reader *groupReader) start(generation *kafka.Generation) {
+	partitions := make([]topicPartition, 0)
+	for topic, assignments := range generation.Assignments {
+		for _, assignment := range assignments {
+			partitions = append(partitions, topicPartition{topic: topic, partition: assignment.ID})
+		}
+	}
+
+	revoking, released := reader.beginGeneration(partitions)
+
+	reader.sub.resetPartitions(partitions)
+	reader.sub.partitionsAssigned(generation.ID, partitions)
+
+	var partitionReaders sync.WaitGroup
+	for topic, assignments := range generation.Assignments {
+		for _, assignment := range assignments {
+			topic, assignment := topic, assignment
+
+			partitionReaders.Add(1)
+			generation.Start(func(ctx context.Context) {
+				defer partitionReaders.Done()
+				reader.readPartition(ctx, topic, assignment)
+			})
+		}
+	}
+
+	// the generation doesn't end until this function returns, the group waits for it to rejoin
+	generation.Start(func(ctx context.Context) {
+		ticker := time.NewTicker(reader.config.CommitInterval)
+		defer ticker.Stop()
+
+		for ctx.Err() == nil {
+			select {
+			case <-ticker.C:
+				reader.flush(generation)
+			case <-ctx.Done():
+			}
+		}
+
+		partitionReaders.Wait()
+		close(revoking)
+
+		select {
+		case <-released:
+		case <-reader.done:
+		}
+
+		reader.flush(generation)
+
+		reader.lock.Lock()
+		reader.partitions = nil
+		reader.lock.Unlock()
+
+		reader.sub.resetPartitions(nil)
+		reader.sub.partitionsRevoked(generation.ID, partitions)
+	})
+}
+
+// beginGeneration assigns the partitions, it returns the revoking of the generation, closed once it ends, and
+// released, closed by the consumer afterwards
+func (reader *groupReader) beginGeneration(partitions []topicPartition) (revoking, released chan struct{}) {
+	revoking = make(chan struct{})
+	released = make(chan struct{})
+
+	reader.lock.Lock()
+	reader.partitions = make(map[topicPartition]bool, len(partitions))
+	for _, partition := range partitions {
+		reader.partitions[partition] = true
+	}
+	reader.offsets = make(map[topicPartition]int64)
+	reader.revokingCh = revoking
+	reader.releasedCh = released
+	started := reader.startedCh
+	reader.startedCh = make(chan struct{})
+	reader.closedStats.Rebalances++
+	reader.lock.Unlock()
+
+	// the consumer fetching since it released the previous partitions waits with the revoking of this generation
+	close(started)
+
+	return revoking, released
+}
+
+// readPartition sends the messages of the partition to the consumer until ctx is done
+func (reader *groupReader) readPartition(ctx context.Context, topic string, assignment kafka.PartitionAssignment) {
+	config := reader.config
+	config.GroupID = ""
+	config.GroupTopics = nil
+	config.Topic = topic
+	config.Partition = assignment.ID
+
+	key := topicPartition{topic: topic, partition: assignment.ID}
+	partitionReader := kafka.NewReader(config)
+
+	reader.lock.Lock()
+	reader.partitionReaders[key] = partitionReader
+	reader.lock.Unlock()
+
+	defer func() {
+		_ = partitionReader.Close()
+
+		reader.lock.Lock()
+		delete(reader.partitionReaders, key)
+		addReaderStats(&reader.closedStats, partitionReader.Stats())
+		reader.lock.Unlock()
+	}()
+
+	_ = partitionReader.SetOffset(assignment.Offset)
+
+	for {
+		message, err := partitionReader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			reader.loggerFields.Errorf("subscriber unable to fetch message of partition %d: %v", assignment.ID, err)
+			reader.sub.fetchFailed(err)
+
+			select {
+			case <-time.After(partitionFetchRetryDelay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case reader.messages <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// FetchMessage returns the next message of the assigned partitions. It returns errPartitionsRevoked once the
+// generation ends, until the consumer releases the partitions.
+func (reader *groupReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		revoking, started := reader.generation()
+
+		select {
+		case message := <-reader.messages:
+			return message, nil
+		case <-revoking:
+			return kafka.Message{}, errPartitionsRevoked
+		case <-started:
+			// fetches with the revoking of the new generation
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		}
+	}
+}
+
+// generation returns revoking, closed once the current generation ends and nil if the partitions are released,
+// and started, closed once the next generation starts
+func (reader *groupReader) generation() (revoking, started chan struct{}) {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	return reader.revokingCh, reader.startedCh
+}
+
+// CommitMessages stores the offsets of the messages, they're committed periodically and when the generation ends.
+// The messages of the partitions not assigned anymore are ignored.
+func (reader *groupReader) CommitMessages(_ context.Context, messages ...kafka.Message) error {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	for _, message := range messages {
+		key := topicPartition{topic: message.Topic, partition: message.Partition}
+		if !reader.partitions[key] {
+			continue
+		}
+
+		if offset, ok := reader.offsets[key]; !ok || message.Offset+1 > offset {
+			reader.offsets[key] = message.Offset + 1
+		}
+	}
+
+	return nil
+}
+
+// revoking returns true once the generation ends, until the consumer releases the partitions
+func (reader *groupReader) revoking() bool {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	select {
+	case <-reader.revokingCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// revokingContext returns a context done once the generation ends, or when parent is done
+func (reader *groupReader) revokingContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		for {
+			revoking, started := reader.generation()
+
+			select {
+			case <-revoking:
+				cancel()
+				return
+			case <-started:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// release lets the ending generation commit the offsets and revoke the partitions. The consumer releases them
+// once the events fetched from them are processed and committed.
+func (reader *groupReader) release() {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	if reader.releasedCh != nil {
+		close(reader.releasedCh)
+		reader.releasedCh = nil
+	}
+
+	// the next generation has its own revoking
+	reader.revokingCh = nil
+}
+
+// Stats returns the stats of the partition readers since the last call, the rebalances are the generations
+func (reader *groupReader) Stats() kafka.ReaderStats {
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+
+	stats := reader.closedStats
+	reader.closedStats = kafka.ReaderStats{}
+
+	for _, partitionReader := range reader.partitionReaders {
+		addReaderStats(&stats, partitionReader.Stats())
+	}
+
+	if reader.config.Dialer != nil {
+		stats.ClientID = reader.config.Dialer.ClientID
+	}
+	stats.Topic = reader.config.Topic
+
+	return stats
+}
+
+// flush commits the stored offsets for the generation
+func (reader *groupReader) flush(generation *kafka.Generation) {
+	reader.lock.Lock()
+	offsets := reader.offsets
+	reader.offsets = make(map[topicPartition]int64)
+	reader.lock.Unlock()
+
+	if len(offsets) == 0 {
+		return
+	}
+
+	commits := make(map[string]map[int]int64)
+	for key, offset := range offsets {
+		if commits[key.topic] == nil {
+			commits[key.topic] = make(map[int]int64)
+		}
+
+		commits[key.topic][key.partition] = offset
+	}
+
+	if err := generation.CommitOffsets(commits); err != nil {
+		reader.loggerFields.Error("unable to commit the events: ", err)
+
+		// the offsets are committed with the next flush, unless newer ones are stored meanwhile
+		reader.lock.Lock()
+		for key, offset := range offsets {
+			if _, ok := reader.offsets[key]; !ok && reader.partitions[key] {
+				reader.offsets[key] = offset
+			}
+		}
+		reader.lock.Unlock()
+	}
+}
+
+// Close leaves the group once the partitions are revoked
+func (reader *groupReader) Close() error {
+	close(reader.done)
+	err := reader.group.Close()
+	reader.wg.Wait()
+
+	return err
+}
+
+// addReaderStats adds the stats of a partition reader to total. The counters and the gauges are summed, except the
+// offset, the highest one, and the configuration.
+func addReaderStats(total *kafka.ReaderStats, stats kafka.ReaderStats) {
+	total.Dials += stats.Dials
+	total.Fetches += stats.Fetches
+	total.Messages += stats.Messages
+	total.Bytes += stats.Bytes
+	total.Rebalances += stats.Rebalances
+	total.Timeouts += stats.Timeouts
+	total.Errors += stats.Errors
+
+	addDurationStats(&total.DialTime, stats.DialTime)
+	addDurationStats(&total.ReadTime, stats.ReadTime)
+	addDurationStats(&total.WaitTime, stats.WaitTime)
+	addSummaryStats(&total.FetchSize, stats.FetchSize)
+	addSummaryStats(&total.FetchBytes, stats.FetchBytes)
+
+	if stats.Offset > total.Offset {
+		total.Offset = stats.Offset
+	}
+	total.Lag += stats.Lag
+	total.QueueLength += stats.QueueLength
+	total.QueueCapacity += stats.QueueCapacity
+
+	total.MinBytes = stats.MinBytes
+	total.MaxBytes = stats.MaxBytes
+	total.MaxWait = stats.MaxWait
+}
+
+// addDurationStats adds the durations of stats to total
+func addDurationStats(total *kafka.DurationStats, stats kafka.DurationStats) {
+	if stats.Count == 0 {
+		return
+	}
+
+	if total.Count == 0 || stats.Min < total.Min {
+		total.Min = stats.Min
+	}
+	if stats.Max > total.Max {
+		total.Max = stats.Max
+	}
+
+	total.Count += stats.Count
+	total.Sum += stats.Sum
+	total.Avg = total.Sum / time.Duration(total.Count)
+}
+
+// addSummaryStats adds the values of stats to total
+func addSummaryStats(total *kafka.SummaryStats, stats kafka.SummaryStats) {
+	if stats.Count == 0 {
+		return
+	}
+
+	if total.Count == 0 || stats.Min < total.Min {
+		total.Min = stats.Min
+	}
+	if stats.Max > total.Max {
+		total.Max = stats.Max
+	}
+
+	total.Count += stats.Count
+	total.Sum += stats.Sum
+	total.Avg = total.Sum / total.Count
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// partitionsHookCall is a call of OnPartitionsAssigned or OnPartitionsRevoked
+type partitionsHookCall struct {
+	generationID int32
+	partitions   []TopicPartition
+}
+
+// partitionsHook returns a partition hook sending its calls to the channel
+func partitionsHook(calls chan<- partitionsHookCall) func(context.Context, int32, []TopicPartition) {
+	return func(ctx context.Context, generationID int32, partitions []TopicPartition) {
+		calls <- partitionsHookCall{generationID: generationID, partitions: partitions}
+	}
+}
+
+// receivePartitionsHookCall returns the next call of the partition hook
+func receivePartitionsHookCall(ctx context.Context, t *testing.T, calls <-chan partitionsHookCall) partitionsHookCall {
+	t.Helper()
+
+	select {
+	case call := <-calls:
+		return call
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+		return partitionsHookCall{}
+	}
+}
+
+func TestSubscribePartitionHooks(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	groupID := generateID()
+
+	assigned := make(chan partitionsHookCall, 10)
+	revoked := make(chan partitionsHookCall, 10)
+	processing := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			OnPartitionsAssigned(partitionsHook(assigned)).
+			OnPartitionsRevoked(partitionsHook(revoked)).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					processing <- struct{}{}
+					<-release
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	assignment := receivePartitionsHookCall(ctx, t, assigned)
+	require.NotEmpty(t, assignment.partitions)
+	assert.Equal(t, topicName, assignment.partitions[0].Topic, "the topic should be without the prefix")
+
+	publishSeekTestEvents(t, client, topicName, 0)
+	<-processing
+
+	if kafkaClient, ok := client.(*KafkaClient); ok {
+		stats, slugs := kafkaClient.GetReaderStats()
+		require.Len(t, slugs, 1, "the reader stats should include the subscriber with partition hooks")
+		assert.Positive(t, stats[0].Messages)
+		assert.Positive(t, stats[0].Rebalances)
+	}
+
+	go func() {
+		_ = subscription.Unsubscribe(ctx)
+	}()
+
+	select {
+	case <-revoked:
+		assert.Fail(t, "the partitions shouldn't be revoked before the callback finishes")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	close(release)
+
+	revocation := receivePartitionsHookCall(ctx, t, revoked)
+	assert.Equal(t, assignment, revocation, "the assigned partitions should be revoked with their generation")
+
+	// the event processed before the revocation is committed, the next member of the group doesn't receive it
+	received := make(chan *Event, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			OnPartitionsAssigned(partitionsHook(assigned)).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	nextAssignment := receivePartitionsHookCall(ctx, t, assigned)
+	assert.Greater(t, nextAssignment.generationID, assignment.generationID, "the generation should increase")
+
+	publishSeekTestEvents(t, client, topicName, 1)
+	assert.Equal(t, []int{1}, receiveEventIDs(ctx, t, received, 1))
+}
+
+func TestSubscribePartitionHooksRebalance(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+	groupID := generateID()
+
+	subscribe := func(assigned, revoked chan partitionsHookCall) {
+		err := client.Register(
+			NewSubscribe().
+				Topic(topicName).
+				EventName("testEvent").
+				GroupID(groupID).
+				Context(ctx).
+				OnPartitionsAssigned(partitionsHook(assigned)).
+				OnPartitionsRevoked(partitionsHook(revoked)).
+				Callback(func(ctx context.Context, event *Event, err error) error {
+					return nil
+				}))
+		require.NoError(t, err)
+	}
+
+	firstAssigned := make(chan partitionsHookCall, 10)
+	firstRevoked := make(chan partitionsHookCall, 10)
+	subscribe(firstAssigned, firstRevoked)
+
+	assignment := receivePartitionsHookCall(ctx, t, firstAssigned)
+
+	secondAssigned := make(chan partitionsHookCall, 10)
+	subscribe(secondAssigned, make(chan partitionsHookCall, 10))
+
+	revocation := receivePartitionsHookCall(ctx, t, firstRevoked)
+	assert.Equal(t, assignment, revocation, "the partitions should be revoked when the group rebalances")
+
+	firstAssignment := receivePartitionsHookCall(ctx, t, firstAssigned)
+	secondAssignment := receivePartitionsHookCall(ctx, t, secondAssigned)
+	assert.Greater(t, firstAssignment.generationID, assignment.generationID, "the generation should increase")
+	assert.Equal(t, firstAssignment.generationID, secondAssignment.generationID)
+
+	partitions := append(firstAssignment.partitions, secondAssignment.partitions...)
+	assert.ElementsMatch(t, assignment.partitions, partitions, "the partitions should be shared by the members")
+}
+
+func TestGroupReaderGenerations(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	reader := &groupReader{messages: make(chan kafka.Message), startedCh: make(chan struct{})}
+	partitions := []topicPartition{{topic: "topic", partition: 0}}
+
+	revoking, released := reader.beginGeneration(partitions)
+	close(revoking)
+	assert.True(t, reader.revoking())
+
+	_, err := reader.FetchMessage(ctx)
+	assert.ErrorIs(t, err, errPartitionsRevoked)
+
+	reader.release()
+	assert.False(t, reader.revoking(), "the released partitions shouldn't be revoking anymore")
+
+	select {
+	case <-released:
+	default:
+		assert.Fail(t, "the generation should be released")
+	}
+
+	// the consumer fetches until the next generation ends
+	fetched := make(chan error)
+	go func() {
+		_, err := reader.FetchMessage(ctx)
+		fetched <- err
+	}()
+
+	revoking, _ = reader.beginGeneration(partitions)
+
+	select {
+	case err := <-fetched:
+		assert.Fail(t, "the fetch shouldn't return before the generation ends", "error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(revoking)
+	assert.ErrorIs(t, <-fetched, errPartitionsRevoked)
+
+	// a generation started before the previous one is released has its own revoking
+	revoking, _ = reader.beginGeneration(partitions)
+	assert.False(t, reader.revoking())
+	close(revoking)
+	assert.True(t, reader.revoking())
+}
+
+func TestSubscribePartitionHooksInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			OnPartitionsRevoked(func(ctx context.Context, generationID int32, partitions []TopicPartition) {}).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidPartitionHooks, "partition hooks should need a group ID")
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// retried operations, reported in the retry metrics
+const (
+	retryOperationPublish      = "publish"
+	retryOperationPublishBatch = "publish_batch"
+	retryOperationAuditLog     = "audit_log"
+	retryOperationSubscribe    = "subscribe"
+	retryOperationFetch        = "fetch"
+)
+
+// RetryPolicy defines how a failed operation is retried, with an exponential backoff between the attempts
+type RetryPolicy struct {
+	MaxAttempts     int           // maximum number of attempts, including the first one. 0: unlimited
+	InitialInterval time.Duration // delay before the first retry
+	MaxInterval     time.Duration // maximum delay between two attempts. 0: unlimited
+	Multiplier      float64       // growth of the delay after each retry, 1 for a constant delay
+	Jitter          float64       // randomization of the delays, from 0 to 1. e.g. 0.5 gives delay ± 50%
+	Budget          time.Duration // maximum time spent retrying since the first attempt. 0: unlimited
+
+	// Retryable classifies the errors, the operation isn't retried after an error it returns false for.
+	// default: every error is retryable
+	Retryable func(err error) bool
+}
+
+// DefaultPublishRetryPolicy returns the retry policy of Publish, PublishAsync and PublishBatch.
+// It retries for 60s with the delays 500ms, 2s, 8s, 32s, each ± 50%.
+func DefaultPublishRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     time.Minute,
+		// the default multiplier is increased, because kafka cluster operations can take quite long to complete,
+		// e.g. auto-creating topics, leader re-election, or rebalancing.
+		Multiplier: 4,
+		Jitter:     0.5,
+		Budget:     time.Minute,
+	}
+}
+
+// DefaultAuditLogRetryPolicy returns the retry policy of PublishAuditLog, 5 attempts with the delays
+// 500ms, 2s, 8s, 32s, each ± 50%.
+func DefaultAuditLogRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:     5,
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     time.Minute,
+		Multiplier:      4,
+		Jitter:          0.5,
+		Budget:          15 * time.Minute,
+	}
+}
+
+// DefaultSubscribeRetryPolicy returns the retry policy of the events failed by the subscriber callback.
+// The event is delivered again every second until the callback succeeds.
+func DefaultSubscribeRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		InitialInterval: time.Second,
+		Multiplier:      1,
+	}
+}
+
+// DefaultFetchRetryPolicy returns the retry policy of the subscriber fetch errors, e.g. while the consumer group
+// is rebalancing. The fetch is retried every 200ms.
+func DefaultFetchRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		InitialInterval: 200 * time.Millisecond,
+		Multiplier:      1,
+	}
+}
+
+// retryPolicyOrDefault returns the policy, or the default one if it's nil
+func retryPolicyOrDefault(policy *RetryPolicy, defaultPolicy func() *RetryPolicy) *RetryPolicy {
+	if policy == nil {
+		return defaultPolicy()
+	}
+
+	return policy
+}
+
+// withBudget returns a copy of the policy with another budget
+func (policy *RetryPolicy) withBudget(budget time.Duration) *RetryPolicy {
+	withBudget := *policy
+	withBudget.Budget = budget
+
+	return &withBudget
+}
+
+// retryContext returns a context done once the budget of the policy is spent
+func (policy *RetryPolicy) retryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if policy.Budget > 0 {
+		return context.WithTimeout(ctx, policy.Budget)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// retrier counts the failed attempts of an operation and returns the delays before the next ones
+type retrier struct {
+	policy  *RetryPolicy
+	attempt int
+	backOff *backoff.ExponentialBackOff
+}
+
+// newRetrier creates a retrier, the budget of the policy starts now
+func newRetrier(policy *RetryPolicy) *retrier {
+	backOff := &backoff.ExponentialBackOff{
+		InitialInterval:     policy.InitialInterval,
+		RandomizationFactor: math.Min(math.Max(policy.Jitter, 0), 1),
+		Multiplier:          math.Max(policy.Multiplier, 1),
+		MaxInterval:         policy.MaxInterval,
+		MaxElapsedTime:      policy.Budget,
+		Clock:               backoff.SystemClock,
+	}
+	if backOff.MaxInterval <= 0 {
+		backOff.MaxInterval = math.MaxInt64
+	}
+	backOff.Reset()
+
+	return &retrier{
+		policy:  policy,
+		backOff: backOff,
+	}
+}
+
+// next counts the failed attempt and returns the delay before the next one, false if the policy gives up
+func (r *retrier) next(err error) (time.Duration, bool) {
+	r.attempt++
+
+	// the writers of a closed client are closed, it won't succeed anymore
+	if errors.Is(err, ErrClientClosed) {
+		return 0, false
+	}
+
+	if r.policy.Retryable != nil && !r.policy.Retryable(err) {
+		return 0, false
+	}
+
+	if r.policy.MaxAttempts > 0 && r.attempt >= r.policy.MaxAttempts {
+		return 0, false
+	}
+
+	delay := r.backOff.NextBackOff()
+	if delay == backoff.Stop {
+		return 0, false
+	}
+
+	// the next attempt would start after the budget
+	if r.policy.Budget > 0 && r.backOff.GetElapsedTime()+delay > r.policy.Budget {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// retry calls the operation until it succeeds, the policy gives up or ctx is done. notify is called before every
+// retry with the number of the failed attempt. It returns the error of the last attempt.
+func retry(
+	ctx context.Context,
+	policy *RetryPolicy,
+	operation func() error,
+	notify func(err error, attempt int, delay time.Duration),
+) error {
+	r := newRetrier(policy)
+
+	for {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := r.next(err)
+		if !ok {
+			return err
+		}
+
+		notify(err, r.attempt, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errRetryTest = errors.New("retry test error")
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+	}{
+		{
+			name:     "max attempts",
+			policy:   RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 2},
+			attempts: 3,
+		},
+		{
+			name: "not retryable",
+			policy: RetryPolicy{InitialInterval: time.Millisecond, Retryable: func(err error) bool {
+				return !errors.Is(err, errRetryTest)
+			}},
+			attempts: 1,
+		},
+		{
+			name:     "budget",
+			policy:   RetryPolicy{InitialInterval: 30 * time.Millisecond, Multiplier: 1, Budget: 50 * time.Millisecond},
+			attempts: 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			attempts := 0
+			var notified []int
+			err := retry(context.Background(), &testCase.policy, func() error {
+				attempts++
+				return errRetryTest
+			}, func(err error, attempt int, delay time.Duration) {
+				notified = append(notified, attempt)
+			})
+
+			assert.ErrorIs(t, err, errRetryTest)
+			assert.Equal(t, testCase.attempts, attempts)
+			assert.Len(t, notified, testCase.attempts-1, "every retry should be notified")
+		})
+	}
+}
+
+func TestRetryPolicyIntervals(t *testing.T) {
+	t.Parallel()
+
+	r := newRetrier(&RetryPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 4})
+
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		delay, ok := r.next(errRetryTest)
+		require.True(t, ok)
+		delays = append(delays, delay)
+	}
+
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 400 * time.Millisecond, time.Second, time.Second}, delays)
+	assert.Equal(t, 4, r.attempt)
+}
+
+func TestPublishRetryPolicy(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, err := NewClient(prefix, eventStreamKafka, []string{"invalidbroker:9092"}, &BrokerConfig{
+		DialTimeout:        100 * time.Millisecond,
+		PublishRetryPolicy: &RetryPolicy{MaxAttempts: 3, InitialInterval: 10 * time.Millisecond, Multiplier: 1},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	result, err := client.PublishAsync(NewPublish().Topic(constructTopicTest()).EventName("testEvent"))
+	require.NoError(t, err)
+
+	reports, err := result.Wait(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 3, reports[0].Attempts, "the client policy should be used")
+
+	result, err = client.PublishAsync(
+		NewPublish().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			RetryPolicy(&RetryPolicy{MaxAttempts: 1}))
+	require.NoError(t, err)
+
+	reports, err = result.Wait(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, reports[0].Attempts, "the publish builder policy should override the client one")
+}
+
+func TestSubscribeRetryPolicyGivesUp(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	for i := 0; i < 2; i++ {
+		err := client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+	}
+
+	deliveries := make(chan int, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			RetryPolicy(&RetryPolicy{MaxAttempts: 3, InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				deliveries <- event.EventID
+				if event.EventID == 0 {
+					return errRetryTest
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	var delivered []int
+	for len(delivered) < 4 {
+		select {
+		case eventID := <-deliveries:
+			delivered = append(delivered, eventID)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "delivered: %v", delivered)
+		}
+	}
+
+	assert.Equal(t, []int{0, 0, 0, 1}, delivered, "the failed event should be skipped after 3 attempts")
+}
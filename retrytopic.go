@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// headers describing the original message of an event published to a retry topic
+const (
+	HeaderRetryError     = "retry-error"              // error of the last attempt
+	HeaderRetryAttempts  = "retry-attempts"           // number of failed attempts
+	HeaderRetryTopic     = "retry-original-topic"     // topic of the original message
+	HeaderRetryPartition = "retry-original-partition" // partition of the original message
+	HeaderRetryOffset    = "retry-original-offset"    // offset of the original message
+)
+
+// RetryTopicName returns the retry topic of a topic for a delay, e.g. topic.retry.5s
+func RetryTopicName(topic string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", topic, formatRetryDelay(delay))
+}
+
+// formatRetryDelay formats the delay with its largest whole unit
+func formatRetryDelay(delay time.Duration) string {
+	switch {
+	case delay%time.Hour == 0:
+		return fmt.Sprintf("%dh", delay/time.Hour)
+	case delay%time.Minute == 0:
+		return fmt.Sprintf("%dm", delay/time.Minute)
+	case delay%time.Second == 0:
+		return fmt.Sprintf("%ds", delay/time.Second)
+	default:
+		return fmt.Sprintf("%dms", delay.Milliseconds())
+	}
+}
+
+// retryTopic is a retry topic of a subscriber, its events are delivered again once the delay has passed
+type retryTopic struct {
+	topic string // without prefix
+	delay time.Duration
+}
+
+// retryTopics returns the retry topics of the subscriber, in the order the failed events go through them
+func (s *SubscribeBuilder) retryTopics() []retryTopic {
+	if s.retryParent != nil {
+		return s.retryParent.retryTopics()
+	}
+
+	topics := make([]retryTopic, 0, len(s.retryDelays))
+	for _, delay := range s.retryDelays {
+		topics = append(topics, retryTopic{topic: RetryTopicName(s.topic, delay), delay: delay})
+	}
+
+	return topics
+}
+
+// retryTopicBuilder returns the builder of the internal subscriber of a retry topic
+func (s *SubscribeBuilder) retryTopicBuilder(tier int, ctx context.Context) *SubscribeBuilder {
+	builder := *s
+	builder.topic = s.retryTopics()[tier].topic
+	builder.offset = kafka.FirstOffset
+	builder.startAt = SeekPosition{}
+	builder.onPartitionsAssigned = nil
+	builder.onPartitionsRevoked = nil
+	builder.ctx = ctx
+	builder.retryTier = tier + 1
+	builder.retryParent = s
+
+	return &builder
+}
+
+// retryTopicDelay returns the delay of the retry topic consumed by the subscriber, 0 for the subscribed topic
+func (s *SubscribeBuilder) retryTopicDelay() time.Duration {
+	if s.retryTier == 0 {
+		return 0
+	}
+
+	return s.retryTopics()[s.retryTier-1].delay
+}
+
+// forwardFailed returns the topic without prefix and the message to publish an event failed by the callback to:
+// the next retry topic, or the dead letter topic after the last one
+func (s *SubscribeBuilder) forwardFailed(message kafka.Message, topic, groupID string, err error) (string, kafka.Message) {
+	letter := deadLetter{
+		topic:     topic,
+		groupID:   groupID,
+		partition: message.Partition,
+		offset:    message.Offset,
+		attempts:  1,
+		err:       err,
+	}
+
+	// the message is consumed from a retry topic
+	headers := eventHeaders(message.Headers)
+	if originalTopic, ok := headers[HeaderRetryTopic]; ok {
+		letter.topic = originalTopic
+		letter.partition, _ = strconv.Atoi(headers[HeaderRetryPartition])
+		letter.offset, _ = strconv.ParseInt(headers[HeaderRetryOffset], 10, 64)
+		attempts, _ := strconv.Atoi(headers[HeaderRetryAttempts])
+		letter.attempts = attempts + 1
+	}
+
+	retryTopics := s.retryTopics()
+	if s.retryTier < len(retryTopics) {
+		return retryTopics[s.retryTier].topic, newRetryMessage(message, letter)
+	}
+
+	return s.deadLetterTopicName(), newDeadLetterMessage(message, letter)
+}
+
+// newRetryMessage returns the message to publish to a retry topic: the original key, value and headers,
+// with the retry headers replacing the ones of the previous retry topic
+func newRetryMessage(message kafka.Message, letter deadLetter) kafka.Message {
+	errMessage := ""
+	if letter.err != nil {
+		errMessage = letter.err.Error()
+	}
+
+	return replaceHeaders(message, "retry-",
+		kafka.Header{Key: HeaderRetryError, Value: []byte(errMessage)},
+		kafka.Header{Key: HeaderRetryAttempts, Value: []byte(strconv.Itoa(letter.attempts))},
+		kafka.Header{Key: HeaderRetryTopic, Value: []byte(letter.topic)},
+		kafka.Header{Key: HeaderRetryPartition, Value: []byte(strconv.Itoa(letter.partition))},
+		kafka.Header{Key: HeaderRetryOffset, Value: []byte(strconv.FormatInt(letter.offset, 10))},
+	)
+}
+
+// subscribeRetryTopics subscribes the internal subscribers of the retry topics of sub.
+// The returned function unsubscribes them.
+func subscribeRetryTopics(sub *subscription, subscribe func(*SubscribeBuilder) (Subscription, error)) func() {
+	if sub.builder.retryParent != nil || len(sub.builder.retryDelays) == 0 {
+		return func() {}
+	}
+
+	subscriptions := make([]Subscription, 0, len(sub.builder.retryDelays))
+	for tier := range sub.builder.retryDelays {
+		retrySubscription, err := subscribe(sub.builder.retryTopicBuilder(tier, sub.ctx))
+		if err != nil {
+			logrus.
+				WithField("Topic Name", sub.builder.subscribedTopic()).
+				WithField("Event Name", sub.builder.subscribedEventName()).
+				Error("unable to subscribe retry topic: ", err)
+
+			continue
+		}
+
+		subscriptions = append(subscriptions, retrySubscription)
+	}
+
+	return func() {
+		for _, retrySubscription := range subscriptions {
+			_ = retrySubscription.Unsubscribe(context.Background())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTopicName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "topic.retry.500ms", RetryTopicName("topic", 500*time.Millisecond))
+	assert.Equal(t, "topic.retry.5s", RetryTopicName("topic", 5*time.Second))
+	assert.Equal(t, "topic.retry.90s", RetryTopicName("topic", 90*time.Second))
+	assert.Equal(t, "topic.retry.1m", RetryTopicName("topic", time.Minute))
+	assert.Equal(t, "topic.retry.2h", RetryTopicName("topic", 2*time.Hour))
+}
+
+func TestSubscribeRetryTopics(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+
+	createTestTopic(t, topicName)
+	createTestTopic(t, DeadLetterTopicName(topicName))
+	for _, delay := range delays {
+		createTestTopic(t, RetryTopicName(topicName, delay))
+	}
+
+	deadLetters := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(DeadLetterTopicName(topicName)).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					deadLetters <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	type delivery struct {
+		event *Event
+		time  time.Time
+	}
+
+	groupID := generateID()
+	deliveries := make(chan delivery, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			RetryTopics(delays...).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				deliveries <- delivery{event: event, time: time.Now()}
+				if event.EventID == 0 {
+					return errRetryTest
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+	}
+
+	var delivered []delivery
+	for len(delivered) < 4 {
+		select {
+		case d := <-deliveries:
+			delivered = append(delivered, d)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "delivered: %d", len(delivered))
+		}
+	}
+
+	eventIDs := make([]int, 0, len(delivered))
+	for _, d := range delivered {
+		eventIDs = append(eventIDs, d.event.EventID)
+	}
+	assert.Equal(t, []int{0, 1, 0, 0}, eventIDs, "the failed event shouldn't block the next one")
+
+	for i, delay := range delays {
+		retried := delivered[i+2]
+		assert.GreaterOrEqual(t, retried.time.Sub(delivered[i].time), delay, "the event should be retried after the delay")
+		assert.Equal(t, constructTopic(prefix, topicName), retried.event.Headers[HeaderRetryTopic])
+		assert.Equal(t, "0", retried.event.Headers[HeaderRetryOffset])
+		assert.Equal(t, errRetryTest.Error(), retried.event.Headers[HeaderRetryError])
+	}
+	assert.Equal(t, "1", delivered[2].event.Headers[HeaderRetryAttempts])
+	assert.Equal(t, "2", delivered[3].event.Headers[HeaderRetryAttempts])
+
+	select {
+	case event := <-deadLetters:
+		assert.Equal(t, 0, event.EventID)
+		assert.Equal(t, "3", event.Headers[HeaderDeadLetterAttempts])
+		assert.Equal(t, constructGroupID(prefix, groupID), event.Headers[HeaderDeadLetterGroupID])
+		assert.Equal(t, constructTopic(prefix, topicName), event.Headers[HeaderDeadLetterTopic])
+		assert.Equal(t, "0", event.Headers[HeaderDeadLetterOffset])
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscribeRetryTopicsWithoutGroupID(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			RetryTopics(time.Second).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidRetryTopics)
+}
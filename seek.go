@@ -0,0 +1,309 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	errInvalidSeek         = errors.New("seek offsets should not be negative, nor used with multiple topics")
+	errSeekReplaced        = errors.New("seek replaced by a newer seek")
+	errSeekRequested       = errors.New("seek requested")
+	errSubscriptionStopped = errors.New("subscription is stopped")
+)
+
+// SeekPosition is where a subscriber starts consuming, see SubscribeBuilder.StartAt, or where it's moved to while
+// it's running, see Subscription.Seek
+type SeekPosition struct {
+	// Time moves the partitions to their first event published at or after it, or to their end if there's none
+	Time time.Time
+
+	// Offsets moves the partitions to the offsets, they take precedence over Time
+	Offsets map[int]int64
+}
+
+// isZero returns true if the position doesn't move any partition
+func (position SeekPosition) isZero() bool {
+	return position.Time.IsZero() && len(position.Offsets) == 0
+}
+
+// validate returns an error if the position can't be used by the subscriber
+func (position SeekPosition) validate(subscribeBuilder *SubscribeBuilder) error {
+	if len(position.Offsets) > 0 && subscribeBuilder.isMultiTopic() {
+		return errInvalidSeek
+	}
+
+	for _, offset := range position.Offsets {
+		if offset < 0 {
+			return errInvalidSeek
+		}
+	}
+
+	return nil
+}
+
+// offset returns the offset of the partition at the position, from the messages of the partition.
+// It returns false if the partition isn't moved.
+func (position SeekPosition) offset(partition int, messages []kafka.Message) (int64, bool) {
+	if offset, ok := position.Offsets[partition]; ok {
+		if offset > int64(len(messages)) {
+			offset = int64(len(messages))
+		}
+
+		return offset, true
+	}
+
+	if position.Time.IsZero() {
+		return 0, false
+	}
+
+	for _, message := range messages {
+		if !message.Time.Before(position.Time) {
+			return message.Offset, true
+		}
+	}
+
+	return int64(len(messages)), true
+}
+
+// seekRequest is a seek waiting to be applied by the consuming goroutine of a subscription.
+// Seek waits for done, the seek of StartAt has none and is applied again until it succeeds.
+type seekRequest struct {
+	position SeekPosition
+	done     chan error
+}
+
+// finish reports the result of the seek to Seek
+func (request *seekRequest) finish(err error) {
+	if request.done != nil {
+		request.done <- err
+	}
+}
+
+// retried returns true if the seek is applied again after a failure
+func (request *seekRequest) retried() bool {
+	return request.done == nil
+}
+
+// requestSeek queues the seek for the consuming goroutine, interrupting its current fetch, and waits until it's
+// applied. The seek is withdrawn if ctx is done first.
+func (sub *subscription) requestSeek(ctx context.Context, position SeekPosition) error {
+	request := &seekRequest{position: position, done: make(chan error, 1)}
+
+	sub.seekLock.Lock()
+	if sub.pendingSeek != nil {
+		sub.pendingSeek.finish(errSeekReplaced)
+	}
+	sub.pendingSeek = request
+
+	if sub.wakeFetch != nil {
+		sub.wakeFetch()
+	}
+	sub.seekLock.Unlock()
+
+	select {
+	case err := <-request.done:
+		return err
+	case <-sub.done:
+		return errSubscriptionStopped
+	case <-ctx.Done():
+		sub.seekLock.Lock()
+		if sub.pendingSeek == request {
+			sub.pendingSeek = nil
+		}
+		sub.seekLock.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// seekPending returns true if a seek is waiting to be applied
+func (sub *subscription) seekPending() bool {
+	sub.seekLock.Lock()
+	defer sub.seekLock.Unlock()
+
+	return sub.pendingSeek != nil
+}
+
+// takeSeek returns the seek waiting to be applied, nil if there's none
+func (sub *subscription) takeSeek() *seekRequest {
+	sub.seekLock.Lock()
+	defer sub.seekLock.Unlock()
+
+	request := sub.pendingSeek
+	sub.pendingSeek = nil
+
+	return request
+}
+
+// retrySeek queues the failed seek again, unless another one is requested meanwhile
+func (sub *subscription) retrySeek(request *seekRequest) {
+	sub.seekLock.Lock()
+	defer sub.seekLock.Unlock()
+
+	if sub.pendingSeek == nil {
+		sub.pendingSeek = request
+	}
+}
+
+// seekContext returns a context done once a seek is requested, or when parent is done.
+// The consumer fetches the next message with it.
+func (sub *subscription) seekContext(parent context.Context) (context.Context, context.CancelFunc) {
+	sub.seekLock.Lock()
+	defer sub.seekLock.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	if sub.pendingSeek != nil {
+		cancel()
+	}
+
+	sub.wakeFetch = cancel
+
+	return ctx, cancel
+}
+
+// seek resolves the position to the offsets of the partitions of the topics with prefix. With a group ID they're
+// committed for the group, the reader joining it afterwards starts from them.
+func (client *KafkaClient) seek(ctx context.Context, topics []string, groupID string,
+	position SeekPosition) (map[topicPartition]int64, error) {
+	offsets, err := client.seekOffsets(ctx, topics, position)
+	if err != nil || groupID == "" {
+		return offsets, err
+	}
+
+	return offsets, client.commitOffsets(ctx, groupID, offsets)
+}
+
+// seekOffsets resolves the position to the offsets of the partitions of the topics with prefix, the timestamps
+// are resolved by the leaders of the partitions
+func (client *KafkaClient) seekOffsets(ctx context.Context, topics []string,
+	position SeekPosition) (map[topicPartition]int64, error) {
+	conn, err := client.dialBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions, err := conn.ReadPartitions(topics...)
+	_ = conn.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[topicPartition]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, ok := position.Offsets[partition.ID]
+		if !ok {
+			if position.Time.IsZero() {
+				continue
+			}
+
+			offset, err = client.readOffset(ctx, partition, position.Time)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		offsets[topicPartition{topic: partition.Topic, partition: partition.ID}] = offset
+	}
+
+	return offsets, nil
+}
+
+// readOffset returns the offset of the first message of the partition published at or after t, the end of the
+// partition if there's none
+func (client *KafkaClient) readOffset(ctx context.Context, partition kafka.Partition, t time.Time) (int64, error) {
+	conn, err := client.dialer().DialPartition(ctx, "tcp", "", partition)
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close() // nolint: errcheck
+
+	offset, err := conn.ReadOffset(t)
+	if err != nil || offset >= 0 {
+		return offset, err
+	}
+
+	return conn.ReadLastOffset()
+}
+
+// commitOffsets commits the offsets for the group outside of its membership, the group shouldn't have other
+// members then
+func (client *KafkaClient) commitOffsets(ctx context.Context, groupID string, offsets map[topicPartition]int64) error {
+	dialer := client.dialer()
+	transport := &kafka.Transport{
+		DialTimeout: dialer.Timeout,
+		TLS:         dialer.TLS,
+		SASL:        dialer.SASLMechanism,
+	}
+	defer transport.CloseIdleConnections()
+
+	request := &kafka.OffsetCommitRequest{
+		GroupID:      groupID,
+		GenerationID: -1,
+		Topics:       make(map[string][]kafka.OffsetCommit),
+	}
+
+	for key, offset := range offsets {
+		request.Topics[key.topic] = append(request.Topics[key.topic],
+			kafka.OffsetCommit{Partition: key.partition, Offset: offset})
+	}
+
+	kafkaClient := &kafka.Client{Addr: kafka.TCP(client.subscribeConfig.Brokers...), Transport: transport}
+
+	response, err := kafkaClient.OffsetCommit(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	for _, partitions := range response.Topics {
+		for _, partition := range partitions {
+			if partition.Error != nil {
+				return partition.Error
+			}
+		}
+	}
+
+	return nil
+}
+
+// seekMember moves the partitions of the member to the position. The offsets of a group member are committed
+// for the group, and the group is rebalanced so its members fetch from them. client.lock must be held
+func (client *MemoryClient) seekMember(member *memoryMember, position SeekPosition) {
+	for partition, messages := range member.topic.partitions {
+		offset, ok := position.offset(partition, messages)
+		if !ok {
+			continue
+		}
+
+		if member.group != nil {
+			member.group.committed[partition] = offset
+		} else {
+			member.positions[partition] = offset
+		}
+	}
+
+	if member.group != nil {
+		member.topic.rebalance(member.group)
+	}
+}
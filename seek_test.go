@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seekTestOffsets returns the offsets of every partition of the test topics
+func seekTestOffsets(offset int64) map[int]int64 {
+	offsets := make(map[int]int64, memoryPartitionCount)
+	for partition := 0; partition < memoryPartitionCount; partition++ {
+		offsets[partition] = offset
+	}
+
+	return offsets
+}
+
+// publishSeekTestEvents publishes the events with the IDs to the partition of testKey
+func publishSeekTestEvents(t *testing.T, client Client, topicName string, eventIDs ...int) {
+	t.Helper()
+
+	for _, eventID := range eventIDs {
+		err := client.PublishSync(
+			NewPublish().
+				Topic(topicName).
+				EventName("testEvent").
+				Key(testKey).
+				EventID(eventID))
+		require.NoError(t, err)
+	}
+}
+
+// receiveEventIDs returns the IDs of the next n events
+func receiveEventIDs(ctx context.Context, t *testing.T, received <-chan *Event, n int) []int {
+	t.Helper()
+
+	eventIDs := make([]int, 0, n)
+	for len(eventIDs) < n {
+		select {
+		case event := <-received:
+			eventIDs = append(eventIDs, event.EventID)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "received: %v", eventIDs)
+		}
+	}
+
+	return eventIDs
+}
+
+func TestSubscribeStartAt(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	publishSeekTestEvents(t, client, topicName, 0)
+	time.Sleep(50 * time.Millisecond)
+	startAt := time.Now()
+	time.Sleep(50 * time.Millisecond)
+	publishSeekTestEvents(t, client, topicName, 1, 2)
+
+	received := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			StartAt(startAt).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{1, 2}, receiveEventIDs(ctx, t, received, 2))
+}
+
+func TestSubscribeStartAtOffsets(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	publishSeekTestEvents(t, client, topicName, 0, 1, 2)
+
+	received := make(chan *Event, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			StartAtOffsets(seekTestOffsets(2)).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{2}, receiveEventIDs(ctx, t, received, 1), "a subscriber without group should seek too")
+}
+
+func TestSubscriptionSeek(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	publishSeekTestEvents(t, client, topicName, 0, 1, 2)
+
+	received := make(chan *Event, 10)
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{0, 1, 2}, receiveEventIDs(ctx, t, received, 3))
+
+	err = subscription.Seek(ctx, SeekPosition{Offsets: seekTestOffsets(1)})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{1, 2}, receiveEventIDs(ctx, t, received, 2), "the events should be replayed from the seek")
+
+	err = subscription.Seek(ctx, SeekPosition{Offsets: map[int]int64{0: -1}})
+	assert.ErrorIs(t, err, errInvalidSeek)
+}
+
+func TestSubscribeStartAtInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topics(constructTopicTest(), constructTopicTest()).
+			EventName("testEvent").
+			GroupID(generateID()).
+			StartAtOffsets(map[int]int64{0: 1}).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidSeek, "offsets shouldn't be used with multiple topics")
+}
@@ -0,0 +1,812 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	spoolSegmentExt       = ".segment"
+	spoolCheckpointFile   = "checkpoint"
+	spoolRecordHeaderSize = 8 // record length and checksum
+
+	defaultSpoolMaxBytes      = 1 << 30  // 1GB
+	defaultSpoolSegmentBytes  = 64 << 20 // 64MB
+	defaultSpoolFsyncInterval = time.Second
+	defaultSpoolRetryInterval = 5 * time.Second
+)
+
+var (
+	errSpoolFull   = errors.New("spool is full")
+	errSpoolClosed = errors.New("spool is closed")
+)
+
+// SpoolFsyncPolicy defines when the spool segments are synced to disk
+type SpoolFsyncPolicy string
+
+const (
+	// SpoolFsyncAlways syncs after every message, nothing is lost on a crash but it's the slowest
+	SpoolFsyncAlways SpoolFsyncPolicy = "always"
+
+	// SpoolFsyncInterval syncs every SpoolConfig.FsyncInterval
+	SpoolFsyncInterval SpoolFsyncPolicy = "interval"
+
+	// SpoolFsyncNever leaves the syncs to the operating system
+	SpoolFsyncNever SpoolFsyncPolicy = "never"
+)
+
+// SpoolConfig enables the disk spool of the kafka client. The messages that can't be published asynchronously
+// are stored in append-only segment files, and forwarded in order once kafka is reachable again.
+type SpoolConfig struct {
+	Dir           string           // directory of the segment files, required
+	MaxBytes      int64            // size cap of the segments, messages are rejected once reached. default: 1GB
+	SegmentBytes  int64            // size of a segment file before a new one is started. default: 64MB
+	FsyncPolicy   SpoolFsyncPolicy // default: SpoolFsyncInterval
+	FsyncInterval time.Duration    // default: 1s
+	RetryInterval time.Duration    // delay between the forward attempts while kafka is unreachable. default: 5s
+}
+
+// spoolRecord is a message stored in the spool
+type spoolRecord struct {
+	Topic   string         `json:"topic"`
+	Key     []byte         `json:"key,omitempty"`
+	Value   []byte         `json:"value"`
+	Headers []kafka.Header `json:"headers,omitempty"`
+	Time    time.Time      `json:"time"`
+
+	// position of the next record
+	next spoolPosition
+}
+
+// message converts the record back to a kafka message
+func (record spoolRecord) message() kafka.Message {
+	return kafka.Message{
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: record.Headers,
+		Time:    record.Time,
+	}
+}
+
+// spoolPosition is the position of a record in the segments
+type spoolPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// diskSpool is an append-only log of messages split in segment files.
+// The position of the next message to forward is kept in the checkpoint file, fully forwarded segments are deleted.
+type diskSpool struct {
+	config SpoolConfig
+
+	lock     sync.Mutex
+	segments []uint64  // oldest first, messages are appended to the last one
+	file     spoolFile // last segment
+	fileSize int64
+	read     spoolPosition
+	messages int64
+	bytes    int64
+	oldest   time.Time
+	dirty    bool
+	closed   bool
+
+	// updated is closed and replaced when messages are appended
+	updated chan struct{}
+
+	spooled   int64
+	forwarded int64
+	dropped   int64
+	rejected  int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// spoolFile is the segment the messages are appended to, an *os.File
+type spoolFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// openSpool opens the spool in the configured dir, counting the messages left by a previous run
+func openSpool(config SpoolConfig) (*diskSpool, error) {
+	if config.Dir == "" {
+		return nil, errors.New("spool dir is required")
+	}
+
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultSpoolMaxBytes
+	}
+
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = defaultSpoolSegmentBytes
+	}
+
+	if config.FsyncPolicy == "" {
+		config.FsyncPolicy = SpoolFsyncInterval
+	}
+
+	if config.FsyncInterval <= 0 {
+		config.FsyncInterval = defaultSpoolFsyncInterval
+	}
+
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultSpoolRetryInterval
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create spool dir: %w", err)
+	}
+
+	spool := &diskSpool{
+		config:  config,
+		updated: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := spool.recover(); err != nil {
+		return nil, err
+	}
+
+	if config.FsyncPolicy == SpoolFsyncInterval {
+		spool.wg.Add(1)
+		go spool.syncLoop()
+	}
+
+	return spool, nil
+}
+
+// recover loads the segments and the checkpoint, and counts the messages to forward.
+// An incomplete record at the end of the last segment, e.g. after a crash, is truncated.
+func (spool *diskSpool) recover() error {
+	paths, err := filepath.Glob(filepath.Join(spool.config.Dir, "*"+spoolSegmentExt))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		spool.segments = append(spool.segments, id)
+	}
+
+	sort.Slice(spool.segments, func(i, j int) bool { return spool.segments[i] < spool.segments[j] })
+
+	if len(spool.segments) == 0 {
+		spool.segments = []uint64{1}
+	}
+
+	spool.read = spoolPosition{Segment: spool.segments[0]}
+
+	checkpoint, err := os.ReadFile(filepath.Join(spool.config.Dir, spoolCheckpointFile))
+	if err == nil {
+		var position spoolPosition
+		if err = json.Unmarshal(checkpoint, &position); err != nil {
+			logrus.Warn("ignoring invalid spool checkpoint: ", err)
+		} else if position.Segment >= spool.segments[0] {
+			spool.read = position
+		}
+	}
+
+	// remove the segments forwarded before the checkpoint
+	for len(spool.segments) > 1 && spool.segments[0] < spool.read.Segment {
+		if err = os.Remove(spool.segmentPath(spool.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		spool.segments = spool.segments[1:]
+	}
+
+	if spool.read.Segment != spool.segments[0] {
+		spool.read = spoolPosition{Segment: spool.segments[0]}
+	}
+
+	for i, id := range spool.segments {
+		offset := int64(0)
+		if id == spool.read.Segment {
+			offset = spool.read.Offset
+		}
+
+		records, end, err := spool.readSegment(id, offset, -1)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		spool.messages += int64(len(records))
+		if spool.oldest.IsZero() && len(records) > 0 {
+			spool.oldest = records[0].Time
+		}
+
+		last := i == len(spool.segments)-1
+		if last {
+			spool.file, err = os.OpenFile(spool.segmentPath(id), os.O_CREATE|os.O_RDWR, 0o644)
+			if err != nil {
+				return err
+			}
+
+			// drop the incomplete record left by a crash
+			if err = spool.file.Truncate(end); err != nil {
+				return err
+			}
+
+			if _, err = spool.file.Seek(end, io.SeekStart); err != nil {
+				return err
+			}
+
+			spool.fileSize = end
+			spool.bytes += end
+
+			continue
+		}
+
+		info, err := os.Stat(spool.segmentPath(id))
+		if err == nil {
+			spool.bytes += info.Size()
+		}
+	}
+
+	if spool.messages > 0 {
+		logrus.Infof("%d messages left in the spool", spool.messages)
+	}
+
+	return nil
+}
+
+// segmentPath returns the path of a segment file
+func (spool *diskSpool) segmentPath(id uint64) string {
+	return filepath.Join(spool.config.Dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// readSegment reads up to max records (all if max is negative) of the segment from the offset.
+// It stops at the first invalid record and returns the offset after the last valid one.
+func (spool *diskSpool) readSegment(id uint64, offset int64, max int) ([]spoolRecord, int64, error) {
+	file, err := os.Open(spool.segmentPath(id))
+	if err != nil {
+		return nil, offset, err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, spoolRecordHeaderSize)
+	records := make([]spoolRecord, 0)
+
+	for max < 0 || len(records) < max {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			break
+		}
+
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+
+		if int64(length) > spool.config.MaxBytes {
+			break
+		}
+
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			break
+		}
+
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		var record spoolRecord
+		if err = json.Unmarshal(payload, &record); err != nil {
+			break
+		}
+
+		offset += spoolRecordHeaderSize + int64(length)
+		record.next = spoolPosition{Segment: id, Offset: offset}
+		records = append(records, record)
+	}
+
+	return records, offset, nil
+}
+
+// append stores a message at the end of the spool
+func (spool *diskSpool) append(topic string, message kafka.Message) error {
+	record := spoolRecord{
+		Topic:   topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: message.Headers,
+		Time:    message.Time,
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, spoolRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[spoolRecordHeaderSize:], payload)
+
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	if spool.closed {
+		return errSpoolClosed
+	}
+
+	if spool.bytes+int64(len(data)) > spool.config.MaxBytes {
+		spool.rejected++
+		return errSpoolFull
+	}
+
+	if spool.fileSize > 0 && spool.fileSize+int64(len(data)) > spool.config.SegmentBytes {
+		if err = spool.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = spool.file.Write(data); err != nil {
+		// drop the partial record, the next message is appended at the same offset
+		spool.truncate()
+		return err
+	}
+
+	if spool.config.FsyncPolicy == SpoolFsyncAlways {
+		if err = spool.file.Sync(); err != nil {
+			// drop the record, it's reported as not spooled so it isn't replayed
+			spool.truncate()
+			return err
+		}
+	} else {
+		spool.dirty = true
+	}
+
+	spool.fileSize += int64(len(data))
+	spool.bytes += int64(len(data))
+	spool.spooled++
+	spool.messages++
+	if spool.messages == 1 {
+		spool.oldest = record.Time
+	}
+
+	close(spool.updated)
+	spool.updated = make(chan struct{})
+
+	return nil
+}
+
+// truncate drops what was written after the last record of the segment, spool.lock must be held
+func (spool *diskSpool) truncate() {
+	_ = spool.file.Truncate(spool.fileSize)
+	_, _ = spool.file.Seek(spool.fileSize, io.SeekStart)
+}
+
+// rotate starts a new segment, spool.lock must be held
+func (spool *diskSpool) rotate() error {
+	if err := spool.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := spool.file.Close(); err != nil {
+		return err
+	}
+
+	id := spool.segments[len(spool.segments)-1] + 1
+
+	file, err := os.OpenFile(spool.segmentPath(id), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	spool.segments = append(spool.segments, id)
+	spool.file = file
+	spool.fileSize = 0
+	spool.dirty = false
+
+	return nil
+}
+
+// next returns up to max records to forward, without removing them from the spool.
+// It also returns a channel closed when new messages are appended, to wait for them when the spool is empty.
+func (spool *diskSpool) next(max int) ([]spoolRecord, <-chan struct{}, error) {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	if spool.closed {
+		return nil, nil, errSpoolClosed
+	}
+
+	for {
+		records, _, err := spool.readSegment(spool.read.Segment, spool.read.Offset, max)
+		if err != nil {
+			return nil, spool.updated, err
+		}
+
+		if len(records) > 0 {
+			spool.oldest = records[0].Time
+			return records, spool.updated, nil
+		}
+
+		if spool.read.Segment == spool.segments[len(spool.segments)-1] {
+			return nil, spool.updated, nil
+		}
+
+		// the segment is fully forwarded
+		if err = spool.removeFirstSegment(); err != nil {
+			return nil, spool.updated, err
+		}
+	}
+}
+
+// commit removes the forwarded records from the spool, up to the position after the last of them
+func (spool *diskSpool) commit(next spoolPosition, forwarded, dropped int) error {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	if spool.closed {
+		return errSpoolClosed
+	}
+
+	spool.read = next
+	spool.messages -= int64(forwarded + dropped)
+	spool.forwarded += int64(forwarded)
+	spool.dropped += int64(dropped)
+
+	if spool.messages == 0 {
+		spool.oldest = time.Time{}
+
+		// start over in an empty segment to reclaim the space
+		if spool.fileSize > 0 {
+			if err := spool.rotate(); err != nil {
+				return err
+			}
+		}
+
+		for len(spool.segments) > 1 {
+			if err := spool.removeFirstSegment(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return spool.writeCheckpoint()
+}
+
+// removeFirstSegment deletes the oldest segment and moves the read position to the next one,
+// spool.lock must be held
+func (spool *diskSpool) removeFirstSegment() error {
+	path := spool.segmentPath(spool.segments[0])
+
+	info, err := os.Stat(path)
+	if err == nil {
+		spool.bytes -= info.Size()
+	}
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	spool.segments = spool.segments[1:]
+	spool.read = spoolPosition{Segment: spool.segments[0]}
+
+	return spool.writeCheckpoint()
+}
+
+// writeCheckpoint saves the read position, spool.lock must be held
+func (spool *diskSpool) writeCheckpoint() error {
+	data, err := json.Marshal(spool.read)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(spool.config.Dir, spoolCheckpointFile)
+	tmpPath := path + ".tmp"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil && spool.config.FsyncPolicy != SpoolFsyncNever {
+		err = file.Sync()
+	}
+
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// pending returns true if messages are waiting to be forwarded
+func (spool *diskSpool) pending() bool {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	return spool.messages > 0
+}
+
+// stats returns the spool stats
+func (spool *diskSpool) stats() kafkaprometheus.SpoolStats {
+	spool.lock.Lock()
+	defer spool.lock.Unlock()
+
+	stats := kafkaprometheus.SpoolStats{
+		Messages:  spool.messages,
+		Bytes:     spool.bytes,
+		Spooled:   spool.spooled,
+		Forwarded: spool.forwarded,
+		Dropped:   spool.dropped,
+		Rejected:  spool.rejected,
+	}
+
+	if spool.messages > 0 && !spool.oldest.IsZero() {
+		stats.OldestAge = time.Since(spool.oldest)
+	}
+
+	return stats
+}
+
+// syncLoop syncs the last segment every fsync interval
+func (spool *diskSpool) syncLoop() {
+	defer spool.wg.Done()
+
+	ticker := time.NewTicker(spool.config.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			spool.lock.Lock()
+			if spool.dirty && !spool.closed {
+				if err := spool.file.Sync(); err != nil {
+					logrus.Warn("unable to sync spool segment: ", err)
+				}
+				spool.dirty = false
+			}
+			spool.lock.Unlock()
+		case <-spool.done:
+			return
+		}
+	}
+}
+
+// close syncs and closes the last segment, the messages left are forwarded by the next run
+func (spool *diskSpool) close() error {
+	spool.lock.Lock()
+	if spool.closed {
+		spool.lock.Unlock()
+		return nil
+	}
+	spool.closed = true
+
+	err := spool.file.Sync()
+	if errClose := spool.file.Close(); err == nil {
+		err = errClose
+	}
+
+	if errCheckpoint := spool.writeCheckpoint(); err == nil {
+		err = errCheckpoint
+	}
+	spool.lock.Unlock()
+
+	close(spool.done)
+	spool.wg.Wait()
+
+	return err
+}
+
+const (
+	spoolForwardBatchSize = 100
+	spoolForwardTimeout   = 10 * time.Second
+)
+
+// forwardSpool publishes the spooled messages in order until the client is closed.
+// Consecutive messages of the same topic are written together, and removed from the spool once written.
+func (client *KafkaClient) forwardSpool(ctx context.Context) {
+	defer client.spoolForwarder.Done()
+
+	config := client.publishConfig
+
+	for {
+		records, updated, err := client.spool.next(spoolForwardBatchSize)
+		if err != nil {
+			if errors.Is(err, errSpoolClosed) {
+				return
+			}
+
+			logrus.Error("unable to read spool: ", err)
+		}
+
+		if err == nil && len(records) == 0 {
+			select {
+			case <-updated:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err == nil {
+			err = client.forwardRecords(ctx, config, records)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		logrus.Warn("unable to forward spooled messages, retrying: ", err)
+
+		select {
+		case <-time.After(client.spool.config.RetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardRecords publishes the records, committing every run of records of the same topic once written.
+// The records that kafka rejects for good are dropped, otherwise the spool would be stuck on them.
+func (client *KafkaClient) forwardRecords(ctx context.Context, config kafka.WriterConfig, records []spoolRecord) error {
+	for len(records) > 0 {
+		run := 1
+		for run < len(records) && records[run].Topic == records[0].Topic {
+			run++
+		}
+
+		messages := make([]kafka.Message, 0, run)
+		for _, record := range records[:run] {
+			messages = append(messages, record.message())
+		}
+
+		topic := records[0].Topic
+		forwarded, dropped := run, 0
+
+		writeCtx, cancel := context.WithTimeout(ctx, spoolForwardTimeout)
+		err := client.publishEvent(writeCtx, topic, "", config, messages...)
+		cancel()
+
+		if err != nil {
+			if isSpoolable(err) {
+				return err
+			}
+
+			logrus.
+				WithField("Topic Name", topic).
+				WithField("Event Count", run).
+				Error("dropping spooled messages rejected by kafka: ", err)
+
+			forwarded, dropped = 0, run
+		}
+
+		if err = client.spool.commit(records[run-1].next, forwarded, dropped); err != nil {
+			return err
+		}
+
+		logrus.
+			WithField("Topic Name", topic).
+			WithField("Event Count", forwarded).
+			Debug("forwarded spooled messages")
+
+		records = records[run:]
+	}
+
+	return nil
+}
+
+// spoolFirst stores the message in the spool if messages are already waiting there, to keep them in order.
+// It returns false if the message has to be published.
+func (client *KafkaClient) spoolFirst(topic string, message kafka.Message) bool {
+	if client.spool == nil || !client.spool.pending() {
+		return false
+	}
+
+	return client.spoolMessage(topic, message, nil)
+}
+
+// spoolMessage stores a message that couldn't be published in the spool.
+// It returns false if there is no spool, the error isn't worth retrying or the spool can't take the message.
+func (client *KafkaClient) spoolMessage(topic string, message kafka.Message, publishErr error) bool {
+	if client.spool == nil || (publishErr != nil && !isSpoolable(publishErr)) {
+		return false
+	}
+
+	message.WriterData = nil
+
+	if err := client.spool.append(topic, message); err != nil {
+		logrus.
+			WithField("Topic Name", topic).
+			Error("unable to spool message: ", err)
+
+		return false
+	}
+
+	logrus.
+		WithField("Topic Name", topic).
+		Debug("spooled message: ", publishErr)
+
+	return true
+}
+
+// GetSpoolStats returns the stats of the disk spool, empty if it isn't enabled
+func (client *KafkaClient) GetSpoolStats() kafkaprometheus.SpoolStats {
+	if client.spool == nil {
+		return kafkaprometheus.SpoolStats{}
+	}
+
+	return client.spool.stats()
+}
+
+// isSpoolable returns false for the errors that publishing again won't fix, like a message too large
+func isSpoolable(err error) bool {
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+
+	var tooLargeErr kafka.MessageTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return false
+	}
+
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, writeErr := range writeErrors {
+			if writeErr != nil && !isSpoolable(writeErr) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestSpool(t *testing.T, config SpoolConfig) *diskSpool {
+	t.Helper()
+
+	spool, err := openSpool(config)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = spool.close()
+	})
+
+	return spool
+}
+
+func appendSpoolMessages(t *testing.T, spool *diskSpool, topic string, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		err := spool.append(topic, kafka.Message{
+			Key:   []byte(testKey),
+			Value: []byte(fmt.Sprintf("value-%d", i)),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestSpoolForwardInOrder(t *testing.T) {
+	t.Parallel()
+	config := SpoolConfig{Dir: t.TempDir(), SegmentBytes: 256, FsyncPolicy: SpoolFsyncAlways}
+
+	spool := openTestSpool(t, config)
+	appendSpoolMessages(t, spool, "topic", 0, 10)
+
+	segments, err := filepath.Glob(filepath.Join(config.Dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	assert.Greater(t, len(segments), 1, "segments should be rotated")
+
+	stats := spool.stats()
+	assert.Equal(t, int64(10), stats.Messages)
+	assert.Equal(t, int64(10), stats.Spooled)
+	assert.Positive(t, stats.Bytes)
+	assert.Positive(t, stats.OldestAge)
+
+	records, _, err := spool.next(4)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Equal(t, "value-0", string(records[0].Value))
+	assert.Equal(t, "topic", records[0].Topic)
+	assert.Equal(t, testKey, string(records[0].Key))
+
+	require.NoError(t, spool.commit(records[len(records)-1].next, len(records), 0))
+	forwarded := len(records)
+	forwardedBeforeRestart := forwarded
+
+	// the messages left are forwarded after a restart
+	require.NoError(t, spool.close())
+	spool = openTestSpool(t, config)
+	assert.Equal(t, int64(10-forwarded), spool.stats().Messages)
+
+	for forwarded < 10 {
+		records, _, err = spool.next(100)
+		require.NoError(t, err)
+		require.NotEmpty(t, records)
+
+		for _, record := range records {
+			assert.Equal(t, fmt.Sprintf("value-%d", forwarded), string(record.Value))
+			forwarded++
+		}
+
+		require.NoError(t, spool.commit(records[len(records)-1].next, len(records), 0))
+	}
+
+	records, updated, err := spool.next(100)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	stats = spool.stats()
+	assert.Zero(t, stats.Messages)
+	assert.Zero(t, stats.Bytes)
+	assert.Zero(t, stats.OldestAge)
+	assert.Equal(t, int64(10-forwardedBeforeRestart), stats.Forwarded, "stats are counted since the spool is opened")
+
+	segments, err = filepath.Glob(filepath.Join(config.Dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	assert.Len(t, segments, 1, "forwarded segments should be deleted")
+
+	appendSpoolMessages(t, spool, "topic", 10, 11)
+
+	select {
+	case <-updated:
+	default:
+		assert.Fail(t, "append should notify the forwarder")
+	}
+}
+
+func TestSpoolRecoverIncompleteRecord(t *testing.T) {
+	t.Parallel()
+	config := SpoolConfig{Dir: t.TempDir()}
+
+	spool := openTestSpool(t, config)
+	appendSpoolMessages(t, spool, "topic", 0, 2)
+	require.NoError(t, spool.close())
+
+	segments, err := filepath.Glob(filepath.Join(config.Dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// a record partially written before a crash
+	file, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 1, 0, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	spool = openTestSpool(t, config)
+	assert.Equal(t, int64(2), spool.stats().Messages)
+
+	appendSpoolMessages(t, spool, "topic", 2, 3)
+
+	records, _, err := spool.next(100)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "value-2", string(records[2].Value))
+}
+
+// failingSyncFile is a segment failing to sync the records written to it
+type failingSyncFile struct {
+	spoolFile
+}
+
+func (file failingSyncFile) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestSpoolSyncFailure(t *testing.T) {
+	t.Parallel()
+	spool := openTestSpool(t, SpoolConfig{Dir: t.TempDir(), FsyncPolicy: SpoolFsyncAlways})
+
+	appendSpoolMessages(t, spool, "topic", 0, 1)
+	bytes := spool.stats().Bytes
+
+	spool.lock.Lock()
+	segment := spool.file
+	spool.file = failingSyncFile{spoolFile: segment}
+	spool.lock.Unlock()
+
+	err := spool.append("topic", kafka.Message{Key: []byte(testKey), Value: []byte("failed")})
+	assert.Error(t, err)
+
+	spool.lock.Lock()
+	spool.file = segment
+	spool.lock.Unlock()
+
+	stats := spool.stats()
+	assert.Equal(t, int64(1), stats.Messages)
+	assert.Equal(t, bytes, stats.Bytes)
+
+	appendSpoolMessages(t, spool, "topic", 1, 2)
+
+	records, _, err := spool.next(100)
+	require.NoError(t, err)
+	require.Len(t, records, 2, "the record failed to sync shouldn't be replayed")
+	assert.Equal(t, "value-1", string(records[1].Value))
+}
+
+func TestSpoolFull(t *testing.T) {
+	t.Parallel()
+	spool := openTestSpool(t, SpoolConfig{Dir: t.TempDir(), MaxBytes: 200})
+
+	appendSpoolMessages(t, spool, "topic", 0, 1)
+
+	err := spool.append("topic", kafka.Message{Value: make([]byte, 200)})
+	assert.ErrorIs(t, err, errSpoolFull)
+	assert.Equal(t, int64(1), spool.stats().Rejected)
+	assert.Equal(t, int64(1), spool.stats().Messages)
+}
+
+func TestKafkaSpoolForward(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream can't fail to publish")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	spoolConfig := &SpoolConfig{Dir: t.TempDir(), RetryInterval: 100 * time.Millisecond}
+	topicName := constructTopicTest()
+
+	// kafka is unreachable, the events are spooled
+	invalidClient, err := NewClient(prefix, eventStreamKafka, []string{"invalidbroker:9092"}, &BrokerConfig{
+		DialTimeout: 100 * time.Millisecond,
+		SpoolConfig: spoolConfig,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		result, err := invalidClient.PublishAsync(
+			NewPublish().
+				Topic(topicName).
+				EventName("testEvent").
+				Key(testKey).
+				EventID(i).
+				Timeout(time.Second).
+				ErrorCallback(func(event *Event, err error) {
+					assert.Fail(t, "spooled event shouldn't call the error callback", err)
+				}))
+		require.NoError(t, err)
+
+		reports, err := result.Wait(ctx)
+		require.NoError(t, err)
+		assert.True(t, reports[0].Spooled)
+	}
+
+	assert.Equal(t, int64(3), invalidClient.(*KafkaClient).GetSpoolStats().Messages)
+	require.NoError(t, invalidClient.Close(ctx))
+
+	// the next client forwards the spooled events in order
+	createTestTopic(t, topicName)
+
+	client, err := NewClient(prefix, eventStreamKafka, testBrokers(), &BrokerConfig{
+		DialTimeout: 2 * time.Second,
+		SpoolConfig: spoolConfig,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	received := make(chan *Event, 3)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-received:
+			assert.Equal(t, i, event.EventID, "spooled events should be forwarded in order")
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		stats := client.(*KafkaClient).GetSpoolStats()
+		return stats.Messages == 0 && stats.Forwarded == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -17,6 +17,7 @@
 package eventstream
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -43,9 +44,26 @@ func (client *StdoutClient) PublishSync(publishBuilder *PublishBuilder) error {
 
 // Publish print event to console
 func (client *StdoutClient) Publish(publishBuilder *PublishBuilder) error {
+	_, err := client.PublishAsync(publishBuilder)
+	return err
+}
+
+// PublishAsync print event to console, the returned result is already completed without any report
+func (client *StdoutClient) PublishAsync(publishBuilder *PublishBuilder) (PublishResult, error) {
 	if publishBuilder == nil {
 		logrus.Error("unable to publish nil event")
-		return errors.New("unable to publish nil event")
+		return nil, errors.New("unable to publish nil event")
+	}
+
+	if publishBuilder.message != nil {
+		_, event, err := ConstructEvent(publishBuilder)
+		if err != nil {
+			logrus.Errorf("unable to unmarshal event : %s , error : %v", publishBuilder.eventName, err)
+		}
+
+		fmt.Println(string(publishBuilder.message.Value))
+
+		return newPublishResult(event, 0), nil
 	}
 
 	event := &Event{
@@ -77,19 +95,41 @@ func (client *StdoutClient) Publish(publishBuilder *PublishBuilder) error {
 
 	fmt.Println(string(eventByte))
 
-	return nil
+	return newPublishResult(event, 0), nil
+}
+
+// PublishBatch print events to console
+func (client *StdoutClient) PublishBatch(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	return client.PublishBatchSync(publishBuilders...)
+}
+
+// PublishBatchSync print events to console
+func (client *StdoutClient) PublishBatchSync(publishBuilders ...*PublishBuilder) ([]BatchResult, error) {
+	results := make([]BatchResult, len(publishBuilders))
+	for i, publishBuilder := range publishBuilders {
+		results[i].Index = i
+		results[i].Err = client.Publish(publishBuilder)
+	}
+
+	return results, batchError(results)
 }
 
 // Register print event to console
 func (client *StdoutClient) Register(subscribeBuilder *SubscribeBuilder) error {
+	_, err := client.Subscribe(subscribeBuilder)
+	return err
+}
+
+// Subscribe print event to console, the returned subscription doesn't receive any event
+func (client *StdoutClient) Subscribe(subscribeBuilder *SubscribeBuilder) (Subscription, error) {
 	subscribe := struct {
 		Topic     string    `json:"topic"`
 		EventName string    `json:"name"`
 		Timestamp time.Time `json:"timestamp"`
 		Version   int       `json:"version"`
 	}{
-		Topic:     subscribeBuilder.topic,
-		EventName: subscribeBuilder.eventName,
+		Topic:     subscribeBuilder.subscribedTopic(),
+		EventName: subscribeBuilder.subscribedEventName(),
 		Version:   defaultVersion,
 		Timestamp: time.Now().UTC(),
 	}
@@ -101,7 +141,7 @@ func (client *StdoutClient) Register(subscribeBuilder *SubscribeBuilder) error {
 
 	fmt.Println(string(eventByte))
 
-	return nil
+	return newIdleSubscription(subscribeBuilder), nil
 }
 
 func (client *StdoutClient) PublishAuditLog(auditLogBuilder *AuditLogBuilder) error {
@@ -113,3 +153,31 @@ func (client *StdoutClient) PublishAuditLog(auditLogBuilder *AuditLogBuilder) er
 	fmt.Println(string(message.Value))
 	return nil
 }
+
+// Deliveries returns nil, stdout client doesn't report deliveries
+func (client *StdoutClient) Deliveries() <-chan DeliveryReport {
+	return nil
+}
+
+// PauseAll do nothing, stdout client doesn't consume any event
+func (client *StdoutClient) PauseAll() {
+}
+
+// ResumeAll do nothing, stdout client doesn't consume any event
+func (client *StdoutClient) ResumeAll() {
+}
+
+// Health returns a healthy report without checks, stdout client doesn't connect to any broker
+func (client *StdoutClient) Health(ctx context.Context) HealthReport {
+	return newHealthReport()
+}
+
+// Describe returns an empty description, stdout client doesn't write to nor consume any topic
+func (client *StdoutClient) Describe() ClientDescription {
+	return ClientDescription{Writers: []WriterDescription{}, Subscriptions: []SubscriptionDescription{}}
+}
+
+// Close do nothing, stdout client doesn't hold any resources
+func (client *StdoutClient) Close(ctx context.Context) error {
+	return nil
+}
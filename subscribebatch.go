@@ -0,0 +1,238 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatchSize    = 100         // events of a CallbackBatch batch
+	defaultBatchTimeout = time.Second // time to fill a CallbackBatch batch
+)
+
+// PartialBatchError is returned by a CallbackBatch callback when only some events of the batch failed.
+// The failed events are delivered again, the other ones are committed with them.
+type PartialBatchError struct {
+	// Failed are the events of the batch to retry
+	Failed []*Event
+
+	// Err is the reason the events failed
+	Err error
+}
+
+// NewPartialBatchError creates the error of the failed events of a batch
+func NewPartialBatchError(err error, failed ...*Event) *PartialBatchError {
+	return &PartialBatchError{Failed: failed, Err: err}
+}
+
+func (err *PartialBatchError) Error() string {
+	return fmt.Sprintf("%d events of the batch failed: %v", len(err.Failed), err.Err)
+}
+
+func (err *PartialBatchError) Unwrap() error {
+	return err.Err
+}
+
+// batchConsumer collects the messages of a CallbackBatch subscriber into batches and processes them
+type batchConsumer struct {
+	sub          *subscription
+	groupID      string
+	policy       *RetryPolicy
+	loggerFields *logrus.Entry
+	retryMetrics *kafkaprometheus.RetryCollector
+
+	// fetch fetches the next message until ctx is done, commit commits the messages of a batch at once,
+	// publish publishes a message to a topic without prefix
+	fetch   func(ctx context.Context) (kafka.Message, error)
+	commit  func(messages []kafka.Message)
+	publish func(topic string, message kafka.Message) error
+}
+
+// collect fetches the next messages of the batch started by first, until the batch is full, the batch timeout has
+// passed or the subscription is stopped
+func (consumer *batchConsumer) collect(first kafka.Message) []kafka.Message {
+	size := consumer.sub.builder.batchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	timeout := consumer.sub.builder.batchTimeout
+	if timeout <= 0 {
+		timeout = defaultBatchTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(consumer.sub.stopCtx, timeout)
+	defer cancel()
+
+	messages := make([]kafka.Message, 1, size)
+	messages[0] = first
+
+	for len(messages) < size {
+		message, err := consumer.fetch(ctx)
+		if err != nil {
+			// a fetch error is handled with the next fetch
+			break
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+// process calls the callback with the events of the messages and commits them. The failed events are retried
+// with the retry policy of the subscriber, then published to the dead letter topic if the subscriber has one.
+// It returns an error if they can't be dead lettered, the batch isn't committed then.
+func (consumer *batchConsumer) process(messages []kafka.Message) error {
+	builder := consumer.sub.builder
+
+	events := make([]*Event, 0, len(messages))
+	eventMessages := make(map[*Event]kafka.Message, len(messages))
+
+	for _, message := range messages {
+		event, err := unmarshal(message)
+		if err != nil {
+			// as retry will fail infinitely - the event is committed with the batch
+			consumer.loggerFields.Error("unable to unmarshal message from subscribe in kafka: ", err)
+			continue
+		}
+
+		event.Topic = builder.eventTopic(message)
+		if !builder.subscribes(event) {
+			// don't send events if consumer didn't subscribe on their event name, or they're filtered out
+			continue
+		}
+
+		events = append(events, event)
+		eventMessages[event] = message
+	}
+
+	var r *retrier
+	for len(events) > 0 {
+		err := builder.callbackBatch(consumer.sub.ctx, events)
+		if err == nil {
+			break
+		}
+
+		consumer.sub.failed(err)
+
+		var partialErr *PartialBatchError
+		if errors.As(err, &partialErr) {
+			events = failedEvents(events, partialErr.Failed)
+			if len(events) == 0 {
+				break
+			}
+		}
+
+		if r == nil {
+			r = newRetrier(consumer.policy)
+		}
+
+		delay, ok := r.next(err)
+		if !ok {
+			if errGiveUp := consumer.giveUp(events, eventMessages, r.attempt, err); errGiveUp != nil {
+				return errGiveUp
+			}
+
+			break
+		}
+
+		consumer.observeRetry(events, eventMessages, r.attempt)
+		consumer.loggerFields.
+			WithField("Attempt", r.attempt).
+			WithField("Failed Events", len(events)).
+			Error("unable to process the batch: ", err)
+
+		if !consumer.sub.waitUntil(time.Now().Add(delay)) {
+			// the batch isn't committed, it's processed again once the subscriber restarts
+			return nil
+		}
+	}
+
+	consumer.commit(messages)
+
+	return nil
+}
+
+// observeRetry observes the retry of the failed events once per topic they're consumed from
+func (consumer *batchConsumer) observeRetry(events []*Event, eventMessages map[*Event]kafka.Message, attempt int) {
+	observed := make(map[string]bool)
+	for _, event := range events {
+		topic := eventMessages[event].Topic
+		if !observed[topic] {
+			observed[topic] = true
+			consumer.retryMetrics.ObserveRetry(retryOperationSubscribe, topic, attempt)
+		}
+	}
+}
+
+// giveUp publishes the events given up after attempt to the dead letter topic if the subscriber has one
+func (consumer *batchConsumer) giveUp(events []*Event, eventMessages map[*Event]kafka.Message, attempt int,
+	err error) error {
+	consumer.loggerFields.
+		WithField("Attempt", attempt).
+		WithField("Failed Events", len(events)).
+		Error("giving up processing the batch: ", err)
+
+	deadLetterTopic := consumer.sub.builder.deadLetterTopicName()
+	if deadLetterTopic == "" {
+		return nil
+	}
+
+	for _, event := range events {
+		message := eventMessages[event]
+		letter := deadLetter{
+			topic:     message.Topic,
+			groupID:   consumer.groupID,
+			partition: message.Partition,
+			offset:    message.Offset,
+			attempts:  attempt,
+			err:       err,
+		}
+
+		if errPublish := consumer.publish(deadLetterTopic, newDeadLetterMessage(message, letter)); errPublish != nil {
+			return errPublish
+		}
+	}
+
+	return nil
+}
+
+// failedEvents returns the events of the batch reported as failed
+func failedEvents(events, failed []*Event) []*Event {
+	isFailed := make(map[*Event]bool, len(failed))
+	for _, event := range failed {
+		isFailed[event] = true
+	}
+
+	retried := make([]*Event, 0, len(failed))
+	for _, event := range events {
+		if isFailed[event] {
+			retried = append(retried, event)
+		}
+	}
+
+	return retried
+}
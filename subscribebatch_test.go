@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeBatch(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	groupID := generateID()
+	createTestTopic(t, topicName)
+
+	for i := 0; i < 5; i++ {
+		err := client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+	}
+
+	err := client.PublishSync(NewPublish().Topic(topicName).EventName("otherEvent").Key(testKey).EventID(5))
+	require.NoError(t, err)
+
+	batches := make(chan []int, 10)
+	failed := false
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			BatchSize(3).
+			BatchTimeout(200 * time.Millisecond).
+			RetryPolicy(&RetryPolicy{InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			CallbackBatch(func(ctx context.Context, events []*Event) error {
+				eventIDs := make([]int, 0, len(events))
+				for _, event := range events {
+					eventIDs = append(eventIDs, event.EventID)
+				}
+				batches <- eventIDs
+
+				for _, event := range events {
+					if event.EventID == 1 && !failed {
+						failed = true
+						return NewPartialBatchError(errRetryTest, event)
+					}
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	var processed []int
+	retried := false
+	for len(processed) < 5 {
+		select {
+		case eventIDs := <-batches:
+			assert.LessOrEqual(t, len(eventIDs), 3)
+			if len(eventIDs) == 1 && eventIDs[0] == 1 && !retried {
+				retried = true
+				continue
+			}
+			processed = append(processed, eventIDs...)
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "processed: %v", processed)
+		}
+	}
+
+	assert.True(t, failed)
+	assert.True(t, retried, "only the failed event should be delivered again")
+	assert.ElementsMatch(t, []int{0, 1, 2, 3, 4}, processed, "the other events should be filtered out")
+
+	require.NoError(t, subscription.Unsubscribe(ctx))
+
+	// the next subscriber of the group starts after the committed batches
+	err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(6))
+	require.NoError(t, err)
+
+	received := make(chan int, 10)
+	err = client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(groupID).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event.EventID
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	select {
+	case eventID := <-received:
+		assert.Equal(t, 6, eventID, "the batches should be committed")
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscribeBatchInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Concurrency(2).
+			CallbackBatch(func(ctx context.Context, events []*Event) error {
+				return nil
+			}))
+	assert.ErrorIs(t, err, errInvalidCallbackBatch)
+}
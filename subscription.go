@@ -0,0 +1,336 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// SubscriptionState is the lifecycle state of a subscription
+type SubscriptionState int32
+
+const (
+	// SubscriptionRunning the subscriber is consuming events
+	SubscriptionRunning SubscriptionState = iota
+	// SubscriptionRestarting the subscriber is waiting to restart after a failed callback
+	SubscriptionRestarting
+	// SubscriptionStopped the subscriber is stopped and won't consume any more events
+	SubscriptionStopped
+	// SubscriptionPaused the subscriber is paused, it keeps its group membership without fetching events
+	SubscriptionPaused
+)
+
+// String returns the state name
+func (state SubscriptionState) String() string {
+	switch state {
+	case SubscriptionRunning:
+		return "running"
+	case SubscriptionRestarting:
+		return "restarting"
+	case SubscriptionStopped:
+		return "stopped"
+	case SubscriptionPaused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
+
+// Subscription is a handle of a subscriber returned by Client.Subscribe
+type Subscription interface {
+	// Unsubscribe stops consuming events. It waits until the current callback finishes and its offset
+	// is committed, or until ctx is done.
+	Unsubscribe(ctx context.Context) error
+
+	// Done is closed when the subscription is stopped
+	Done() <-chan struct{}
+
+	// Err returns the error that stopped the subscription, e.g. the cancellation of the SubscribeBuilder context.
+	// It returns nil while the subscription is running or when it was stopped by Unsubscribe.
+	Err() error
+
+	// State returns the current subscription state
+	State() SubscriptionState
+
+	// Seek moves the subscriber to the position once the current callback finishes, and waits until it's moved
+	// or ctx is done. The offsets of a group are committed for it, it shouldn't have other members then.
+	Seek(ctx context.Context, position SeekPosition) error
+
+	// Pause stops fetching events once the current callback finishes, the subscriber keeps its group membership
+	// so the partitions aren't rebalanced
+	Pause()
+
+	// Resume fetches events again after Pause
+	Resume()
+}
+
+// subscription is the Subscription implementation shared by the clients
+type subscription struct {
+	builder *SubscribeBuilder
+
+	// ctx is passed to the callbacks, derived from the SubscribeBuilder context
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stopCtx is cancelled to stop consuming, the current callback is left to finish
+	stopCtx context.Context
+	stop    context.CancelFunc
+
+	state int32
+	done  chan struct{}
+
+	errLock       sync.Mutex
+	stopRequested bool
+	stopReason    error
+	err           error
+
+	// seekLock guards pendingSeek, the seek waiting to be applied by the consuming goroutine, and wakeFetch,
+	// interrupting its current fetch
+	seekLock    sync.Mutex
+	pendingSeek *seekRequest
+	wakeFetch   context.CancelFunc
+
+	// pauseLock guards resumed, closed when the paused subscription is resumed, nil while it's not paused
+	pauseLock sync.Mutex
+	resumed   chan struct{}
+
+	// idle subscriptions don't consume anything. The events of a multi topic subscriber of the memory stream are
+	// consumed by the subscriptions returned by topicSubscriptions, they seek and pause with it.
+	idle               bool
+	topicSubscriptions func() []*subscription
+
+	// activityLock guards the activity of the subscription reported by Describe
+	activityLock    sync.Mutex
+	partitions      map[topicPartition]*partitionActivity
+	lastError       error
+	lastErrorTime   time.Time
+	lastMessageTime time.Time
+
+	// fetchError is the last fetch error, nil once an event is fetched, and lastFetchTime the time of the last
+	// event fetched or poll without event. Reported by Health, guarded by activityLock.
+	fetchError    error
+	lastFetchTime time.Time
+
+	// retries of the event failed by the callback, only used by the consuming goroutine
+	failedTopic     string
+	failedPartition int
+	failedOffset    int64
+	failedRetrier   *retrier
+	restartDelay    time.Duration
+}
+
+// newSubscription creates a running subscription for subscribeBuilder
+func newSubscription(subscribeBuilder *SubscribeBuilder) *subscription {
+	ctx, cancel := context.WithCancel(subscribeBuilder.ctx)
+	stopCtx, stop := context.WithCancel(ctx)
+
+	sub := &subscription{
+		builder: subscribeBuilder,
+		ctx:     ctx,
+		cancel:  cancel,
+		stopCtx: stopCtx,
+		stop:    stop,
+		state:   int32(SubscriptionRunning),
+		done:    make(chan struct{}),
+
+		lastFetchTime: time.Now(),
+	}
+	// the subscriber starts at the position of StartAt
+	if !subscribeBuilder.startAt.isZero() {
+		sub.pendingSeek = &seekRequest{position: subscribeBuilder.startAt}
+	}
+
+	return sub
+}
+
+// newIdleSubscription creates a subscription that doesn't consume anything.
+// It's stopped by Unsubscribe or when the SubscribeBuilder context is cancelled.
+func newIdleSubscription(subscribeBuilder *SubscribeBuilder) *subscription {
+	sub := newSubscription(subscribeBuilder)
+	sub.pendingSeek = nil
+	sub.idle = true
+
+	go func() {
+		<-sub.stopCtx.Done()
+		sub.finish(sub.ctx.Err())
+	}()
+
+	return sub
+}
+
+// Unsubscribe stops the subscription and waits until it's done or ctx is done
+func (sub *subscription) Unsubscribe(ctx context.Context) error {
+	sub.stopWithReason(nil)
+
+	select {
+	case <-sub.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Seek moves the subscriber to the position and waits until it's moved or ctx is done
+func (sub *subscription) Seek(ctx context.Context, position SeekPosition) error {
+	if err := position.validate(sub.builder); err != nil {
+		return err
+	}
+
+	switch {
+	case sub.idle:
+		return nil
+	case sub.topicSubscriptions != nil:
+		for _, topicSubscription := range sub.topicSubscriptions() {
+			if err := topicSubscription.Seek(ctx, position); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return sub.requestSeek(ctx, position)
+	}
+}
+
+// Done is closed when the subscription is stopped
+func (sub *subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Err returns the error that stopped the subscription
+func (sub *subscription) Err() error {
+	sub.errLock.Lock()
+	defer sub.errLock.Unlock()
+
+	return sub.err
+}
+
+// State returns the current subscription state
+func (sub *subscription) State() SubscriptionState {
+	state := SubscriptionState(atomic.LoadInt32(&sub.state))
+	if state == SubscriptionRunning && sub.paused() {
+		return SubscriptionPaused
+	}
+
+	return state
+}
+
+func (sub *subscription) setState(state SubscriptionState) {
+	atomic.StoreInt32(&sub.state, int32(state))
+}
+
+// stopWithReason requests the subscription to stop, reason is reported by Err once it's stopped.
+// Only the first reason is kept.
+func (sub *subscription) stopWithReason(reason error) {
+	sub.errLock.Lock()
+	if !sub.stopRequested && sub.stopCtx.Err() == nil {
+		sub.stopRequested = true
+		sub.stopReason = reason
+	}
+	sub.errLock.Unlock()
+
+	sub.stop()
+}
+
+// stopped returns true if the subscription was asked to stop
+func (sub *subscription) stopped() bool {
+	return sub.stopCtx.Err() != nil
+}
+
+// finish marks the subscription as stopped. err is used as the terminal error
+// unless the subscription was stopped explicitly.
+func (sub *subscription) finish(err error) {
+	sub.errLock.Lock()
+	if sub.stopRequested {
+		// stopped by Unsubscribe or Close, not by the SubscribeBuilder context
+		err = sub.stopReason
+	}
+	sub.err = err
+	sub.errLock.Unlock()
+
+	sub.setState(SubscriptionStopped)
+	sub.stop()
+	sub.cancel()
+	close(sub.done)
+}
+
+// notifyCancelled calls the callbacks with the error of the cancelled subscription context.
+// The internal subscribers of the retry topics and of the topics of the memory stream don't, the subscriber
+// notifies it once.
+func (sub *subscription) notifyCancelled() {
+	builder := sub.builder
+	if builder.retryParent != nil || builder.topicParent != nil {
+		return
+	}
+
+	// ignore error because client isn't processing events
+	if builder.callback != nil {
+		_ = builder.callback(sub.ctx, nil, sub.ctx.Err())
+	}
+	if builder.callbackRaw != nil {
+		_ = builder.callbackRaw(sub.ctx, nil, sub.ctx.Err())
+	}
+	if builder.callbackTyped != nil {
+		_ = builder.callbackTyped(sub.ctx, nil, sub.ctx.Err())
+	}
+	if builder.callbackDelivery != nil {
+		_ = builder.callbackDelivery(sub.ctx, nil, sub.ctx.Err())
+	}
+}
+
+// waitUntil waits until t, it returns false if the subscription is stopped first
+func (sub *subscription) waitUntil(t time.Time) bool {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-sub.stopCtx.Done():
+		return false
+	}
+}
+
+// retryEvent counts the failed delivery of the message and sets the delay before its redelivery.
+// It returns the number of the failed attempt, and false if the policy gives up on the event.
+func (sub *subscription) retryEvent(policy *RetryPolicy, message kafka.Message, err error) (int, bool) {
+	if sub.failedRetrier == nil || sub.failedTopic != message.Topic || sub.failedPartition != message.Partition ||
+		sub.failedOffset != message.Offset {
+		sub.failedTopic = message.Topic
+		sub.failedPartition = message.Partition
+		sub.failedOffset = message.Offset
+		sub.failedRetrier = newRetrier(policy)
+	}
+
+	// the retrier is kept once it gives up, so the event is given up again if it's delivered again
+	delay, ok := sub.failedRetrier.next(err)
+	attempt := sub.failedRetrier.attempt
+
+	sub.restartDelay = delay
+
+	return attempt, ok
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionUnsubscribe(t *testing.T) {
+	sub := newIdleSubscription(NewSubscribe())
+	assert.Equal(t, SubscriptionRunning, sub.State())
+
+	err := sub.Unsubscribe(context.Background())
+	require.NoError(t, err)
+
+	<-sub.Done()
+	assert.Equal(t, SubscriptionStopped, sub.State())
+	assert.NoError(t, sub.Err(), "unsubscribe should not report an error")
+}
+
+func TestSubscriptionContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := newIdleSubscription(NewSubscribe().Context(ctx))
+
+	cancel()
+
+	select {
+	case <-sub.Done():
+	case <-time.After(time.Second):
+		assert.FailNow(t, errorTimeout)
+	}
+
+	assert.Equal(t, SubscriptionStopped, sub.State())
+	assert.Equal(t, context.Canceled, sub.Err())
+
+	// unsubscribing a stopped subscription keeps the original error
+	require.NoError(t, sub.Unsubscribe(context.Background()))
+	assert.Equal(t, context.Canceled, sub.Err())
+}
+
+func TestSubscriptionStopReason(t *testing.T) {
+	sub := newIdleSubscription(NewSubscribe())
+
+	sub.stopWithReason(ErrClientClosed)
+	sub.stopWithReason(nil)
+
+	<-sub.Done()
+	assert.Equal(t, ErrClientClosed, sub.Err())
+}
+
+func TestKafkaSubscribeUnsubscribe(t *testing.T) {
+	t.Parallel()
+	client := createInvalidKafkaClient(t)
+
+	sub, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	require.NoError(t, err)
+	assert.Equal(t, SubscriptionRunning, sub.State())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, sub.Unsubscribe(ctx))
+	assert.Equal(t, SubscriptionStopped, sub.State())
+	assert.NoError(t, sub.Err())
+}
+
+func TestKafkaCloseStopsSubscriptions(t *testing.T) {
+	t.Parallel()
+	client := createInvalidKafkaClient(t)
+
+	sub, err := client.Subscribe(
+		NewSubscribe().
+			Topic(constructTopicTest()).
+			EventName("testEvent").
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, client.Close(ctx))
+
+	select {
+	case <-sub.Done():
+	default:
+		assert.Fail(t, "subscription should be stopped after Close")
+	}
+	assert.Equal(t, ErrClientClosed, sub.Err())
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTopicDiscoveryInterval = 30 * time.Second // interval of the discovery of the topics matching a pattern
+)
+
+// topicPatternEscaper escapes the slug separator in a topic pattern, see subscribedTopic
+var topicPatternEscaper = strings.NewReplacer("%", "%25", kafkaprometheus.SlugSeparator, "%24")
+
+var (
+	// errSubscribedTopicsChanged restarts the reader of a pattern subscriber with the topics matching its pattern
+	errSubscribedTopicsChanged = errors.New("subscribed topics changed")
+
+	errNoBrokers = errors.New("no brokers")
+)
+
+// subscribedTopics returns the topics subscribed by Topic and Topics, without prefix
+func (s *SubscribeBuilder) subscribedTopics() []string {
+	topics := make([]string, 0, 1+len(s.topics))
+	if s.topic != "" {
+		topics = append(topics, s.topic)
+	}
+
+	for _, topic := range s.topics {
+		if topic != s.topic {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// subscribedTopic returns the subscribed topic without prefix, or the subscribed topics separated by commas,
+// or pattern:<topic pattern>, e.g. for logs and the slug. The slug separator is escaped in the pattern.
+func (s *SubscribeBuilder) subscribedTopic() string {
+	if s.topicPattern != nil {
+		return "pattern:" + topicPatternEscaper.Replace(s.topicPattern.String())
+	}
+
+	return strings.Join(s.subscribedTopics(), ",")
+}
+
+// subscribesTopic returns true if the topic without prefix is subscribed by Topic, Topics or TopicPattern
+func (s *SubscribeBuilder) subscribesTopic(topic string) bool {
+	for _, subscribed := range s.subscribedTopics() {
+		if subscribed == topic {
+			return true
+		}
+	}
+
+	return s.topicPattern != nil && s.topicPattern.MatchString(topic)
+}
+
+// isMultiTopic returns true if the subscriber consumes several topics, or the topics matching a pattern
+func (s *SubscribeBuilder) isMultiTopic() bool {
+	return s.topicPattern != nil || len(s.subscribedTopics()) > 1
+}
+
+// eventTopic returns the topic the message is consumed from without prefix, reported as Event.Topic
+func (s *SubscribeBuilder) eventTopic(message kafka.Message) string {
+	if message.Topic == "" {
+		return s.subscribedTopic()
+	}
+
+	return trimTopicPrefix(s.prefix, message.Topic)
+}
+
+// topicBuilder returns the builder of the internal subscriber of a topic of a multi topic subscriber
+func (s *SubscribeBuilder) topicBuilder(topic string, ctx context.Context) *SubscribeBuilder {
+	builder := *s
+	builder.topic = topic
+	builder.topics = nil
+	builder.topicPattern = nil
+	builder.ctx = ctx
+	builder.topicParent = s
+
+	return &builder
+}
+
+// matchTopics returns the topics with the prefix whose names without it match the pattern, sorted.
+// The internal topics of kafka are skipped.
+func matchTopics(prefix string, pattern *regexp.Regexp, topics []string) []string {
+	matched := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, "__") {
+			continue
+		}
+
+		if prefix != "" && !strings.HasPrefix(topic, prefix+separator) {
+			continue
+		}
+
+		if pattern.MatchString(trimTopicPrefix(prefix, topic)) {
+			matched = append(matched, topic)
+		}
+	}
+
+	sort.Strings(matched)
+
+	return matched
+}
+
+// sameTopics returns true if both sorted lists have the same topics
+func sameTopics(topics, other []string) bool {
+	if len(topics) != len(other) {
+		return false
+	}
+
+	for i := range topics {
+		if topics[i] != other[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialer returns the dialer of the readers
+func (client *KafkaClient) dialer() *kafka.Dialer {
+	if client.subscribeConfig.Dialer == nil {
+		return kafka.DefaultDialer
+	}
+
+	return client.subscribeConfig.Dialer
+}
+
+// dialBroker connects to the first reachable broker
+func (client *KafkaClient) dialBroker(ctx context.Context) (*kafka.Conn, error) {
+	err := errNoBrokers
+	for _, broker := range client.subscribeConfig.Brokers {
+		var conn *kafka.Conn
+		conn, err = client.dialer().DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// listTopics returns the topics of the cluster
+func (client *KafkaClient) listTopics(ctx context.Context) ([]string, error) {
+	conn, err := client.dialBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions, err := conn.ReadPartitions()
+	_ = conn.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make(map[string]struct{})
+	topics := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		if _, ok := unique[partition.Topic]; !ok {
+			unique[partition.Topic] = struct{}{}
+			topics = append(topics, partition.Topic)
+		}
+	}
+
+	return topics, nil
+}
+
+// subscribeTopics returns the topics with prefix consumed by the reader of a multi topic subscriber: its topics,
+// or the topics matching its pattern. It waits until a topic matches, it returns false if the subscription is
+// stopped first.
+func (client *KafkaClient) subscribeTopics(sub *subscription, loggerFields *logrus.Entry) ([]string, bool) {
+	builder := sub.builder
+	if builder.topicPattern == nil {
+		topics := builder.subscribedTopics()
+		for i, topic := range topics {
+			topics[i] = constructTopic(client.prefix, topic)
+		}
+
+		return topics, true
+	}
+
+	for {
+		topics, err := client.listTopics(sub.stopCtx)
+		if err != nil {
+			loggerFields.Error("unable to list the topics: ", err)
+		} else if matched := matchTopics(client.prefix, builder.topicPattern, topics); len(matched) > 0 {
+			return matched, true
+		}
+
+		if !sub.waitUntil(time.Now().Add(client.topicDiscoveryInterval)) {
+			return nil, false
+		}
+	}
+}
+
+// watchTopics lists the topics periodically until ctx is done. It calls changed once the topics matching the
+// pattern of the subscriber aren't the consumed ones anymore.
+func (client *KafkaClient) watchTopics(ctx context.Context, sub *subscription, consumed []string,
+	changed context.CancelFunc, loggerFields *logrus.Entry) {
+	ticker := time.NewTicker(client.topicDiscoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		topics, err := client.listTopics(ctx)
+		if err != nil {
+			loggerFields.Error("unable to list the topics: ", err)
+			continue
+		}
+
+		if matched := matchTopics(client.prefix, sub.builder.topicPattern, topics); !sameTopics(matched, consumed) {
+			loggerFields.WithField("Topics", matched).Info("subscribed topics changed")
+			changed()
+
+			return
+		}
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/AccelByte/eventstream-go-sdk/v3/pkg/kafkaprometheus"
+)
+
+func TestMatchTopics(t *testing.T) {
+	t.Parallel()
+
+	topics := []string{"prefix.orders", "prefix.orders.dlq", "prefix.users", "orders", "__consumer_offsets"}
+
+	assert.Equal(t, []string{"prefix.orders", "prefix.orders.dlq"},
+		matchTopics("prefix", regexp.MustCompile(`^orders`), topics))
+	assert.Equal(t, []string{"prefix.orders"}, matchTopics("prefix", regexp.MustCompile(`^orders$`), topics))
+	assert.Equal(t, []string{"orders"}, matchTopics("", regexp.MustCompile(`^orders$`), topics))
+	assert.Empty(t, matchTopics("", regexp.MustCompile(`consumer`), topics), "internal topics should be skipped")
+}
+
+func TestSubscribeTopics(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topics := []string{constructTopicTest(), constructTopicTest()}
+
+	for i, topicName := range topics {
+		createTestTopic(t, topicName)
+
+		err := client.PublishSync(
+			NewPublish().
+				Topic(topicName).
+				EventName("testEvent").
+				Key(testKey).
+				EventID(i))
+		require.NoError(t, err)
+	}
+
+	received := make(chan *Event, 2)
+	err := client.Register(
+		NewSubscribe().
+			Topics(topics...).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	receivedTopics := make(map[int]string)
+	for len(receivedTopics) < len(topics) {
+		select {
+		case event := <-received:
+			receivedTopics[event.EventID] = event.Topic
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout, "received: %v", receivedTopics)
+		}
+	}
+
+	assert.Equal(t, map[int]string{0: topics[0], 1: topics[1]}, receivedTopics,
+		"the events should report their source topic")
+}
+
+func TestSubscribeTopicsRetryPolicy(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topics := []string{constructTopicTest(), constructTopicTest()}
+	for _, topicName := range topics {
+		createTestTopic(t, topicName)
+	}
+
+	deliveries := make(chan int, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topics(topics...).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			RetryPolicy(&RetryPolicy{MaxAttempts: 2, InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					deliveries <- event.EventID
+				}
+				return errRetryTest
+			}))
+	require.NoError(t, err)
+
+	// the events have the same partition and offset in their topics, the second one is published once the first
+	// one is given up
+	var delivered []int
+	for i, topicName := range topics {
+		err = client.PublishSync(NewPublish().Topic(topicName).EventName("testEvent").Key(testKey).EventID(i))
+		require.NoError(t, err)
+
+		for len(delivered) < 2*(i+1) {
+			select {
+			case eventID := <-deliveries:
+				delivered = append(delivered, eventID)
+			case <-ctx.Done():
+				assert.FailNow(t, errorTimeout, "delivered: %v", delivered)
+			}
+		}
+	}
+
+	assert.Equal(t, []int{0, 0, 1, 1}, delivered, "the attempts should be counted per topic")
+}
+
+func TestSubscribeTopicsRetryMetricsAndSeek(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("the memory stream has no retry metrics")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	registry := prometheus.NewRegistry()
+	client, err := NewClient(prefix, eventStreamKafka, testBrokers(), &BrokerConfig{
+		StrictValidation: true,
+		DialTimeout:      2 * time.Second,
+		ReadTimeout:      2 * time.Second,
+		WriteTimeout:     2 * time.Second,
+		MetricsRegistry:  registry,
+	})
+	require.NoError(t, err)
+
+	topics := []string{constructTopicTest(), constructTopicTest()}
+	for _, topicName := range topics {
+		createTestTopic(t, topicName)
+	}
+
+	startAt := time.Now()
+	for i, topicName := range topics {
+		publishSeekTestEvents(t, client, topicName, i)
+	}
+
+	// the first delivery of every event fails
+	failed := make(map[int]bool)
+	received := make(chan *Event, 10)
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			Topics(topics...).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			RetryPolicy(&RetryPolicy{MaxAttempts: 2, InitialInterval: 10 * time.Millisecond, Multiplier: 1}).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event == nil {
+					return nil
+				}
+
+				if !failed[event.EventID] {
+					failed[event.EventID] = true
+					return errRetryTest
+				}
+
+				received <- event
+				return nil
+			}))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []int{0, 1}, receiveEventIDs(ctx, t, received, 2))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var retriedTopics []string
+	for _, family := range families {
+		if family.GetName() != "ab_eventstream_retry_attempts" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["operation"] == retryOperationSubscribe {
+				retriedTopics = append(retriedTopics, labels["topic"])
+			}
+		}
+	}
+
+	assert.ElementsMatch(t, []string{constructTopic(prefix, topics[0]), constructTopic(prefix, topics[1])},
+		retriedTopics, "the retries should be labeled with the topics of the events")
+
+	err = subscription.Seek(ctx, SeekPosition{Time: startAt})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []int{0, 1}, receiveEventIDs(ctx, t, received, 2),
+		"the events of both topics should be replayed from the seek")
+}
+
+func TestSubscribeTopicPattern(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, err := NewClient(prefix, testStream(), testBrokers(), &BrokerConfig{
+		StrictValidation:       true,
+		DialTimeout:            2 * time.Second,
+		TopicDiscoveryInterval: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	base := constructTopicTest()
+	existingTopic, createdTopic := base+".a", base+".b"
+	createTestTopic(t, existingTopic)
+
+	received := make(chan *Event, 2)
+	err = client.Register(
+		NewSubscribe().
+			TopicPattern(regexp.MustCompile("^" + regexp.QuoteMeta(base) + `\.[ab]$`)).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- event
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	publish := func(topicName string) {
+		err := client.PublishSync(
+			NewPublish().
+				Topic(topicName).
+				EventName("testEvent").
+				Key(testKey))
+		require.NoError(t, err)
+	}
+
+	publish(existingTopic)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, existingTopic, event.Topic)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+
+	// the topic created after subscribing is discovered
+	createTestTopic(t, createdTopic)
+	publish(createdTopic)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, createdTopic, event.Topic)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscriberCollectorTopicPattern(t *testing.T) {
+	if testStream() == eventStreamMemory {
+		t.Skip("memory stream has no subscriber metrics")
+	}
+
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+
+	// the anchor of the pattern is the slug separator
+	subscription, err := client.Subscribe(
+		NewSubscribe().
+			TopicPattern(regexp.MustCompile(`\.[ab]$`)).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				return nil
+			}))
+	require.NoError(t, err)
+
+	subscription.Pause()
+
+	expected := `
+		# HELP ab_eventstream_subscriber_paused Whether the subscriber is paused (1) or fetching events (0).
+		# TYPE ab_eventstream_subscriber_paused gauge
+		ab_eventstream_subscriber_paused{event="testEvent",topic="pattern:\\.[ab]%24"} 1
+	`
+	collector := &kafkaprometheus.SubscriberCollector{Client: client.(*KafkaClient)}
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestSubscribeTopicsInvalid(t *testing.T) {
+	t.Parallel()
+
+	client := createMemoryClient(t)
+	callback := func(ctx context.Context, event *Event, err error) error {
+		return nil
+	}
+
+	_, err := client.Subscribe(
+		NewSubscribe().
+			Topics(constructTopicTest(), constructTopicTest()).
+			EventName("testEvent").
+			Callback(callback))
+	assert.ErrorIs(t, err, errInvalidTopics, "multiple topics should need a group ID")
+
+	_, err = client.Subscribe(
+		NewSubscribe().
+			TopicPattern(regexp.MustCompile(`^orders`)).
+			EventName("testEvent").
+			GroupID(generateID()).
+			DeadLetter(3).
+			Callback(callback))
+	assert.ErrorIs(t, err, errInvalidTopics, "a topic pattern should need a dead letter topic")
+
+	_, err = client.Subscribe(
+		NewSubscribe().
+			TopicPattern(regexp.MustCompile(`^orders`)).
+			EventName("testEvent").
+			GroupID(generateID()).
+			DeadLetterTopic("orders.dlq").
+			Callback(callback))
+	assert.ErrorIs(t, err, errInvalidDeadLetterTopic, "the dead letter topic shouldn't match the pattern")
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName      = "github.com/AccelByte/eventstream-go-sdk/v3"
+	messagingSystem = "kafka"
+)
+
+// traceContext propagates the span contexts through the W3C traceparent and tracestate headers
+var traceContext = propagation.TraceContext{}
+
+// tracing creates the OpenTelemetry spans of the publishes and the subscribers of a client,
+// nil unless a TracerProvider is configured
+type tracing struct {
+	tracer trace.Tracer
+}
+
+// newTracing returns the tracing of the provider, nil if there's none
+func newTracing(provider trace.TracerProvider) *tracing {
+	if provider == nil {
+		return nil
+	}
+
+	return &tracing{tracer: provider.Tracer(tracerName)}
+}
+
+// startPublish starts the producer span of the messages published to the topic and injects its context into their
+// headers. The span ends with the returned function, once the messages are published or given up.
+func (t *tracing) startPublish(ctx context.Context, topic string, messages []kafka.Message) func(err error) {
+	if t == nil {
+		return func(error) {}
+	}
+
+	attributes := []attribute.KeyValue{
+		semconv.MessagingSystem(messagingSystem),
+		semconv.MessagingDestinationName(topic),
+		semconv.MessagingOperationPublish,
+	}
+	if len(messages) > 1 {
+		attributes = append(attributes, semconv.MessagingBatchMessageCount(len(messages)))
+	}
+
+	ctx, span := t.tracer.Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes...))
+
+	for i := range messages {
+		// the headers are copied, the slice may be shared with the message of the publish builder
+		headers := append([]kafka.Header(nil), messages[i].Headers...)
+		traceContext.Inject(ctx, headerCarrier{headers: &headers})
+		messages[i].Headers = headers
+	}
+
+	return func(err error) {
+		endSpan(span, err)
+	}
+}
+
+// startPublishMessage starts the producer span of the message published to the topic, see startPublish.
+// It returns the message with the span context in its headers.
+func (t *tracing) startPublishMessage(ctx context.Context, topic string, message kafka.Message) (kafka.Message,
+	func(err error)) {
+	messages := []kafka.Message{message}
+	end := t.startPublish(ctx, topic, messages)
+
+	return messages[0], end
+}
+
+// startPublishBatch starts the producer span of the messages of the group, see startPublish
+func (t *tracing) startPublishBatch(ctx context.Context, group *batchGroup) func(err error) {
+	if t == nil {
+		return func(error) {}
+	}
+
+	messages := group.kafkaMessages()
+	end := t.startPublish(ctx, group.topic, messages)
+
+	for i, batchMsg := range group.messages {
+		batchMsg.message = messages[i]
+	}
+
+	return end
+}
+
+// process calls the callback with the consumer span of the message, a child of the producer span extracted from
+// the headers of the message. The callback is called with ctx if the subscriber isn't traced.
+func (t *tracing) process(ctx context.Context, subscribeBuilder *SubscribeBuilder, message kafka.Message,
+	callback func(ctx context.Context) error) error {
+	if t == nil {
+		return callback(ctx)
+	}
+
+	ctx = traceContext.Extract(ctx, headerCarrier{headers: &message.Headers})
+
+	attributes := []attribute.KeyValue{
+		semconv.MessagingSystem(messagingSystem),
+		semconv.MessagingSourceName(message.Topic),
+		semconv.MessagingOperationProcess,
+		semconv.MessagingKafkaSourcePartition(message.Partition),
+		semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+	}
+	if subscribeBuilder.groupID != "" {
+		attributes = append(attributes, semconv.MessagingKafkaConsumerGroup(subscribeBuilder.groupID))
+	}
+
+	ctx, span := t.tracer.Start(ctx, message.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...))
+
+	err := callback(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+// endSpan ends the span, with an error status if err isn't nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// headerTraceID returns the trace ID of the traceparent header, empty if there's none
+func headerTraceID(headers []kafka.Header) string {
+	spanContext := trace.SpanContextFromContext(
+		traceContext.Extract(context.Background(), headerCarrier{headers: &headers}))
+	if !spanContext.IsValid() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
+
+// headerCarrier adapts the headers of a message to propagation.TextMapCarrier
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+// Get returns the value of the first header with the key
+func (carrier headerCarrier) Get(key string) string {
+	for _, header := range *carrier.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+// Set replaces the value of the header with the key, or adds it
+func (carrier headerCarrier) Set(key, value string) {
+	for i, header := range *carrier.headers {
+		if header.Key == key {
+			(*carrier.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+
+	*carrier.headers = append(*carrier.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of the headers
+func (carrier headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*carrier.headers))
+	for _, header := range *carrier.headers {
+		keys = append(keys, header.Key)
+	}
+
+	return keys
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedEvent is an event received by a traced subscriber with the span context of its callback
+type tracedEvent struct {
+	event       *Event
+	spanContext trace.SpanContext
+}
+
+// createTracedClient creates a client of the test stream with a tracer provider recording the spans
+func createTracedClient(t *testing.T) (Client, *tracetest.SpanRecorder, trace.TracerProvider) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client, err := NewClient(prefix, testStream(), testBrokers(), &BrokerConfig{
+		DialTimeout:    2 * time.Second,
+		TracerProvider: provider,
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = client.Close(context.Background())
+	})
+
+	return client, recorder, provider
+}
+
+// endedSpan returns the ended span with the kind, waiting until it's recorded
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	var ended sdktrace.ReadOnlySpan
+	require.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			if span.SpanKind() == kind {
+				ended = span
+				return true
+			}
+		}
+
+		return false
+	}, 10*time.Second, 10*time.Millisecond, "the %s span should end", kind)
+
+	return ended
+}
+
+func TestTracingPublishSubscribe(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, recorder, provider := createTracedClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	received := make(chan tracedEvent, 10)
+	err := client.Register(
+		NewSubscribe().
+			Topic(topicName).
+			EventName("testEvent").
+			GroupID(generateID()).
+			Offset(0).
+			Context(ctx).
+			Callback(func(ctx context.Context, event *Event, err error) error {
+				if event != nil {
+					received <- tracedEvent{event: event, spanContext: trace.SpanContextFromContext(ctx)}
+				}
+				return nil
+			}))
+	require.NoError(t, err)
+
+	requestCtx, request := provider.Tracer("test").Start(ctx, "request")
+
+	err = client.PublishSync(
+		NewPublish().
+			Topic(topicName).
+			EventName("testEvent").
+			Context(requestCtx))
+	require.NoError(t, err)
+	request.End()
+
+	var traced tracedEvent
+	select {
+	case traced = <-received:
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+
+	traceID := request.SpanContext().TraceID()
+	assert.Equal(t, traceID, traced.spanContext.TraceID(), "the callback should continue the trace of the publisher")
+	assert.Equal(t, traceID.String(), traced.event.TraceID, "the trace ID should default to the one of the header")
+	assert.Contains(t, traced.event.Headers, "traceparent")
+
+	producer := endedSpan(t, recorder, trace.SpanKindProducer)
+	assert.Equal(t, request.SpanContext().SpanID(), producer.Parent().SpanID())
+	assert.Equal(t, constructTopic(prefix, topicName)+" publish", producer.Name())
+
+	consumer := endedSpan(t, recorder, trace.SpanKindConsumer)
+	assert.Equal(t, traced.spanContext.SpanID(), consumer.SpanContext().SpanID())
+	assert.Equal(t, producer.SpanContext().SpanID(), consumer.Parent().SpanID(),
+		"the consumer span should be a child of the producer span")
+	assert.Empty(t, consumer.Links(), "the consumer span shouldn't be linked to its parent")
+}
+
+func TestTracingPublishAuditLog(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client, recorder, provider := createTracedClient(t)
+	createTestTopic(t, auditLogTopicDefault)
+
+	requestCtx, request := provider.Tracer("test").Start(ctx, "request")
+
+	err := client.PublishAuditLog(
+		NewAuditLogBuilder().
+			Category("user").
+			ActionName("create").
+			Actor("c7dcf5ed3e6d4f1d9f5e3c3a7b0b4d8e").
+			IsActorTypeUser(true).
+			ClientID("9c8e5ba5a8e44a1ba4f3e1a0f9d7b2c6").
+			ActorNamespace("accelbyte").
+			ObjectNamespace("accelbyte").
+			Context(requestCtx))
+	require.NoError(t, err)
+	request.End()
+
+	producer := endedSpan(t, recorder, trace.SpanKindProducer)
+	assert.Equal(t, request.SpanContext().SpanID(), producer.Parent().SpanID(),
+		"the audit log should continue the trace of its context")
+}
+
+func TestTracingProcessError(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	errCallback := errors.New("callback failed")
+	builder := NewSubscribe().
+		Topic(constructTopicTest()).
+		EventName("testEvent").
+		CallbackRaw(func(ctx context.Context, msgValue []byte, err error) error {
+			return errCallback
+		})
+	builder.tracing = newTracing(provider)
+
+	err := processMessage(context.Background(), builder, kafka.Message{Topic: "topic"}, "topic")
+	assert.ErrorIs(t, err, errCallback)
+
+	consumer := endedSpan(t, recorder, trace.SpanKindConsumer)
+	assert.Equal(t, codes.Error, consumer.Status().Code, "the span of a failed callback should have an error status")
+	assert.False(t, consumer.Parent().IsValid(), "the span shouldn't have a parent without a producer span")
+}
+
+func TestTracingDisabledTraceID(t *testing.T) {
+	t.Parallel()
+
+	producer := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	headers := make([]kafka.Header, 0)
+	traceContext.Inject(trace.ContextWithSpanContext(context.Background(), producer), headerCarrier{headers: &headers})
+
+	message, _, err := ConstructEvent(NewPublish().Topic("topic").EventName("testEvent"))
+	require.NoError(t, err)
+	message.Headers = headers
+
+	tracedMessage, _, err := ConstructEvent(NewPublish().Topic("topic").EventName("testEvent").TraceID("traceID"))
+	require.NoError(t, err)
+	tracedMessage.Headers = headers
+
+	traceIDs := make(chan string, 2)
+	builder := NewSubscribe().
+		Topic("topic").
+		EventName("testEvent").
+		Callback(func(ctx context.Context, event *Event, err error) error {
+			assert.False(t, trace.SpanContextFromContext(ctx).IsValid(), "the callback shouldn't be traced")
+			traceIDs <- event.TraceID
+			return nil
+		})
+
+	require.NoError(t, processMessage(context.Background(), builder, message, "topic"))
+	require.NoError(t, processMessage(context.Background(), builder, tracedMessage, "topic"))
+
+	assert.Equal(t, producer.TraceID().String(), <-traceIDs, "the trace ID should default to the one of the header")
+	assert.Equal(t, "traceID", <-traceIDs, "the trace ID of the publisher should be kept")
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// TypedConfig is the configuration of SubscribeTyped
+type TypedConfig struct {
+	// DisallowUnknownFields fails the decoding of a payload having fields that aren't in T
+	DisallowUnknownFields bool
+}
+
+// TypedEvent is an event with its payload decoded into T
+type TypedEvent[T any] struct {
+	Event
+	Payload T
+}
+
+// typedMessage is the event message with the payload left undecoded
+type typedMessage struct {
+	Event
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// PublishTyped publishes the event with the payload encoded from T, like Publish.
+// T should encode to a JSON object, e.g. a struct or a map.
+func PublishTyped[T any](client Client, publishBuilder *PublishBuilder, payload T) error {
+	if err := setTypedPayload(publishBuilder, payload); err != nil {
+		return err
+	}
+
+	return client.Publish(publishBuilder)
+}
+
+// PublishTypedSync publishes the event with the payload encoded from T, like PublishSync.
+// T should encode to a JSON object, e.g. a struct or a map.
+func PublishTypedSync[T any](client Client, publishBuilder *PublishBuilder, payload T) error {
+	if err := setTypedPayload(publishBuilder, payload); err != nil {
+		return err
+	}
+
+	return client.PublishSync(publishBuilder)
+}
+
+// setTypedPayload sets the payload of the publish builder from T.
+// The numbers are kept as json.Number so they are encoded again without losing precision.
+func setTypedPayload[T any](publishBuilder *PublishBuilder, payload T) error {
+	if publishBuilder == nil {
+		return errPubNilEvent
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal payload : %s , error : %v", publishBuilder.eventName, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payloadBytes))
+	decoder.UseNumber()
+
+	var payloadMap map[string]interface{}
+	if err = decoder.Decode(&payloadMap); err != nil {
+		return fmt.Errorf("payload should be a JSON object : %s , error : %v", publishBuilder.eventName, err)
+	}
+
+	publishBuilder.Payload(payloadMap)
+
+	return nil
+}
+
+// SubscribeTyped sets the callback of the subscribe builder, decoding the payload of the events into T.
+// It replaces Callback and CallbackRaw. The events that can't be decoded are passed to the callback with the error,
+// with the fields decoded so far, instead of being skipped. Register the returned builder to subscribe.
+func SubscribeTyped[T any](
+	subscribeBuilder *SubscribeBuilder,
+	callback func(ctx context.Context, event *TypedEvent[T], err error) error,
+	config ...*TypedConfig,
+) *SubscribeBuilder {
+	disallowUnknownFields := len(config) > 0 && config[0] != nil && config[0].DisallowUnknownFields
+
+	subscribeBuilder.callback = nil
+	subscribeBuilder.callbackRaw = nil
+	subscribeBuilder.callbackTyped = func(ctx context.Context, message *kafka.Message, err error) error {
+		if message == nil {
+			return callback(ctx, nil, err)
+		}
+
+		event, err := decodeTypedEvent[T](subscribeBuilder, *message, disallowUnknownFields)
+		if event == nil {
+			// don't send events if consumer didn't subscribe on their event name, or they're filtered out
+			return nil
+		}
+
+		return callback(ctx, event, err)
+	}
+
+	return subscribeBuilder
+}
+
+// decodeTypedEvent decodes the message into a typed event, the event is nil if it isn't subscribed
+func decodeTypedEvent[T any](
+	subscribeBuilder *SubscribeBuilder,
+	message kafka.Message,
+	disallowUnknownFields bool,
+) (*TypedEvent[T], error) {
+	event := &TypedEvent[T]{}
+
+	var typed typedMessage
+	if err := json.Unmarshal(message.Value, &typed); err != nil {
+		event.Topic = subscribeBuilder.eventTopic(message)
+		setMessageFields(&event.Event, message)
+
+		return event, fmt.Errorf("unable to unmarshal event: %w", err)
+	}
+
+	event.Event = typed.Event
+	event.Topic = subscribeBuilder.eventTopic(message)
+	setMessageFields(&event.Event, message)
+
+	if !subscribeBuilder.subscribes(&event.Event) {
+		return nil, nil
+	}
+
+	if len(typed.Payload) == 0 || string(typed.Payload) == "null" {
+		return event, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(typed.Payload))
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(&event.Payload); err != nil {
+		return event, fmt.Errorf("unable to decode payload of event %s: %w", event.EventName, err)
+	}
+
+	return event, nil
+}
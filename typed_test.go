@@ -0,0 +1,137 @@
+/*
+ * Copyright 2019 AccelByte Inc
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type typedPayload struct {
+	UserID  string   `json:"userId"`
+	Balance int64    `json:"balance"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+type typedResult struct {
+	event *TypedEvent[typedPayload]
+	err   error
+}
+
+func subscribeTypedEvents(
+	ctx context.Context,
+	t *testing.T,
+	client Client,
+	topic string,
+	config ...*TypedConfig,
+) <-chan typedResult {
+	t.Helper()
+
+	results := make(chan typedResult, 10)
+	err := client.Register(
+		SubscribeTyped(
+			NewSubscribe().
+				Topic(topic).
+				EventName("testEvent").
+				GroupID(generateID()).
+				Offset(0).
+				Context(ctx),
+			func(ctx context.Context, event *TypedEvent[typedPayload], err error) error {
+				if event != nil {
+					results <- typedResult{event: event, err: err}
+				}
+				return nil
+			},
+			config...))
+	require.NoError(t, err)
+
+	return results
+}
+
+func TestPublishSubscribeTyped(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	payload := typedPayload{UserID: "user-1", Balance: 1<<53 + 1, Tags: []string{"a", "b"}}
+	err := PublishTypedSync(client, NewPublish().Topic(topicName).EventName("testEvent").Key(testKey), payload)
+	require.NoError(t, err)
+
+	results := subscribeTypedEvents(ctx, t, client, topicName)
+
+	select {
+	case result := <-results:
+		require.NoError(t, result.err)
+		assert.Equal(t, payload, result.event.Payload, "numbers shouldn't lose precision")
+		assert.Equal(t, "testEvent", result.event.EventName)
+		assert.Equal(t, topicName, result.event.Topic)
+		assert.Equal(t, testKey, result.event.Key)
+		assert.NotEmpty(t, result.event.ID)
+	case <-ctx.Done():
+		assert.FailNow(t, errorTimeout)
+	}
+}
+
+func TestSubscribeTypedDisallowUnknownFields(t *testing.T) {
+	t.Parallel()
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(timeoutTest)*time.Second)
+	defer done()
+
+	client := createKafkaClient(t)
+	topicName := constructTopicTest()
+	createTestTopic(t, topicName)
+
+	payload := map[string]interface{}{"userId": "user-1", "unknown": true}
+	err := PublishTypedSync(client, NewPublish().Topic(topicName).EventName("testEvent"), payload)
+	require.NoError(t, err)
+
+	lenient := subscribeTypedEvents(ctx, t, client, topicName)
+	strict := subscribeTypedEvents(ctx, t, client, topicName, &TypedConfig{DisallowUnknownFields: true})
+
+	for _, results := range []<-chan typedResult{lenient, strict} {
+		select {
+		case result := <-results:
+			assert.Equal(t, "user-1", result.event.Payload.UserID)
+			if results == strict {
+				assert.ErrorContains(t, result.err, "unknown field", "decode error should be passed to the callback")
+			} else {
+				assert.NoError(t, result.err)
+			}
+		case <-ctx.Done():
+			assert.FailNow(t, errorTimeout)
+		}
+	}
+}
+
+func TestPublishTypedInvalidPayload(t *testing.T) {
+	t.Parallel()
+	client := createMemoryClient(t)
+
+	err := PublishTyped(client, NewPublish().Topic(constructTopicTest()).EventName("testEvent"), 42)
+	assert.Error(t, err, "payload should be a JSON object")
+
+	err = PublishTyped(client, nil, typedPayload{})
+	assert.ErrorIs(t, err, errPubNilEvent)
+}
@@ -17,9 +17,11 @@
 package eventstream
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -34,6 +36,15 @@ func constructTopic(prefix, topic string) string {
 	return topic
 }
 
+// trimTopicPrefix returns the topic name without the prefix
+func trimTopicPrefix(prefix, topic string) string {
+	if prefix != "" {
+		return strings.TrimPrefix(topic, prefix+separator)
+	}
+
+	return topic
+}
+
 // constructGroupID construct groupID or queue group name
 func constructGroupID(prefix, groupID string) string {
 	if groupID == "" {
@@ -66,3 +77,19 @@ func loadEnv(key string) string {
 	}
 	return ""
 }
+
+// waitWithContext waits for the wait group, returns ctx error if ctx is done first
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -36,6 +36,13 @@ var (
 	errInvalidSessionID       = errors.New("sessionID isn't valid")
 	errInvalidTraceID         = errors.New("traceID isn't valid")
 	errInvalidCallback        = errors.New("callback should not be nil")
+	errInvalidDeadLetterTopic = errors.New("dead letter topic isn't valid")
+	errInvalidRetryTopics     = errors.New("retry topics need a group ID and positive delays, without CallbackDelivery")
+	errInvalidConcurrency     = errors.New("concurrency should not be negative, nor used with CallbackDelivery")
+	errInvalidCallbackBatch   = errors.New("CallbackBatch can't be used with CallbackDelivery, Concurrency or RetryTopics")
+	errInvalidPartitionHooks  = errors.New("partition hooks need a group ID")
+	errInvalidTopics          = errors.New("multiple topics and topic patterns need a group ID and a DeadLetterTopic " +
+		"with DeadLetter, without RetryTopics")
 )
 
 var topicRegex *regexp.Regexp = nil
@@ -125,20 +132,24 @@ func validatePublishEvent(publishBuilder *PublishBuilder, strictValidation bool)
 // validateSubscribeEvent validate subscribe event
 func validateSubscribeEvent(subscribeBuilder *SubscribeBuilder) error {
 
-	if isTopicValid := validateTopicEvent(subscribeBuilder.topic); !isTopicValid {
-		logrus.
-			WithField("Topic Name", subscribeBuilder.topic).
-			WithField("Event Name", subscribeBuilder.eventName).
-			Errorf("unable to validate subscribe event. error: invalid topic format")
-		return errInvalidTopicFormat
+	for _, topic := range subscribeBuilder.subscribedTopics() {
+		if isTopicValid := validateTopicEvent(topic); !isTopicValid {
+			logrus.
+				WithField("Topic Name", topic).
+				WithField("Event Name", subscribeBuilder.subscribedEventName()).
+				Errorf("unable to validate subscribe event. error: invalid topic format")
+			return errInvalidTopicFormat
+		}
 	}
 
-	if isEventNameValid := validateTopicEvent(subscribeBuilder.eventName); !isEventNameValid {
-		logrus.
-			WithField("Topic Name", subscribeBuilder.topic).
-			WithField("Event Name", subscribeBuilder.eventName).
-			Errorf("unable to validate subscribe event. error: invalid event name format")
-		return errInvalidEventNameFormat
+	for _, eventName := range subscribeBuilder.subscribedEventNames() {
+		if isEventNameValid := validateEventNamePattern(eventName); !isEventNameValid {
+			logrus.
+				WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+				WithField("Event Name", eventName).
+				Errorf("unable to validate subscribe event. error: invalid event name format")
+			return errInvalidEventNameFormat
+		}
 	}
 
 	subscribeEvent := struct {
@@ -148,8 +159,8 @@ func validateSubscribeEvent(subscribeBuilder *SubscribeBuilder) error {
 		Callback    func(ctx context.Context, event *Event, err error) error
 		CallbackRaw func(ctx context.Context, msg []byte, err error) error
 	}{
-		Topic:       subscribeBuilder.topic,
-		EventName:   subscribeBuilder.eventName,
+		Topic:       subscribeBuilder.subscribedTopic(),
+		EventName:   subscribeBuilder.subscribedEventName(),
 		GroupID:     subscribeBuilder.groupID,
 		Callback:    subscribeBuilder.callback,
 		CallbackRaw: subscribeBuilder.callbackRaw,
@@ -158,16 +169,55 @@ func validateSubscribeEvent(subscribeBuilder *SubscribeBuilder) error {
 	_, err := validator.ValidateStruct(subscribeEvent)
 	if err != nil {
 		logrus.
-			WithField("Topic Name", subscribeBuilder.topic).
-			WithField("Event Name", subscribeBuilder.eventName).
+			WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+			WithField("Event Name", subscribeBuilder.subscribedEventName()).
 			Errorf("unable to validate subscribe event. error : %v", err)
 		return err
 	}
 
-	if subscribeEvent.Callback == nil && subscribeEvent.CallbackRaw == nil {
+	if subscribeEvent.Callback == nil && subscribeEvent.CallbackRaw == nil && subscribeBuilder.callbackTyped == nil &&
+		subscribeBuilder.callbackDelivery == nil && subscribeBuilder.callbackBatch == nil &&
+		len(subscribeBuilder.handlers) == 0 {
 		return errInvalidCallback
 	}
 
+	if subscribeBuilder.callbackBatch != nil && (subscribeBuilder.callbackDelivery != nil ||
+		subscribeBuilder.concurrency > 1 || len(subscribeBuilder.retryDelays) > 0) {
+		return errInvalidCallbackBatch
+	}
+
+	for _, delay := range subscribeBuilder.retryDelays {
+		if delay <= 0 || subscribeBuilder.groupID == "" || subscribeBuilder.callbackDelivery != nil {
+			return errInvalidRetryTopics
+		}
+	}
+
+	if subscribeBuilder.isMultiTopic() && (subscribeBuilder.groupID == "" || len(subscribeBuilder.retryDelays) > 0 ||
+		(subscribeBuilder.deadLetter && subscribeBuilder.deadLetterTopic == "")) {
+		return errInvalidTopics
+	}
+
+	if subscribeBuilder.hasPartitionHooks() && subscribeBuilder.groupID == "" {
+		return errInvalidPartitionHooks
+	}
+
+	if err := subscribeBuilder.startAt.validate(subscribeBuilder); err != nil {
+		return err
+	}
+
+	if subscribeBuilder.concurrency < 0 || (subscribeBuilder.concurrency > 1 && subscribeBuilder.callbackDelivery != nil) {
+		return errInvalidConcurrency
+	}
+
+	if deadLetterTopic := subscribeBuilder.deadLetterTopicName(); deadLetterTopic != "" &&
+		(!validateTopicEvent(deadLetterTopic) || subscribeBuilder.subscribesTopic(deadLetterTopic)) {
+		logrus.
+			WithField("Topic Name", subscribeBuilder.subscribedTopic()).
+			WithField("Event Name", subscribeBuilder.subscribedEventName()).
+			Errorf("unable to validate subscribe event. error: invalid dead letter topic %s", deadLetterTopic)
+		return errInvalidDeadLetterTopic
+	}
+
 	return nil
 }
 